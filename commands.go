@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/UKHomeOffice/smilodon/pkg/smilodon"
+)
+
+// command is a smilodon subcommand. Local commands, such as those talking to
+// the running daemon, need no provider and are run without a reconciler.
+type command struct {
+	usage string
+	run   func(ctx context.Context, r *smilodon.Reconciler, args []string) error
+	local bool
+}
+
+var commands = map[string]command{
+	"run":          {"reconcile periodically until stopped (default)", runCmd, false},
+	"bootstrap":    {"reconcile until the node is ready, then exit, for init containers and oneshot units", bootstrapCmd, false},
+	"status":       {"print the node held by this instance", statusCmd, false},
+	"list":         {"list all nodes and the instances holding them", listCmd, false},
+	"attach":       {"attach the node given by -node-id to this instance", attachCmd, false},
+	"detach":       {"unmount and detach the node held by this instance", detachCmd, false},
+	"decommission": {"stop the daemon, detach the node and remove output files", decommissionCmd, false},
+	"preflight":    {"dry-run the required EC2 actions and report missing permissions, AWS only", preflightCmd, false},
+	"provision":    {"create the volumes and network interfaces of -count nodes, AWS only", provisionCmd, false},
+	"install-unit": {"render and install systemd units running smilodon with the current options", installUnitCmd, true},
+	"dump":         {"print the internal state of the running daemon as JSON", dumpCmd, true},
+	"reconcile":    {"make the running daemon reconcile right away", controlCmd(smilodon.ControlReconcile), true},
+	"release":      {"make the running daemon release its node", controlCmd(smilodon.ControlRelease), true},
+	"pause":        {"pause the reconcile passes of the running daemon", controlCmd(smilodon.ControlPause), true},
+	"resume":       {"resume the reconcile passes of the running daemon", controlCmd(smilodon.ControlResume), true},
+}
+
+// commandNames lists commands in the order they are printed in the usage.
+var commandNames = []string{"run", "bootstrap", "status", "list", "attach", "detach", "decommission", "preflight", "provision", "install-unit", "dump", "reconcile", "release", "pause", "resume"}
+
+func runCmd(ctx context.Context, r *smilodon.Reconciler, args []string) error {
+	return runDaemons(ctx, []daemon{{opts.profile, r, opts}})
+}
+
+func bootstrapCmd(ctx context.Context, r *smilodon.Reconciler, args []string) error {
+	fs := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 0, "how long to wait for the node to be ready, 0 waits forever")
+	interval := fs.Duration("interval", 10*time.Second, "interval between reconcile passes")
+	fs.Parse(args)
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	return r.Bootstrap(ctx, *interval)
+}
+
+// reloadOnHangup reloads the config of the profiles of ds on SIGHUP until ctx
+// is done.
+func reloadOnHangup(ctx context.Context, ds []daemon) {
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	defer signal.Stop(hups)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hups:
+		}
+		log.Println("Received SIGHUP, reloading configuration.")
+		for _, d := range ds {
+			if err := reloadConfig(d.profile); err != nil {
+				log.Printf("Failed to reload configuration: %q.\n", err)
+				continue
+			}
+			if p, ok := d.r.Provider().(*smilodon.AWSProvider); ok {
+				p.ReloadFilters(opts.filters, awsOpts)
+			} else {
+				log.Printf("Filters of the %s provider are not reloadable, they take effect on restart.\n", opts.provider)
+			}
+			if err := d.r.Reload(cfg); err != nil {
+				log.Printf("Failed to reload configuration: %q.\n", err)
+			}
+		}
+	}
+}
+
+// status is the JSON output of the status command.
+type status struct {
+	Instance   smilodon.Instance `json:"instance"`
+	Node       smilodon.Node     `json:"node"`
+	Device     string            `json:"device"`
+	MountPoint string            `json:"mount_point"`
+	Mounted    bool              `json:"mounted"`
+	// FastSnapshotRestores are the fast snapshot restore states of the
+	// latest scheduled snapshot, if any.
+	FastSnapshotRestores map[string]string `json:"fast_snapshot_restores,omitempty"`
+}
+
+func statusCmd(ctx context.Context, r *smilodon.Reconciler, args []string) error {
+	n, err := r.Status(ctx)
+	if err != nil {
+		return err
+	}
+	i := r.Instance()
+	fsr, err := r.FastSnapshotRestores(ctx, n)
+	if err != nil {
+		log.Printf("Failed to check fast snapshot restores: %q.\n", err)
+	}
+	if opts.output == "json" {
+		return printJSON(status{i, n, r.BlockDevice(), r.MountPoint(), r.Mounted(), fsr})
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "Instance:\t%s (%s)\n", i.ID, i.AZ)
+	fmt.Fprintf(w, "Node ID:\t%s\n", orDash(n.ID))
+	if v := n.Volume; v != nil {
+		fmt.Fprintf(w, "Volume:\t%s (node %s)\n", v.ID, v.NodeID)
+	} else {
+		fmt.Fprintf(w, "Volume:\t-\n")
+	}
+	if ni := n.NetworkInterface; ni != nil {
+		fmt.Fprintf(w, "Network interface:\t%s (node %s, %s)\n", ni.ID, ni.NodeID, ni.IPAddress)
+	} else {
+		fmt.Fprintf(w, "Network interface:\t-\n")
+	}
+	fmt.Fprintf(w, "Device:\t%s\n", r.BlockDevice())
+	fmt.Fprintf(w, "Mounted:\t%t (%s)\n", r.Mounted(), r.MountPoint())
+	if len(fsr) > 0 {
+		var azs []string
+		for az, state := range fsr {
+			azs = append(azs, az+" "+state)
+		}
+		sort.Strings(azs)
+		fmt.Fprintf(w, "Fast snapshot restores:\t%s\n", strings.Join(azs, ", "))
+	}
+	return w.Flush()
+}
+
+func listCmd(ctx context.Context, r *smilodon.Reconciler, args []string) error {
+	ns, err := r.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	if opts.output == "json" {
+		if ns == nil {
+			ns = []smilodon.Node{}
+		}
+		return printJSON(ns)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE ID\tVOLUME\tATTACHED TO\tNETWORK INTERFACE\tIP ADDRESS\tATTACHED TO")
+	for _, n := range ns {
+		var vid, vto, nid, ip, nto string
+		if v := n.Volume; v != nil {
+			vid, vto = v.ID, v.AttachedTo
+		}
+		if ni := n.NetworkInterface; ni != nil {
+			nid, ip, nto = ni.ID, ni.IPAddress, ni.AttachedTo
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", n.ID, orDash(vid), orDash(vto), orDash(nid), orDash(ip), orDash(nto))
+	}
+	return w.Flush()
+}
+
+func attachCmd(ctx context.Context, r *smilodon.Reconciler, args []string) error {
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	id := fs.String("node-id", "", "node ID to attach")
+	fs.Parse(args)
+	if *id == "" {
+		return fmt.Errorf("-node-id is required")
+	}
+	return r.Attach(ctx, *id)
+}
+
+func detachCmd(ctx context.Context, r *smilodon.Reconciler, args []string) error {
+	return r.Detach(ctx)
+}
+
+func decommissionCmd(ctx context.Context, r *smilodon.Reconciler, args []string) error {
+	fs := flag.NewFlagSet("decommission", flag.ExitOnError)
+	removeNodeID := fs.Bool("remove-node-id", false, "remove the node ID tag of the volume and network interface, AWS only")
+	fs.Parse(args)
+	if opts.pidFile != "" {
+		if err := stopDaemon(opts.pidFile); err != nil {
+			return err
+		}
+	}
+	n, err := r.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if err := r.Decommission(ctx); err != nil {
+		return err
+	}
+	if !*removeNodeID {
+		return nil
+	}
+	p, ok := r.Provider().(*smilodon.AWSProvider)
+	if !ok {
+		return fmt.Errorf("-remove-node-id is only supported on AWS")
+	}
+	var ids []string
+	if n.Volume != nil {
+		ids = append(ids, n.Volume.ID)
+	}
+	if n.NetworkInterface != nil {
+		ids = append(ids, n.NetworkInterface.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return p.RemoveNodeID(ctx, ids...)
+}
+
+func preflightCmd(ctx context.Context, r *smilodon.Reconciler, args []string) error {
+	return preflight(ctx, r, true)
+}
+
+// preflight checks the EC2 permissions of the provider of r, printing the
+// result of every check if print is set, and fails if any is denied.
+func preflight(ctx context.Context, r *smilodon.Reconciler, print bool) error {
+	p, ok := r.Provider().(*smilodon.AWSProvider)
+	if !ok {
+		return fmt.Errorf("preflight checks are only supported on AWS")
+	}
+	checks := p.Preflight(ctx, r.BlockDevice())
+	var denied []string
+	for _, c := range checks {
+		switch c.Result {
+		case smilodon.PermissionDenied:
+			denied = append(denied, c.Action)
+		case smilodon.PermissionUnknown:
+			log.Printf("Could not check %s: %q.\n", c.Action, c.Error)
+		}
+	}
+	if print {
+		if opts.output == "json" {
+			if err := printJSON(checks); err != nil {
+				return err
+			}
+		} else {
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "ACTION\tRESULT")
+			for _, c := range checks {
+				fmt.Fprintf(w, "%s\t%s\n", c.Action, c.Result)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("%w: missing %s", smilodon.ErrPermissionDenied, strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+func provisionCmd(ctx context.Context, r *smilodon.Reconciler, args []string) error {
+	fs := flag.NewFlagSet("provision", flag.ExitOnError)
+	var o smilodon.ProvisionOptions
+	fs.IntVar(&o.Count, "count", 0, "number of nodes to provision")
+	fs.IntVar(&o.FirstNodeID, "first-node-id", 1, "node ID of the first node, further nodes are numbered consecutively")
+	subnets := fs.String("subnets", "", "comma-delimited list of subnets network interfaces are created in round-robin, volumes in their availability zones")
+	fs.Int64Var(&o.Size, "size", 0, "volume size in GiB, optional with -snapshots")
+	fs.StringVar(&o.VolumeType, "volume-type", "gp3", "volume type")
+	fs.Int64Var(&o.IOPS, "iops", 0, "provisioned volume IOPS, if not 0")
+	snapshots := fs.String("snapshots", "", "comma-delimited list of snapshots volumes are created from, one for all volumes or one per node")
+	fs.Parse(args)
+	p, ok := r.Provider().(*smilodon.AWSProvider)
+	if !ok {
+		return fmt.Errorf("provisioning is only supported on AWS")
+	}
+	if *subnets != "" {
+		o.Subnets = strings.Split(*subnets, ",")
+	}
+	if *snapshots != "" {
+		o.Snapshots = strings.Split(*snapshots, ",")
+	}
+	ns, err := p.Provision(ctx, o)
+	if opts.output == "json" {
+		if ns == nil {
+			ns = []smilodon.Node{}
+		}
+		if perr := printJSON(ns); err == nil {
+			err = perr
+		}
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE ID\tVOLUME\tNETWORK INTERFACE\tIP ADDRESS")
+	for _, n := range ns {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", n.ID, n.Volume.ID, n.NetworkInterface.ID, orDash(n.NetworkInterface.IPAddress))
+	}
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// printJSON prints v as indented JSON.
+func printJSON(v interface{}) error {
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
+
+// orDash returns s or "-" if s is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/UKHomeOffice/smilodon/pkg/smilodon"
+)
+
+// cmdLineFlags holds the names of flags given on the command line, which
+// take precedence over environment variables and the config file.
+var cmdLineFlags = map[string]bool{}
+
+// envFlags holds the names of flags given as environment variables, which
+// take precedence over the config file.
+var envFlags = map[string]bool{}
+
+// fileFlags holds the names of flags set by the config file, which take
+// precedence over the remote config.
+var fileFlags = map[string]bool{}
+
+// envPrefix is the prefix of the environment variables of flags.
+const envPrefix = "SMILODON_"
+
+// envName returns the environment variable of flag name, for example
+// SMILODON_POLL_INTERVAL for poll-interval.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// applyEnv sets flags not given on the command line from their environment
+// variables. Flags which can be given multiple times take a comma-delimited
+// list.
+func applyEnv() error {
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || cmdLineFlags[f.Name] || err != nil {
+			return
+		}
+		vs := []string{v}
+		if _, ok := f.Value.(*stringSlice); ok {
+			vs = strings.Split(v, ",")
+		}
+		for _, v := range vs {
+			if e := f.Value.Set(v); e != nil {
+				err = fmt.Errorf("%s: %v", envName(f.Name), e)
+				return
+			}
+		}
+		envFlags[f.Name] = true
+	})
+	return err
+}
+
+// applyConfigFile sets flags of profile from config file f, which holds a
+// flag per line as name=value, or just name for boolean flags. Empty lines and
+// lines starting with # are ignored. Lines following a [name] header only
+// apply to profile name, those before the first header to all profiles.
+func applyConfigFile(f, profile string) error {
+	file, err := os.Open(f)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	s := bufio.NewScanner(file)
+	section := ""
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		if name, ok := sectionName(l); ok {
+			section = name
+			continue
+		}
+		if section != "" && section != profile {
+			continue
+		}
+		name, value := l, "true"
+		if i := strings.Index(l, "="); i >= 0 {
+			name, value = strings.TrimSpace(l[:i]), strings.TrimSpace(l[i+1:])
+		}
+		name = strings.TrimLeft(name, "-")
+		if cmdLineFlags[name] || envFlags[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %v", f, n, err)
+		}
+		fileFlags[name] = true
+	}
+	return s.Err()
+}
+
+// sectionName returns the profile name of config file line l if it is a
+// [name] header.
+func sectionName(l string) (string, bool) {
+	if !strings.HasPrefix(l, "[") || !strings.HasSuffix(l, "]") {
+		return "", false
+	}
+	return strings.TrimSpace(l[1 : len(l)-1]), true
+}
+
+// configProfiles returns the profile names of config file f in order.
+func configProfiles(f string) ([]string, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var names []string
+	seen := map[string]bool{}
+	s := bufio.NewScanner(file)
+	for n := 1; s.Scan(); n++ {
+		name, ok := sectionName(strings.TrimSpace(s.Text()))
+		if !ok {
+			continue
+		}
+		if name == "" || seen[name] {
+			return nil, fmt.Errorf("%s:%d: invalid or duplicate profile %q", f, n, name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, s.Err()
+}
+
+// profilePath returns path p of profile name, which has the profile name
+// appended to its base name, for example /run/smilodon/control-kafka.sock.
+func profilePath(p, name string) string {
+	if p == "" || name == "" {
+		return p
+	}
+	ext := filepath.Ext(p)
+	return strings.TrimSuffix(p, ext) + "-" + name + ext
+}
+
+// applyProfilePaths makes the control socket and dump file of profile name
+// distinct from those of other profiles.
+func applyProfilePaths(name string) {
+	opts.socket = profilePath(opts.socket, name)
+	opts.dumpFile = profilePath(opts.dumpFile, name)
+}
+
+// applyRemoteConfig sets flags of profile not given otherwise from the SSM
+// parameters under -config-ssm-prefix and the Secrets Manager secret
+// -config-secret-id. Names prefixed with a profile name only apply to that
+// profile, and take precedence over unprefixed ones.
+func applyRemoteConfig(profile string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	flags, err := smilodon.LoadRemoteConfig(ctx, opts.configSSMPrefix, opts.configSecretID, awsOpts)
+	if err != nil {
+		return err
+	}
+	values := map[string]string{}
+	for k, v := range flags {
+		if !strings.Contains(k, "/") {
+			values[k] = v
+		}
+	}
+	for k, v := range flags {
+		if i := strings.LastIndex(k, "/"); i >= 0 && k[:i] == profile {
+			values[k[i+1:]] = v
+		}
+	}
+	for name, v := range values {
+		f := flag.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("remote config: unknown flag %q", name)
+		}
+		if cmdLineFlags[name] || envFlags[name] || fileFlags[name] {
+			continue
+		}
+		vs := []string{v}
+		if _, ok := f.Value.(*stringSlice); ok {
+			vs = strings.Split(v, ",")
+		}
+		for _, v := range vs {
+			if err := f.Value.Set(v); err != nil {
+				return fmt.Errorf("remote config: %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// reloadConfig resets all flags to their defaults and parses the command
+// line, the environment, the config file and the remote config of profile
+// again.
+func reloadConfig(profile string) error {
+	cfg = smilodon.DefaultConfig()
+	awsOpts = smilodon.AWSOptions{}
+	flag.VisitAll(func(f *flag.Flag) {
+		// Slices are reset above, setting them would append.
+		if _, ok := f.Value.(*stringSlice); !ok {
+			f.Value.Set(f.DefValue)
+		}
+	})
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		return err
+	}
+	if err := applyEnv(); err != nil {
+		return err
+	}
+	fileFlags = map[string]bool{}
+	if opts.configFile != "" {
+		if err := applyConfigFile(opts.configFile, profile); err != nil {
+			return err
+		}
+	}
+	if err := applyRemoteConfig(profile); err != nil {
+		return err
+	}
+	applyProfilePaths(profile)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/UKHomeOffice/smilodon/pkg/smilodon"
+)
+
+// controlCmd returns a command carrying out control operation op with the
+// running daemon, printing its state afterwards.
+func controlCmd(op string) func(context.Context, *smilodon.Reconciler, []string) error {
+	return func(ctx context.Context, r *smilodon.Reconciler, args []string) error {
+		return callControl(ctx, http.MethodPost, op)
+	}
+}
+
+// callControl sends a request of method to path p of the control API and
+// prints the JSON response.
+func callControl(ctx context.Context, method, p string) error {
+	if opts.socket == "" {
+		return fmt.Errorf("-control-socket is not set")
+	}
+	c := &http.Client{
+		// Released nodes are detached, which may take a while.
+		Timeout: 5 * time.Minute,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", opts.socket)
+			},
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://smilodon/"+p, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("smilodon daemon is not reachable: %v", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(b, &e) == nil && e.Error != "" {
+			return fmt.Errorf("%s", e.Error)
+		}
+		return fmt.Errorf("control API returned %s", resp.Status)
+	}
+	_, err = os.Stdout.Write(b)
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/UKHomeOffice/smilodon/pkg/smilodon"
+)
+
+// dumpOnSignal writes the state of the reconcilers of ds to their dump files
+// on SIGUSR2 until ctx is done.
+func dumpOnSignal(ctx context.Context, ds []daemon) {
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	defer signal.Stop(usr2)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-usr2:
+		}
+		for _, d := range ds {
+			if err := writeDump(d.opts.dumpFile, d.r.State()); err != nil {
+				log.Printf("Failed to write state dump: %q.\n", err)
+				continue
+			}
+			log.Printf("Wrote state dump to %q.\n", d.opts.dumpFile)
+		}
+	}
+}
+
+// writeDump writes state s to file f as indented JSON, readable by root only.
+func writeDump(f string, s smilodon.State) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		return err
+	}
+	t := f + ".tmp"
+	if err := ioutil.WriteFile(t, append(b, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(t, f)
+}
+
+func dumpCmd(ctx context.Context, r *smilodon.Reconciler, args []string) error {
+	if opts.socket != "" {
+		if _, err := os.Stat(opts.socket); err == nil {
+			return callControl(ctx, http.MethodGet, "state")
+		}
+	}
+	pid, err := daemonPid(opts.pidFile)
+	if err != nil {
+		return err
+	}
+	if pid == 0 {
+		return fmt.Errorf("smilodon daemon is not running")
+	}
+	var since time.Time
+	if fi, err := os.Stat(opts.dumpFile); err == nil {
+		since = fi.ModTime()
+	}
+	if err := syscall.Kill(pid, syscall.SIGUSR2); err != nil {
+		return err
+	}
+	for i := 0; i < 20; i++ {
+		time.Sleep(250 * time.Millisecond)
+		if fi, err := os.Stat(opts.dumpFile); err == nil && fi.ModTime().After(since) {
+			b, err := ioutil.ReadFile(opts.dumpFile)
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(b)
+			return err
+		}
+	}
+	return fmt.Errorf("smilodon daemon with pid %d did not write %q", pid, opts.dumpFile)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/UKHomeOffice/smilodon/pkg/smilodon"
+)
+
+// Log targets.
+const (
+	logTargetAuto    = "auto"
+	logTargetJournal = "journal"
+	logTargetStderr  = "stderr"
+)
+
+// setupLogging directs the log to target. The journal timestamps entries
+// itself, so log lines are not prefixed with the time then.
+func setupLogging(target string) error {
+	switch target {
+	case logTargetStderr:
+		return nil
+	case logTargetAuto:
+		if !smilodon.JournalConnected() {
+			return nil
+		}
+	case logTargetJournal:
+	default:
+		return fmt.Errorf("unknown log target %q", target)
+	}
+	w, err := smilodon.NewJournalWriter("smilodon")
+	if err != nil {
+		if target == logTargetAuto {
+			log.Printf("Failed to connect to the journal, logging to stderr: %q.\n", err)
+			return nil
+		}
+		return err
+	}
+	log.SetOutput(w)
+	log.SetFlags(0)
+	return nil
+}
@@ -1,268 +1,398 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"net"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/UKHomeOffice/smilodon/pkg/smilodon"
 )
 
+// stringSlice is a flag.Value which can be given multiple times.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 type cmdLineOpts struct {
-	filters     string
-	blockDevice string
-	createFs    bool
-	fsType      string
-	mountFs     bool
-	mountPoint  string
-	envFile     string
-	help        bool
-	version     bool
+	provider        string
+	filters         string
+	output          string
+	pidFile         string
+	dumpFile        string
+	socket          string
+	configFile      string
+	profile         string
+	configSSMPrefix string
+	configSecretID  string
+	pprofPort       int
+	logTarget       string
+	user            string
+	capabilities    string
+	help            bool
+	version         bool
+
+	disableSourceDestCheck bool
+	restoreSourceDestCheck bool
+	preflight              bool
 }
 
 var (
-	opts              cmdLineOpts
-	region            string
-	ec2c              *ec2.EC2
-	filters           []*ec2.Filter
-	volumeAttachTries int
+	opts    cmdLineOpts
+	cfg     = smilodon.DefaultConfig()
+	awsOpts smilodon.AWSOptions
 )
 
 func init() {
+	flag.StringVar(&opts.provider, "provider", "aws", "cloud provider: aws, gcp, azure or openstack")
 	flag.StringVar(&opts.filters, "filters", "", "a comma-delimited list of filters. For example --filters='tag-key=Env,tag:Profile=foo'")
-	flag.StringVar(&opts.blockDevice, "block-device", "/dev/xvde", "linux block device path")
-	flag.BoolVar(&opts.createFs, "create-file-system", false, "whether to create a file system")
-	flag.StringVar(&opts.fsType, "file-system-type", "ext4", "file system type")
-	flag.BoolVar(&opts.mountFs, "mount-fs", false, "whether to mount a file system")
-	flag.StringVar(&opts.mountPoint, "mount-point", "/data", "mount point path")
-	flag.StringVar(&opts.envFile, "env-file", "/run/smilodon/environment", "environment file path")
+	flag.StringVar(&cfg.NodeIDFormat, "node-id-format", cfg.NodeIDFormat, "node ID format: string or numeric. Numeric node IDs are the last number of the raw value, so 'etcd-03' matches '3'")
+	flag.StringVar(&cfg.NodeSelection, "node-selection", cfg.NodeSelection, "how to pick a node ID without one: first, lowest, random or preferred")
+	flag.Var((*stringSlice)(&cfg.PreferredNodeIDs), "preferred-node-id", "node ID to try first with -node-selection=preferred, can be given multiple times")
+	flag.DurationVar(&cfg.StickyTimeout, "sticky-timeout", cfg.StickyTimeout, "how long to wait to re-claim the node ID last held by the instance before picking another one, 0 disables it")
+	flag.DurationVar(&cfg.ReadyDeadline, "ready-deadline", cfg.ReadyDeadline, "how long the run command waits to acquire a node before exiting with an error, 0 waits forever")
+	flag.StringVar(&cfg.StateFile, "state-file", cfg.StateFile, "file recording the node ID last held by the instance for -sticky-timeout")
+	flag.StringVar(&awsOpts.NodeIDTag, "node-id-tag", "NodeID", "tag key holding the node ID")
+	flag.StringVar(&awsOpts.VolumeNodeIDSource, "volume-node-id-source", "tag", "where to read volume node IDs from: tag or name")
+	flag.StringVar(&awsOpts.InterfaceNodeIDSource, "eni-node-id-source", "tag", "where to read network interface node IDs from: tag or description")
+	flag.StringVar(&awsOpts.VolumeFilters, "volume-filters", "", "a comma-delimited list of EC2 filters for volumes, defaults to -filters")
+	flag.StringVar(&awsOpts.InterfaceFilters, "eni-filters", "", "a comma-delimited list of EC2 filters for network interfaces, defaults to -filters")
+	flag.DurationVar(&awsOpts.Timeout, "aws-timeout", 30*time.Second, "timeout of every EC2 API call, including retries")
+	flag.Float64Var(&awsOpts.RateLimit, "aws-rate-limit", 0, "average number of EC2 API calls per second, 0 means no limit")
+	flag.IntVar(&awsOpts.RateBurst, "aws-rate-burst", 10, "maximum burst of EC2 API calls with -aws-rate-limit")
+	flag.BoolVar(&awsOpts.AttachmentTags, "attachment-tags", awsOpts.AttachmentTags, "whether to tag attached volumes and network interfaces with the instance ID, hostname and attachment time")
+	flag.BoolVar(&awsOpts.RequireEncrypted, "require-encrypted", awsOpts.RequireEncrypted, "whether to skip unencrypted volumes and encrypt volumes smilodon creates")
+	flag.StringVar(&awsOpts.KMSKeyID, "kms-key-id", awsOpts.KMSKeyID, "KMS key ID, ARN or alias which candidate volumes must be encrypted with and volumes created with -require-encrypted are encrypted with, defaults to the account default key")
+	flag.StringVar(&awsOpts.ManagedByTag, "managed-by-tag", awsOpts.ManagedByTag, "ownership tag of the form key=value, for example managed-by=smilodon, which volumes and network interfaces must carry to be attached, detached or modified, and which resources smilodon creates are tagged with")
+	flag.Int64Var(&awsOpts.InterfaceDeviceIndex, "eni-device-index", 1, "preferred device index the network interface is attached at, the next free one is used if it is taken")
+	flag.BoolVar(&awsOpts.InterfaceDeleteOnTermination, "eni-delete-on-termination", awsOpts.InterfaceDeleteOnTermination, "whether the attached network interface is deleted when the instance terminates, which is corrected on every pass")
+	flag.StringVar(&awsOpts.SecurityGroups, "security-groups", awsOpts.SecurityGroups, "a comma-delimited list of security group IDs and tag:<key>=<value> lookups the attached network interface is kept in")
+	flag.BoolVar(&awsOpts.Debug, "debug-aws", awsOpts.Debug, "whether to log every AWS request attempt with its parameters, result, request ID and retries, without credentials")
+	flag.StringVar(&awsOpts.AuditLog, "audit-log", awsOpts.AuditLog, "file to append every mutating AWS call to as a JSON line, with its parameters, result and request ID")
+	flag.StringVar(&awsOpts.Endpoint, "aws-endpoint", os.Getenv("SMILODON_AWS_ENDPOINT"), "EC2 endpoint URL override, for example http://localhost:4566 for LocalStack. Defaults to $SMILODON_AWS_ENDPOINT")
+	flag.StringVar(&awsOpts.AssumeRoleARN, "assume-role-arn", "", "IAM role ARN to assume for AWS API calls, for example to manage resources in another account")
+	flag.StringVar(&awsOpts.ExternalID, "assume-role-external-id", "", "external ID to pass when assuming -assume-role-arn")
+	flag.StringVar(&awsOpts.Region, "aws-region", os.Getenv("SMILODON_AWS_REGION"), "AWS region, defaults to $SMILODON_AWS_REGION or the instance region")
+	flag.StringVar(&awsOpts.Profile, "aws-profile", os.Getenv("SMILODON_AWS_PROFILE"), "shared config profile, defaults to $SMILODON_AWS_PROFILE")
+	flag.StringVar(&awsOpts.AccessKeyID, "aws-access-key-id", os.Getenv("SMILODON_AWS_ACCESS_KEY_ID"), "static AWS access key ID, defaults to $SMILODON_AWS_ACCESS_KEY_ID")
+	flag.StringVar(&awsOpts.SecretAccessKey, "aws-secret-access-key", os.Getenv("SMILODON_AWS_SECRET_ACCESS_KEY"), "static AWS secret access key, defaults to $SMILODON_AWS_SECRET_ACCESS_KEY")
+	flag.StringVar(&cfg.BlockDevice, "block-device", cfg.BlockDevice, "linux block device path")
+	flag.BoolVar(&cfg.AutoDevice, "auto-device", cfg.AutoDevice, "whether to attach the volume as the next free device name if -block-device is taken")
+	flag.DurationVar(&cfg.AttachTimeout, "attach-timeout", cfg.AttachTimeout, "how long to wait for the block device, or the network interface with -iface-mode=static, to appear after attaching it")
+	flag.DurationVar(&cfg.AttachTimeout, "device-timeout", cfg.AttachTimeout, "deprecated alias of -attach-timeout")
+	flag.IntVar(&cfg.AttachRetries, "attach-retries", cfg.AttachRetries, "number of passes to wait for the matching volume or network interface before releasing the attached one, 0 waits forever")
+	flag.BoolVar(&cfg.CreateFs, "create-file-system", cfg.CreateFs, "whether to create a file system")
+	flag.StringVar(&cfg.FsType, "file-system-type", cfg.FsType, "file system type")
+	flag.BoolVar(&cfg.RelocateVolumes, "relocate-volumes", cfg.RelocateVolumes, "whether to recreate the volume of a free node ID from a snapshot when it is only found in another availability zone")
+	flag.DurationVar(&cfg.SnapshotInterval, "snapshot-interval", cfg.SnapshotInterval, "interval between snapshots of the attached volume, for example 24h. Disabled by default")
+	flag.IntVar(&cfg.SnapshotRetain, "snapshot-retain", cfg.SnapshotRetain, "number of completed scheduled snapshots to keep")
+	flag.Var((*stringSlice)(&cfg.FastSnapshotRestoreAZs), "fast-snapshot-restore-az", "availability zone to enable fast snapshot restores of the latest scheduled snapshot in, can be given multiple times")
+	flag.BoolVar(&cfg.ModifyVolume, "modify-volume", cfg.ModifyVolume, "whether to modify the attached volume to the desired type, size and performance and grow the file system")
+	flag.StringVar(&cfg.VolumeType, "volume-type", cfg.VolumeType, "desired volume type, for example gp3")
+	flag.Int64Var(&cfg.VolumeSize, "volume-size", cfg.VolumeSize, "desired volume size in GiB, volumes are never shrunk")
+	flag.Int64Var(&cfg.VolumeIOPS, "volume-iops", cfg.VolumeIOPS, "desired volume IOPS")
+	flag.Int64Var(&cfg.VolumeThroughput, "volume-throughput", cfg.VolumeThroughput, "desired volume throughput in MiB/s")
+	flag.Int64Var(&cfg.MinVolumeIOPS, "min-volume-iops", cfg.MinVolumeIOPS, "minimum IOPS the attached volume is required to have, 0 disables the check")
+	flag.Int64Var(&cfg.MinVolumeThroughput, "min-volume-throughput", cfg.MinVolumeThroughput, "minimum throughput in MiB/s the attached volume is required to have, 0 disables the check")
+	flag.StringVar(&cfg.VolumeTypes, "volume-types", cfg.VolumeTypes, "comma-delimited list of volume types the attached volume is required to be of, for example gp3,io2")
+	flag.StringVar(&cfg.VolumeRequirementAction, "volume-requirement-action", cfg.VolumeRequirementAction, "what to do with an attached volume not meeting -min-volume-iops, -min-volume-throughput or -volume-types: warn or modify")
+	flag.Var((*stringSlice)(&cfg.AliasIPs), "alias-ip", "extra IP address to assign to the attached network interface, can be given multiple times")
+	flag.BoolVar(&cfg.AliasSecondaryIPs, "alias-secondary-ips", cfg.AliasSecondaryIPs, "whether to assign the secondary IP addresses of the attached network interface to it")
+	flag.StringVar(&cfg.IOScheduler, "io-scheduler", cfg.IOScheduler, "I/O scheduler set on the block device, for example none")
+	flag.IntVar(&cfg.ReadAheadKB, "read-ahead-kb", cfg.ReadAheadKB, "read-ahead in KiB set on the block device, 0 leaves it untouched")
+	flag.IntVar(&cfg.NrRequests, "nr-requests", cfg.NrRequests, "request queue size set on the block device, 0 leaves it untouched")
+	flag.BoolVar(&cfg.Partition, "partition", cfg.Partition, "whether to create a GPT with a single partition on the block device and use the partition, for example /dev/nvme1n1p1, for the file system")
+	flag.BoolVar(&cfg.ForceMkfs, "force-mkfs", cfg.ForceMkfs, "whether to create a file system over existing file system, RAID, LVM or partition table signatures, destroying their data")
+	flag.StringVar(&cfg.MkfsOptions, "mkfs-options", cfg.MkfsOptions, "extra options passed to mkfs, for example '-m 0 -E lazy_itable_init=0' for ext4")
+	flag.Var((*stringSlice)(&cfg.BindMounts), "bind-mount", "path to bind mount the mount point, or a directory beneath it, to, as target[=source], for example '/var/lib/kafka=kafka', can be given multiple times")
+	flag.StringVar(&cfg.MountPropagation, "mount-propagation", cfg.MountPropagation, "propagation of the mount point and -bind-mount targets: shared, rshared, slave, rslave, private or rprivate, empty leaves it as is")
+	flag.Var((*stringSlice)(&cfg.MountDirs), "mount-dir", "directory to create beneath the mount point if missing, as path[=mode[:user:group]], for example 'kafka/logs=0750:kafka:kafka', can be given multiple times")
+	flag.BoolVar(&cfg.Discard, "discard", cfg.Discard, "whether to mount the file system with the discard option")
+	flag.StringVar(&cfg.HostRoot, "host-root", cfg.HostRoot, "directory the host root file system is mounted at when running in a privileged container sharing the host PID namespace, for example /host")
+	flag.BoolVar(&cfg.WarmUp, "warm-up", cfg.WarmUp, "read the whole volume in the background once after attaching it, if it was restored from a snapshot")
+	flag.IntVar(&cfg.WarmUpParallel, "warm-up-parallel", cfg.WarmUpParallel, "number of parallel readers warming up a volume")
+	flag.IntVar(&cfg.WarmUpRate, "warm-up-rate", cfg.WarmUpRate, "maximum rate of warming up a volume in MiB/s, 0 for no limit")
+	flag.DurationVar(&cfg.TrimInterval, "trim-interval", cfg.TrimInterval, "interval between fstrim runs on the mounted file system, for example 168h, 0 disables them")
+	flag.StringVar(&cfg.FsReservedPercent, "fs-reserved-percent", cfg.FsReservedPercent, "reserved block percentage set on a created ext file system, for example 0")
+	flag.StringVar(&cfg.FsLabel, "fs-label", cfg.FsLabel, "label set on a created file system, a template with the output template data, for example 'node-{{.NodeID}}'")
+	flag.StringVar(&cfg.FsUUID, "fs-uuid", cfg.FsUUID, "UUID set on a created ext or xfs file system: a UUID, random or time")
+	flag.StringVar(&cfg.ZFSPool, "zfs-pool", cfg.ZFSPool, "name of the ZFS pool created with -file-system-type=zfs")
+	flag.Var((*stringSlice)(&cfg.ZFSDatasets), "zfs-dataset", "ZFS dataset to create in the pool if missing, for example 'logs', can be given multiple times")
+	flag.BoolVar(&cfg.MountFs, "mount-fs", cfg.MountFs, "whether to mount a file system")
+	flag.StringVar(&cfg.MountPoint, "mount-point", cfg.MountPoint, "mount point path")
+	flag.Var((*stringSlice)(&cfg.NodeOverrides), "node-override", "override of block-device, mount-point, file-system-type, mkfs-options, min-iops, min-throughput or volume-types for a node ID or a range of numeric node IDs, for example '1-3:block-device=/dev/xvdf', can be given multiple times")
+	flag.StringVar(&cfg.RPFilter, "rp-filter", cfg.RPFilter, "rp_filter value to set on the attached network interface, empty to leave it untouched")
+	flag.Var((*stringSlice)(&cfg.Sysctls), "sysctl", "per-interface IPv4 sysctl to set on the attached network interface, for example 'arp_ignore=1', can be given multiple times")
+	flag.StringVar(&cfg.IfaceMode, "iface-mode", cfg.IfaceMode, "how the attached network interface gets its IP address: wait for DHCP or assign it directly with static")
+	flag.DurationVar(&cfg.IfaceWaitTimeout, "iface-wait-timeout", cfg.IfaceWaitTimeout, "how long to wait for DHCP to assign the IP address with -iface-mode=wait")
+	flag.IntVar(&cfg.IfaceMTU, "eni-mtu", cfg.IfaceMTU, "MTU to set on the attached network interface, for example 9001, 0 leaves it untouched")
+	flag.BoolVar(&cfg.GratuitousARP, "gratuitous-arp", cfg.GratuitousARP, "whether to send gratuitous ARP and unsolicited neighbor advertisements after attaching a network interface")
+	flag.IntVar(&cfg.PolicyRoutingTable, "policy-routing-table", cfg.PolicyRoutingTable, "routing table to route traffic from the attached network interface IP through it, 0 disables policy routing")
+	flag.Var((*stringSlice)(&cfg.RouteTables), "route-table", "route table whose -route-cidr routes are pointed at the attached network interface, can be given multiple times")
+	flag.Var((*stringSlice)(&cfg.RouteCIDRs), "route-cidr", "destination CIDR of routes in -route-table, can be given multiple times")
+	flag.StringVar(&cfg.SELinuxContext, "selinux-context", cfg.SELinuxContext, "SELinux context to mount the file system with, for example 'system_u:object_r:container_file_t:s0'")
+	flag.StringVar(&cfg.MountOwner, "mount-owner", cfg.MountOwner, "owner of the mount point after mounting, as user:group names or IDs, for example kafka:kafka")
+	flag.StringVar(&cfg.MountMode, "mount-mode", cfg.MountMode, "octal mode of the mount point after mounting, for example 0750")
+	flag.StringVar(&cfg.MultiAttach, "multi-attach", cfg.MultiAttach, "what to do with multi-attach volumes attached to other instances: refuse, or share them for clustered file systems")
+	flag.BoolVar(&cfg.Scratch, "scratch", cfg.Scratch, "whether to format and mount the instance-store disks to -scratch-mount-point, striped if there are several")
+	flag.Var((*stringSlice)(&cfg.ScratchDevices), "scratch-device", "instance-store disk to use for -scratch instead of the discovered NVMe ones, can be given multiple times")
+	flag.StringVar(&cfg.ScratchFsType, "scratch-fs-type", cfg.ScratchFsType, "file system type of the scratch disks")
+	flag.StringVar(&cfg.ScratchMountPoint, "scratch-mount-point", cfg.ScratchMountPoint, "mount point of the scratch disks")
+	flag.BoolVar(&cfg.ZooKeeperMyID, "zookeeper-myid", cfg.ZooKeeperMyID, "whether to write the numeric node ID to the ZooKeeper myid file")
+	flag.StringVar(&cfg.ZooKeeperMyIDFile, "zookeeper-myid-file", cfg.ZooKeeperMyIDFile, "ZooKeeper myid file path, defaults to myid in the mount point")
+	flag.StringVar(&cfg.EnvFile, "env-file", cfg.EnvFile, "environment file path")
+	flag.StringVar(&cfg.EnvFormat, "env-format", cfg.EnvFormat, "environment file format: systemd, dotenv, json or shell")
+	flag.StringVar(&cfg.EnvPrefix, "env-prefix", cfg.EnvPrefix, "prefix of the environment file keys, for example SMILODON_")
+	flag.StringVar(&cfg.EnvFileMode, "env-file-mode", cfg.EnvFileMode, "octal mode of the environment file, for example 0640")
+	flag.StringVar(&cfg.EnvFileOwner, "env-file-owner", cfg.EnvFileOwner, "owner of the environment file as user:group, for example root:kafka")
+	flag.StringVar(&cfg.EventsTopic, "events-sns-topic", cfg.EventsTopic, "SNS topic ARN to publish attach/detach events to")
+	flag.StringVar(&cfg.EventsQueue, "events-sqs-queue", cfg.EventsQueue, "SQS queue URL to send attach/detach events to")
+	flag.BoolVar(&cfg.WatchFiles, "watch-files", cfg.WatchFiles, "whether to restore output files when they are modified or removed externally")
+	flag.StringVar(&cfg.FilePerms, "file-perms", cfg.FilePerms, "a comma-delimited list of output file permissions. For example --file-perms='/run/smilodon/environment=0600:root:root'")
+	flag.StringVar(&cfg.MetricsNamespace, "cloudwatch-namespace", cfg.MetricsNamespace, "CloudWatch namespace to push reconcile metrics to after every pass, for example Smilodon")
+	flag.StringVar(&cfg.OTLPEndpoint, "otlp-endpoint", cfg.OTLPEndpoint, "OTLP HTTP endpoint of an OpenTelemetry collector to export traces of reconcile passes to, for example http://localhost:4318")
+	flag.StringVar(&cfg.TriggerQueue, "trigger-sqs-queue", cfg.TriggerQueue, "SQS queue URL fed by EventBridge rules, whose events trigger a reconcile pass right away")
+	flag.DurationVar(&cfg.PollInterval, "poll-interval", cfg.PollInterval, "interval between reconcile passes")
+	flag.DurationVar(&cfg.StablePollInterval, "stable-poll-interval", cfg.StablePollInterval, "interval between reconcile passes once a volume and a network interface are attached, defaults to -poll-interval")
+	flag.Float64Var(&cfg.PollJitter, "poll-jitter", cfg.PollJitter, "fraction of the poll interval to randomly jitter by, seeded by the instance ID")
+	flag.StringVar(&cfg.ClusterRecordZone, "cluster-record-zone", cfg.ClusterRecordZone, "Route 53 hosted zone ID of the cluster record set")
+	flag.StringVar(&cfg.ClusterRecordName, "cluster-record-name", cfg.ClusterRecordName, "name of the record set holding the IP addresses of all nodes, for example seeds.cluster.internal")
+	flag.Int64Var(&cfg.ClusterRecordTTL, "cluster-record-ttl", cfg.ClusterRecordTTL, "TTL of the cluster record set in seconds")
+	flag.StringVar(&cfg.ConsulAddr, "consul-addr", cfg.ConsulAddr, "Consul agent HTTP API address")
+	flag.StringVar(&cfg.ConsulService, "consul-service", cfg.ConsulService, "Consul service name to register the node as, registration is disabled if empty")
+	flag.StringVar(&cfg.ConsulToken, "consul-token", os.Getenv("CONSUL_HTTP_TOKEN"), "Consul ACL token, defaults to $CONSUL_HTTP_TOKEN")
+	flag.StringVar(&cfg.EtcdEndpoints, "etcd-endpoints", cfg.EtcdEndpoints, "comma-delimited list of etcd endpoints to record and lock node IDs in, for example http://etcd-1:2379,http://etcd-2:2379")
+	flag.StringVar(&cfg.EtcdPrefix, "etcd-prefix", cfg.EtcdPrefix, "etcd key prefix of node IDs")
+	flag.StringVar(&cfg.KubeNodeName, "kube-node-name", os.Getenv("NODE_NAME"), "Kubernetes node to label with the node ID and annotate with the IP address, defaults to $NODE_NAME")
+	flag.StringVar(&cfg.KubeAPIServer, "kube-api-server", cfg.KubeAPIServer, "Kubernetes API server URL, defaults to the in-cluster API server")
+	flag.StringVar(&cfg.KubeTokenFile, "kube-token-file", cfg.KubeTokenFile, "Kubernetes API bearer token file")
+	flag.StringVar(&cfg.KubeCAFile, "kube-ca-file", cfg.KubeCAFile, "Kubernetes API server CA certificate file")
+	flag.StringVar(&cfg.PreMountHook, "pre-mount-hook", cfg.PreMountHook, "command to run before mounting the file system, the mount is skipped if it fails")
+	flag.StringVar(&cfg.PostMountHook, "post-mount-hook", cfg.PostMountHook, "command to run after the file system is mounted")
+	flag.StringVar(&cfg.PreDetachHook, "pre-detach-hook", cfg.PreDetachHook, "command to run before detaching the node, ahead of unmounting its file system")
+	flag.BoolVar(&cfg.IOErrorWatchdog, "io-error-watchdog", cfg.IOErrorWatchdog, "whether to watch the kernel log for I/O errors of the block device and report the volume as degraded")
+	flag.IntVar(&cfg.IOErrorPasses, "io-error-passes", cfg.IOErrorPasses, "number of passes in a row with I/O errors after which the volume is degraded")
+	flag.BoolVar(&cfg.IOErrorReattach, "io-error-reattach", cfg.IOErrorReattach, "whether to detach a degraded volume and attach it again")
+	flag.DurationVar(&cfg.VolumeStatusInterval, "volume-status-interval", cfg.VolumeStatusInterval, "interval between status checks of the volume, for example 5m, 0 disables them")
+	flag.IntVar(&cfg.VolumeStatusChecks, "volume-status-checks", cfg.VolumeStatusChecks, "number of impaired status checks in a row after which the volume is recovered")
+	flag.StringVar(&cfg.VolumeStatusRecovery, "volume-status-recovery", cfg.VolumeStatusRecovery, "recovery of impaired volumes: reattach or restore from the latest snapshot, empty to only report them")
+	flag.StringVar(&cfg.HealthProbe, "health-probe", cfg.HealthProbe, "command or http(s) URL probing the local service once the node is complete")
+	flag.IntVar(&cfg.HealthThreshold, "health-threshold", cfg.HealthThreshold, "number of consecutive failed health probes after which -health-release releases the node")
+	flag.BoolVar(&cfg.HealthRelease, "health-release", cfg.HealthRelease, "whether to release the node after -health-threshold failed health probes, so that a standby instance takes over")
+	flag.DurationVar(&cfg.HealthHoldOff, "health-hold-off", cfg.HealthHoldOff, "how long not to claim a node after releasing one")
+	flag.StringVar(&cfg.VolumeLostHook, "volume-lost-hook", cfg.VolumeLostHook, "command to run after the volume was detached externally and its file system unmounted")
+	flag.Var((*stringSlice)(&cfg.Templates), "template", "Go template file to render when the node ID changes, can be given multiple times")
+	flag.Var((*stringSlice)(&cfg.AppPresets), "app-preset", "config fragment to render when the node ID changes, as name[=output], where name is kafka, cassandra or elasticsearch. Can be given multiple times")
+	flag.Var((*stringSlice)(&cfg.TemplateOutputs), "template-output", "output file path of the matching -template, can be given multiple times")
+	flag.BoolVar(&opts.disableSourceDestCheck, "disable-source-dest-check", true, "whether to disable the source/destination check of instance network interfaces on AWS")
+	flag.BoolVar(&opts.restoreSourceDestCheck, "restore-source-dest-check", false, "whether to restore the original source/destination check on shutdown")
+	flag.StringVar(&opts.configSSMPrefix, "config-ssm-prefix", "", "SSM parameter path of further flags, one parameter per flag named after it, for example /smilodon/prod/poll-interval, reloaded on SIGHUP. Flags given otherwise take precedence")
+	flag.StringVar(&opts.configSecretID, "config-secret-id", "", "Secrets Manager secret holding further flags as a JSON object, reloaded on SIGHUP. Flags given otherwise take precedence")
+	flag.StringVar(&opts.configFile, "config-file", "", "file of further flags as name=value lines, reloaded on SIGHUP. Flags given on the command line or as SMILODON_* environment variables take precedence")
+	flag.StringVar(&opts.profile, "profile", "", "profile of the config file to use, the run command runs all of them if empty")
+	flag.BoolVar(&opts.preflight, "preflight", false, "whether the run command checks EC2 permissions with dry runs on startup and exits if any are missing")
+	flag.StringVar(&opts.socket, "control-socket", "/run/smilodon/control.sock", "Unix socket the run command serves the control API on, used by the dump, reconcile, release, pause and resume commands. Empty disables it")
+	flag.StringVar(&opts.dumpFile, "dump-file", "/run/smilodon/state.json", "file the daemon writes its internal state to as JSON on SIGUSR2, read by the dump command")
+	flag.IntVar(&opts.pprofPort, "pprof-port", 0, "localhost port the run command serves net/http/pprof profiles on under /debug/pprof/, 0 disables it")
+	flag.StringVar(&opts.pidFile, "pid-file", "/run/smilodon/smilodon.pid", "pid file written by the run command, used by decommission to stop the daemon")
+	flag.StringVar(&opts.user, "user", "", "unprivileged user the run command drops to when started as root, keeping only -capabilities. A root parent process stays to forward signals")
+	flag.StringVar(&opts.capabilities, "capabilities", "", "comma-separated capabilities kept by -user, by default sys_admin,net_admin and net_raw with -gratuitous-arp. Add sys_chroot,sys_ptrace with -host-root")
+	flag.StringVar(&opts.logTarget, "log-target", "auto", "where to log: journal, with structured fields, stderr, or auto to log to the journal if stderr is connected to it")
+	flag.StringVar(&opts.output, "o", "text", "output format of the status, list and -version commands: text or json")
 	flag.BoolVar(&opts.help, "help", false, "print this message")
 	flag.BoolVar(&opts.version, "version", false, "print version and exit")
 }
 
 func main() {
 	flag.Parse()
-
-	if flag.NArg() > 0 || opts.help {
-		fmt.Fprintf(os.Stderr, "Usage: %q [OPTION]...\n", os.Args[0])
-		flag.PrintDefaults()
-		os.Exit(0)
+	flag.Visit(func(f *flag.Flag) { cmdLineFlags[f.Name] = true })
+	if err := applyEnv(); err != nil {
+		log.Printf("Invalid environment variable: %q.", err)
+		os.Exit(2)
 	}
-
-	if opts.version {
-		fmt.Fprintln(os.Stderr, Version)
-		os.Exit(0)
+	var profiles []string
+	if opts.configFile != "" {
+		var err error
+		if profiles, err = configProfiles(opts.configFile); err == nil {
+			err = applyConfigFile(opts.configFile, opts.profile)
+		}
+		if err != nil {
+			log.Printf("Failed to read config file: %q.", err)
+			os.Exit(2)
+		}
 	}
-
-	var i instance
-	err := i.getMetadata()
-	if err != nil {
-		log.Fatalf("Issues getting instance metadata properties. Exiting..")
+	if !opts.help && !opts.version {
+		if err := applyRemoteConfig(opts.profile); err != nil {
+			log.Printf("Failed to read the remote config: %q.", err)
+			if code := exitCode(err); code != 1 {
+				os.Exit(code)
+			}
+			os.Exit(2)
+		}
 	}
-	ec2c = ec2.New(session.New(), aws.NewConfig().WithRegion(i.region))
-	disableSourceDestCheck(i.id, ec2c)
-	filters = buildFilters(i)
-
-	for {
-		run(&i)
-		time.Sleep(120 * time.Second)
+	if opts.profile != "" {
+		if !contains(profiles, opts.profile) {
+			log.Printf("Unknown profile %q.", opts.profile)
+			os.Exit(2)
+		}
+		applyProfilePaths(opts.profile)
+	}
+	if err := setupLogging(opts.logTarget); err != nil {
+		log.Printf("Failed to set up logging: %q.", err)
+		os.Exit(2)
 	}
-}
 
-func run(i *instance) {
-	// Iterate over found volumes and check if one of them is attached to the
-	// instance, then update i.volume accordingly.
-	volumes, err := findVolumes(i, ec2c, filters)
-	if err != nil {
-		log.Println(err)
-	} else {
-		for _, v := range volumes {
-			if i.volume == nil && v.attachedTo == i.id && !v.available {
-				log.Printf("Found attached volume: %q.\n", v.id)
-				i.volume = &v
-				break
-			}
-			if i.volume != nil && i.volume.id == v.id && v.available {
-				i.volume = nil
-				break
-			}
+	name, args := "run", []string(nil)
+	if flag.NArg() > 0 {
+		name, args = flag.Arg(0), flag.Args()[1:]
+	}
+	c, ok := commands[name]
+	if !ok || opts.help {
+		usage()
+		if !ok {
+			os.Exit(2)
 		}
+		os.Exit(0)
 	}
 
-	// Iterate over found network interfaces and see if one of them is attached
-	// to the instance, then update i.networkInterface accordingly.
-	networkInterfaces, err := findNetworkInterfaces(i, ec2c, filters)
-	if err != nil {
-		log.Println(err)
-	} else {
-		for _, n := range networkInterfaces {
-			if i.networkInterface == nil && n.attachedTo == i.id && !n.available {
-				log.Printf("Found attached network interface: %q.\n", n.id)
-				i.networkInterface = &n
-				break
-			}
-			if i.networkInterface != nil && i.networkInterface.id == n.id && n.available {
-				i.networkInterface = nil
-				break
-			}
-		}
+	if opts.output != "text" && opts.output != "json" {
+		log.Printf("Unknown output format %q.", opts.output)
+		os.Exit(2)
 	}
 
-	// If nothing is attached, then pick an available volume. We never want to
-	// attach a network interface if there is no volume attached first.
-	if i.volume == nil && i.networkInterface == nil {
-		log.Println("Neither a volume, nor a network interface are attached.")
-		for _, v := range volumes {
-			if v.available {
-				i.attachVolume(v, ec2c)
-				break
-			}
-		}
-		if i.volume == nil {
-			log.Println("No available volumes found.")
-		}
-		if i.volume != nil {
-			for _, n := range networkInterfaces {
-				if n.available && i.volume.nodeID == n.nodeID {
-					_ = i.attachNetworkInterface(n, ec2c)
-					waitAndSetupIface(n.IPAddress)
-					break
-				}
-				log.Println("No available network interfaces found.")
-			}
+	if opts.version {
+		if opts.output == "json" {
+			printJSON(map[string]string{"version": Version})
 		} else {
-			log.Println("No volumes appear to be attached, skipping network interface attachment.")
+			fmt.Fprintln(os.Stderr, Version)
 		}
+		os.Exit(0)
 	}
 
-	// If volume is attached, but network interface is not, then find a
-	// matching available network interface and attach it.
-	if i.volume != nil && i.networkInterface == nil {
-		for _, n := range networkInterfaces {
-			if n.available && n.nodeID == i.volume.nodeID {
-				_ = i.attachNetworkInterface(n, ec2c)
-				waitAndSetupIface(n.IPAddress)
-				break
-			}
-		}
+	// Without a profile, only the run command runs all of them.
+	if len(profiles) > 0 && opts.profile == "" && name != "run" && name != "install-unit" {
+		log.Printf("The config file has profiles %s, select one with -profile.", strings.Join(profiles, ", "))
+		os.Exit(2)
 	}
 
-	// If network interface is attached, but volume is not, then find a
-	// matching available volume and attach it. If we cannot find a matching
-	// volume after 3 tries, we release the network interface.
-	if i.networkInterface != nil && i.volume == nil {
-		if volumeAttachTries > 2 {
-			log.Println("Unable to attach a matching volume after 3 retries.")
-			if err := i.dettachNetworkInterface(); err == nil {
-				volumeAttachTries = 0
-			}
-		}
-		for _, v := range volumes {
-			if v.available && v.nodeID == i.networkInterface.nodeID {
-				log.Printf("Found a matching volume %q with NodeID %q.\n", v.id, v.nodeID)
-				if err := i.attachVolume(v, ec2c); err == nil {
-					volumeAttachTries = 0
-					break
-				}
-			}
-		}
-		if i.volume == nil {
-			volumeAttachTries++
+	if c.local {
+		if err := c.run(context.Background(), nil, args); err != nil {
+			log.Printf("The %s command failed: %q.", name, err)
+			os.Exit(exitCode(err))
 		}
+		return
 	}
 
-	// FIXME: below could be cleaned up with less if statements maybe
-	// Set node ID. If specified, create and mount the file system.
-	if i.volume != nil && i.networkInterface != nil {
-		if i.volume.nodeID == i.networkInterface.nodeID {
-			if i.nodeID != i.volume.nodeID {
-				i.nodeID = i.volume.nodeID
-				log.Printf("Node ID is %q.\n", i.nodeID)
-				writeEnvFile(opts.envFile, *i)
-			}
+	// Only the run command drops privileges, the others are short-lived.
+	if name == "run" && opts.user != "" && os.Getuid() == 0 {
+		caps := opts.capabilities
+		if caps == "" {
+			caps = defaultCapabilities(cfg)
 		}
-		// Set nodeID only when both volume and network interface are attached and their node IDs match.
-		if i.volume.nodeID != i.networkInterface.nodeID {
-			log.Printf("Something has gone wrong, volume and network interface node IDs do not match.")
-		}
-		if opts.createFs {
-			if !hasFs(opts.blockDevice, opts.fsType) {
-				mkfs(opts.blockDevice, opts.fsType)
-			}
-		}
-		if opts.mountFs {
-			if hasFs(opts.blockDevice, opts.fsType) && !isMounted(opts.blockDevice) {
-				mount(opts.blockDevice, opts.mountPoint, opts.fsType)
-			}
+		code, err := runUnprivileged(opts.user, caps)
+		if err != nil {
+			log.Printf("Failed to run as user %q: %q.", opts.user, err)
 		}
+		os.Exit(code)
 	}
-}
 
-// waitAndSetupIface blocks until network interface becomes ready and gets an
-// IP, then set needed sysctl settings.
-func waitAndSetupIface(ip string) {
-	for tries := 0; tries < 5; tries++ {
-		time.Sleep(5 * time.Second)
+	// Cancel in-flight API calls and hooks on shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		s := <-sigs
+		log.Printf("Received %s, shutting down.\n", s)
+		cancel()
+	}()
 
-		iface, err := getIfaceNameByIP(ip)
-		if err != nil {
-			log.Printf("failed to get interface name: %v", err)
-		}
-		if iface == "" {
-			continue
-		}
-		if err := setNetRPFilter(iface); err != nil {
-			log.Printf("failed to set rp_filter: %v", err)
-		} else {
-			break
+	if len(profiles) > 0 && opts.profile == "" {
+		if err := runProfiles(ctx, profiles); err != nil {
+			log.Printf("The %s command failed: %q.", name, err)
+			os.Exit(exitCode(err))
 		}
+		return
 	}
-}
 
-// getIfaceNameByIP returns network interface name by IP address.
-func getIfaceNameByIP(ip string) (string, error) {
-	var name string
-	ifaces, err := net.Interfaces()
+	p, err := newProvider(opts.provider, opts.filters, awsOpts)
 	if err != nil {
-		return name, err
+		log.Printf("Issues getting instance metadata properties: %q. Exiting..", err)
+		os.Exit(exitCode(err))
 	}
-	for _, iface := range ifaces {
-		addr, err := iface.Addrs()
-		if err != nil {
-			return name, err
-		}
-		for _, a := range addr {
-			netIP, _, err := net.ParseCIDR(a.String())
-			if err != nil {
-				return name, err
-			}
-			if netIP.Equal(net.ParseIP(ip)) {
-				name = iface.Name
-			}
+
+	r, err := smilodon.NewReconciler(ctx, cfg, p)
+	if err != nil {
+		log.Printf("Invalid configuration: %q.", err)
+		if code := exitCode(err); code != 1 {
+			os.Exit(code)
 		}
+		os.Exit(2)
+	}
+	if err := c.run(ctx, r, args); err != nil {
+		log.Printf("The %s command failed: %q.", name, err)
+		os.Exit(exitCode(err))
 	}
-	return name, nil
 }
 
-// setNetRPFilter sets /proc/sys/net/ipv4/conf/<iface>/rp_filter to value of 2.
-// This is needed to accept asymmetrically routed (outgoing routes and incoming
-// routes are different) packets on iface interface.
-func setNetRPFilter(iface string) error {
-	key := fmt.Sprintf("/proc/sys/net/ipv4/conf/%s/rp_filter", iface)
+// Exit codes of failed commands by error category. Other failures exit with
+// 1, invalid command lines and configurations with 2.
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{smilodon.ErrMetadata, 3},
+	{smilodon.ErrPermissionDenied, 4},
+	{smilodon.ErrNoResources, 5},
+	{smilodon.ErrAttachTimeout, 6},
+	{smilodon.ErrFilesystem, 7},
+}
 
-	f, err := os.OpenFile(key, os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+// exitCode returns the exit code of error err.
+func exitCode(err error) int {
+	for _, c := range exitCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return 1
+}
+
+// usage prints the command line usage.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %q [OPTION]... [COMMAND]\n\nCommands:\n", os.Args[0])
+	for _, n := range commandNames {
+		fmt.Fprintf(os.Stderr, "  %-13s %s\n", n, commands[n].usage)
 	}
-	defer f.Close()
+	fmt.Fprintf(os.Stderr, "\nOptions:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nEvery option can also be given as an environment variable, for example %s for -poll-interval.\n", envName("poll-interval"))
+}
 
-	if _, err := f.WriteString("2\n"); err != nil {
-		return err
+// newProvider returns a provider of a given name using filters f and AWS
+// options o.
+func newProvider(name, f string, o smilodon.AWSOptions) (smilodon.Provider, error) {
+	switch name {
+	case "aws":
+		return smilodon.NewAWSProvider(f, o)
+	case "gcp":
+		return smilodon.NewGCPProvider(f)
+	case "azure":
+		return smilodon.NewAzureProvider(f)
+	case "openstack":
+		return smilodon.NewOpenStackProvider(f)
 	}
-	return nil
+	return nil, fmt.Errorf("unknown provider %q", name)
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// writePidFile writes the process ID to pid file f.
+func writePidFile(f string) error {
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// daemonPid returns the process ID of the daemon of pid file f, or zero if it
+// is not running.
+func daemonPid(f string) (int, error) {
+	b, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pid file %q: %v", f, err)
+	}
+	if syscall.Kill(pid, 0) != nil {
+		return 0, nil
+	}
+	return pid, nil
+}
+
+// stopDaemon stops the daemon of pid file f, if it is running, and waits for
+// it to exit.
+func stopDaemon(f string) error {
+	pid, err := daemonPid(f)
+	if err != nil || pid == 0 {
+		return err
+	}
+	log.Printf("Stopping smilodon daemon with pid %d.\n", pid)
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	for i := 0; i < 60; i++ {
+		if syscall.Kill(pid, 0) != nil {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("smilodon daemon with pid %d did not exit", pid)
+}
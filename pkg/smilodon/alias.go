@@ -0,0 +1,73 @@
+package smilodon
+
+import (
+	"fmt"
+	"log"
+	"net"
+)
+
+// parseAliasIPs validates alias IP addresses ips.
+func parseAliasIPs(ips []string) error {
+	for _, ip := range ips {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid alias IP address %q", ip)
+		}
+	}
+	return nil
+}
+
+// aliasIPs returns the alias IP addresses of network interface n: the
+// configured ones and, if enabled, the secondary IP addresses of n.
+func (r *Reconciler) aliasIPs(n NetworkInterface) []string {
+	ips := append([]string(nil), r.cfg.AliasIPs...)
+	if r.cfg.AliasSecondaryIPs {
+		ips = append(ips, n.SecondaryIPAddresses...)
+	}
+	return ips
+}
+
+// hostPrefix returns ip as a single host prefix, /32 or /128.
+func hostPrefix(ip string) string {
+	if net.ParseIP(ip).To4() != nil {
+		return ip + "/32"
+	}
+	return ip + "/128"
+}
+
+// addAliases assigns the alias IP addresses of network interface n to
+// interface iface and announces them to peers, if enabled.
+func (r *Reconciler) addAliases(iface string, n NetworkInterface) {
+	for _, ip := range r.aliasIPs(n) {
+		if err := addrReplace(iface, hostPrefix(ip)); err != nil {
+			log.Printf("failed to assign alias %q: %v", ip, err)
+			continue
+		}
+		log.Printf("Assigned alias %q to %q.\n", ip, iface)
+		if r.cfg.GratuitousARP {
+			announce(iface, ip)
+		}
+	}
+}
+
+// removeAliases removes the alias IP addresses of network interface n from
+// its interface, so that they do not linger after it is detached.
+func (r *Reconciler) removeAliases(n NetworkInterface) {
+	ips := r.aliasIPs(n)
+	if len(ips) == 0 {
+		return
+	}
+	iface, err := findIface(n)
+	if err != nil || iface == "" {
+		return
+	}
+	for _, ip := range ips {
+		if ok, _ := ifaceHasIP(iface, ip); !ok {
+			continue
+		}
+		if err := addrDel(iface, hostPrefix(ip)); err != nil {
+			log.Printf("failed to remove alias %q: %v", ip, err)
+			continue
+		}
+		log.Printf("Removed alias %q from %q.\n", ip, iface)
+	}
+}
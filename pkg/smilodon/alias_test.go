@@ -0,0 +1,15 @@
+package smilodon
+
+import "testing"
+
+func TestHostPrefix(t *testing.T) {
+	for _, tc := range []struct{ ip, want string }{
+		{"10.0.1.20", "10.0.1.20/32"},
+		{"::ffff:10.0.1.20", "::ffff:10.0.1.20/32"},
+		{"2001:db8::20", "2001:db8::20/128"},
+	} {
+		if got := hostPrefix(tc.ip); got != tc.want {
+			t.Errorf("hostPrefix(%s) = %s, want %s", tc.ip, got, tc.want)
+		}
+	}
+}
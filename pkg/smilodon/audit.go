@@ -0,0 +1,98 @@
+package smilodon
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go/middleware"
+)
+
+// auditLog appends a JSON line per mutating API call to a file, so that
+// identity movements can be reconstructed after incidents.
+type auditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time       time.Time   `json:"time"`
+	Service    string      `json:"service"`
+	Operation  string      `json:"operation"`
+	Params     interface{} `json:"params"`
+	RequestID  string      `json:"request_id,omitempty"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// openAuditLog opens audit log file f for appending, or returns nil if f is
+// empty.
+func openAuditLog(f string) (*auditLog, error) {
+	if f == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(f, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{f: file}, nil
+}
+
+// auditAWS returns a function adding a middleware to stack which records
+// every mutating call in audit log a, once it completes after any retries.
+func auditAWS(a *auditLog) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("SmilodonAudit", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			out, md, err := next.HandleInitialize(ctx, in)
+			a.record(ctx, in.Parameters, md, err)
+			return out, md, err
+		}), middleware.After)
+	}
+}
+
+// mutating checks whether API operation op changes resources. Receiving
+// messages from SQS is not considered mutating.
+func mutating(op string) bool {
+	for _, p := range []string{"Describe", "Get", "List", "Receive"} {
+		if strings.HasPrefix(op, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// record appends a call of the operation in ctx with params, result metadata
+// md and error err, if it is mutating. Failures are logged and otherwise
+// ignored.
+func (a *auditLog) record(ctx context.Context, params interface{}, md middleware.Metadata, err error) {
+	service, op := awsOperation(ctx)
+	if a == nil || !mutating(op) {
+		return
+	}
+	rec := auditRecord{
+		Time:       time.Now().UTC(),
+		Service:    service,
+		Operation:  op,
+		Params:     params,
+		RequestID:  awsRequestID(md, err),
+		StatusCode: awsStatusCode(md, err),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	b, merr := json.Marshal(rec)
+	if merr != nil {
+		log.Printf("Failed to encode audit record of %q: %q.\n", rec.Operation, merr)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, werr := a.f.Write(append(b, '\n')); werr != nil {
+		log.Printf("Failed to write audit record of %q: %q.\n", rec.Operation, werr)
+	}
+}
@@ -0,0 +1,27 @@
+package smilodon
+
+import "testing"
+
+func TestMutating(t *testing.T) {
+	for _, tc := range []struct {
+		op   string
+		want bool
+	}{
+		{"AttachVolume", true},
+		{"CreateTags", true},
+		{"ModifyNetworkInterfaceAttribute", true},
+		{"ChangeResourceRecordSets", true},
+		{"Publish", true},
+		{"SendMessage", true},
+		{"DeleteMessage", true},
+		{"PutMetricData", true},
+		{"DescribeVolumes", false},
+		{"GetParametersByPath", false},
+		{"ListResourceRecordSets", false},
+		{"ReceiveMessage", false},
+	} {
+		if got := mutating(tc.op); got != tc.want {
+			t.Errorf("mutating(%s) = %t, want %t", tc.op, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package smilodon
+
+import (
+	"context"
+	"log"
+	"os"
+)
+
+// deviceLister is implemented by providers which know the device names taken
+// by attachments to the instance.
+type deviceLister interface {
+	// UsedDevices returns the device names of all attachments.
+	UsedDevices(ctx context.Context) ([]string, error)
+}
+
+// nextDevice returns the device name following d, for example /dev/xvdf for
+// /dev/xvde, if d ends with a letter other than z.
+func nextDevice(d string) (string, bool) {
+	if d == "" {
+		return "", false
+	}
+	c := d[len(d)-1]
+	if c < 'a' || c >= 'z' {
+		return "", false
+	}
+	return d[:len(d)-1] + string(c+1), true
+}
+
+// freeDevice returns d, or the next device name after d which is neither
+// taken by an attachment nor present on the instance.
+func (r *Reconciler) freeDevice(ctx context.Context, d string) string {
+	used := map[string]bool{}
+	if l, ok := r.provider.(deviceLister); ok {
+		ds, err := l.UsedDevices(ctx)
+		if err != nil {
+			log.Printf("Failed to get device names in use, trying %q: %q.\n", d, err)
+			return d
+		}
+		for _, u := range ds {
+			used[u] = true
+		}
+	}
+	for c := d; ; {
+		if _, err := os.Stat(r.host.path(c)); !used[c] && os.IsNotExist(err) {
+			if c != d {
+				log.Printf("Device %q is taken, using %q instead.\n", d, c)
+			}
+			return c
+		}
+		n, ok := nextDevice(c)
+		if !ok {
+			log.Printf("No free device name found after %q, trying %q.\n", d, d)
+			return d
+		}
+		c = n
+	}
+}
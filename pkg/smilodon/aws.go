@@ -0,0 +1,853 @@
+package smilodon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// AWSProvider is a Provider backed by EBS volumes and ENIs.
+type AWSProvider struct {
+	instance Instance
+	imdsTags imdsTags
+	ec2c     *ec2.Client
+
+	// filtersMu guards the filters, which are reloadable.
+	filtersMu        sync.Mutex
+	volumeFilters    []types.Filter
+	interfaceFilters []types.Filter
+
+	nodeIDTag             string
+	volumeNodeIDSource    string
+	interfaceNodeIDSource string
+
+	// subnets caches subnet CIDRs by subnet ID.
+	subnets map[string]string
+	// timeout bounds every EC2 call, including retries.
+	timeout time.Duration
+	limiter *rateLimiter
+
+	// sourceDestCheck holds the original SourceDestCheck attribute of every
+	// network interface it was disabled on.
+	sourceDestCheck map[string]bool
+	// attachmentTags enables tagging attached resources with the instance.
+	attachmentTags bool
+	// requireEncrypted enables skipping unencrypted volumes and encrypting
+	// created ones, with kmsKeyID if not empty.
+	requireEncrypted bool
+	kmsKeyID         string
+	// kmsKeyARN caches the resolved ARN of kmsKeyID.
+	kmsKeyARN string
+	kms       *kms.Client
+	// managedBy is the ownership tag resources must carry to be managed, and
+	// which resources created by smilodon are tagged with, if not nil.
+	managedBy *types.Tag
+	// cfg is the config of the EC2 client, which the clients of other
+	// services share.
+	cfg aws.Config
+	// deleteOnTermination is the desired DeleteOnTermination flag of network
+	// interfaces attached to the instance.
+	deleteOnTermination bool
+	// deviceIndex is the preferred device index of the network interface
+	// attachment.
+	deviceIndex int32
+	// securityGroups are the desired security groups of network interfaces
+	// attached to the instance, see AWSOptions.SecurityGroups.
+	securityGroups []string
+}
+
+// Sources of AWS resource node IDs.
+const (
+	// nodeIDSourceTag reads the node ID from the node ID tag.
+	nodeIDSourceTag = "tag"
+	// nodeIDSourceName reads the node ID from the Name tag of a volume.
+	nodeIDSourceName = "name"
+	// nodeIDSourceDescription reads the node ID from the description of a
+	// network interface.
+	nodeIDSourceDescription = "description"
+)
+
+// AWSOptions configures the EC2 client of an AWSProvider.
+type AWSOptions struct {
+	// Timeout bounds every EC2 call, including retries. Zero means no
+	// timeout.
+	Timeout time.Duration
+	// RateLimit is the average number of EC2 calls per second allowed, with
+	// bursts of up to RateBurst calls. Zero means no limit.
+	RateLimit float64
+	RateBurst int
+	// NodeIDTag is the tag holding the node ID, NodeID by default.
+	NodeIDTag string
+	// VolumeNodeIDSource is where volume node IDs are read from: tag or name.
+	VolumeNodeIDSource string
+	// InterfaceNodeIDSource is where network interface node IDs are read
+	// from: tag or description.
+	InterfaceNodeIDSource string
+	// VolumeFilters and InterfaceFilters replace the provider filters for
+	// volume and network interface lookups respectively, if not empty.
+	VolumeFilters    string
+	InterfaceFilters string
+	// Endpoint overrides the EC2 endpoint URL, for example to use LocalStack
+	// or a VPC endpoint.
+	Endpoint string
+	// AssumeRoleARN is an IAM role, possibly in another account, which all
+	// AWS clients assume. Instance metadata still comes from the local
+	// instance.
+	AssumeRoleARN string
+	// ExternalID is passed to STS when assuming AssumeRoleARN.
+	ExternalID string
+	// Region overrides the region read from the metadata service.
+	Region string
+	// Profile selects a named profile of the shared config and credentials
+	// files.
+	Profile string
+	// AccessKeyID and SecretAccessKey are static credentials, which take
+	// precedence over Profile.
+	AccessKeyID     string
+	SecretAccessKey string
+	// AttachmentTags enables tagging attached volumes and network interfaces
+	// with the instance ID, hostname and attachment time.
+	AttachmentTags bool
+	// AuditLog is a file every mutating AWS call is appended to as a JSON
+	// line, if not empty.
+	AuditLog string
+	// RequireEncrypted enables skipping unencrypted candidate volumes and
+	// encrypting volumes smilodon creates, with the KMS key KMSKeyID or the
+	// account default. Candidate volumes encrypted with another key than
+	// KMSKeyID, a key ID, ARN or alias, are skipped if it is set.
+	RequireEncrypted bool
+	KMSKeyID         string
+	// ManagedByTag is an ownership tag of the form key=value, for example
+	// 'managed-by=smilodon'. If set, volumes and network interfaces without it
+	// are never attached, detached or modified, and resources smilodon
+	// creates are tagged with it.
+	ManagedByTag string
+	// InterfaceDeleteOnTermination is the desired DeleteOnTermination flag
+	// of the network interface attachment, which is corrected on every pass.
+	// It is off by default so that the interface survives instance loss.
+	InterfaceDeleteOnTermination bool
+	// InterfaceDeviceIndex is the preferred device index the network
+	// interface is attached at, 1 if zero. If it is occupied, for example by
+	// interfaces of a CNI plugin, the next free index is used.
+	InterfaceDeviceIndex int64
+	// SecurityGroups is a comma-delimited list of security group IDs and
+	// tag:<key>=<value> lookups in the instance VPC, which the attached
+	// network interface is kept in on every pass, if not empty.
+	SecurityGroups string
+	// Debug enables logging every AWS request attempt with its parameters,
+	// result, request ID and retries.
+	Debug bool
+}
+
+// clientConfig returns the config of AWS clients in region with the
+// credentials selected by o, assuming AssumeRoleARN with session name
+// sessionName if set. Every call made with it is traced, and logged if Debug
+// is set.
+func (o AWSOptions) clientConfig(ctx context.Context, region, sessionName string) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+	switch {
+	case o.AccessKeyID != "":
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(o.AccessKeyID, o.SecretAccessKey, "")))
+	case o.Profile != "":
+		opts = append(opts, config.WithSharedConfigProfile(o.Profile))
+	}
+	c, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	c.APIOptions = append(c.APIOptions, traceAWS)
+	if o.Debug {
+		c.APIOptions = append(c.APIOptions, debugAWS)
+	}
+	if o.AssumeRoleARN != "" {
+		log.Printf("Assuming role: %q.\n", o.AssumeRoleARN)
+		c.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(c), o.AssumeRoleARN, func(r *stscreds.AssumeRoleOptions) {
+			r.RoleSessionName = sessionName
+			if o.ExternalID != "" {
+				r.ExternalID = aws.String(o.ExternalID)
+			}
+		}))
+	}
+	return c, nil
+}
+
+// defaultAWSConfig returns the client config of AWS provider p, or of the
+// default credential chain in region for other providers.
+func defaultAWSConfig(p Provider, region string) aws.Config {
+	if a, ok := p.(*AWSProvider); ok && a.cfg.Credentials != nil {
+		return a.cfg
+	}
+	c, err := AWSOptions{}.clientConfig(context.Background(), region, "smilodon")
+	if err != nil {
+		log.Printf("Failed to load the default AWS config: %q.\n", err)
+		return aws.Config{Region: region}
+	}
+	return c
+}
+
+// NewAWSProvider returns an AWSProvider for the instance it runs on. Filters
+// is a comma-delimited list of EC2 filters, for example
+// 'tag-key=Env,tag:Profile=foo'.
+func NewAWSProvider(filters string, o AWSOptions) (*AWSProvider, error) {
+	p := &AWSProvider{
+		nodeIDTag:             firstNonEmpty(o.NodeIDTag, "NodeID"),
+		volumeNodeIDSource:    firstNonEmpty(o.VolumeNodeIDSource, nodeIDSourceTag),
+		interfaceNodeIDSource: firstNonEmpty(o.InterfaceNodeIDSource, nodeIDSourceTag),
+		timeout:               o.Timeout,
+		limiter:               newRateLimiter(o.RateLimit, o.RateBurst),
+		attachmentTags:        o.AttachmentTags,
+		requireEncrypted:      o.RequireEncrypted,
+		kmsKeyID:              o.KMSKeyID,
+		deleteOnTermination:   o.InterfaceDeleteOnTermination,
+		deviceIndex:           int32(o.InterfaceDeviceIndex),
+	}
+	if p.deviceIndex == 0 {
+		p.deviceIndex = 1
+	}
+	if o.ManagedByTag != "" {
+		t, err := parseManagedByTag(o.ManagedByTag)
+		if err != nil {
+			return nil, err
+		}
+		p.managedBy = t
+	}
+	if o.SecurityGroups != "" {
+		p.securityGroups = strings.Split(o.SecurityGroups, ",")
+	}
+	if s := p.volumeNodeIDSource; s != nodeIDSourceTag && s != nodeIDSourceName {
+		return nil, fmt.Errorf("unknown volume node ID source %q", s)
+	}
+	if s := p.interfaceNodeIDSource; s != nodeIDSourceTag && s != nodeIDSourceDescription {
+		return nil, fmt.Errorf("unknown network interface node ID source %q", s)
+	}
+	audit, err := openAuditLog(o.AuditLog)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if err := p.getMetadata(ctx, o.Region); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMetadata, err)
+	}
+	c, err := o.clientConfig(ctx, p.instance.Region, "smilodon-"+p.instance.ID)
+	if err != nil {
+		return nil, err
+	}
+	if audit != nil {
+		c.APIOptions = append(c.APIOptions, auditAWS(audit))
+	}
+	p.cfg = c
+	p.kms = kms.NewFromConfig(c)
+	p.ec2c = ec2.NewFromConfig(c, func(eo *ec2.Options) {
+		if o.Endpoint != "" {
+			log.Printf("Using EC2 endpoint: %q.\n", o.Endpoint)
+			eo.BaseEndpoint = aws.String(o.Endpoint)
+		}
+		eo.APIOptions = append(eo.APIOptions, p.limit)
+	})
+	vpc, err := p.getVPC(ctx)
+	if err != nil {
+		return nil, categorize(err)
+	}
+	p.instance.VPC = vpc
+	p.ReloadFilters(filters, o)
+	return p, nil
+}
+
+// ReloadFilters replaces the volume and network interface filters with
+// filters, or the VolumeFilters and InterfaceFilters of o if not empty.
+func (p *AWSProvider) ReloadFilters(filters string, o AWSOptions) {
+	volumeTag := p.nodeIDTag
+	if p.volumeNodeIDSource == nodeIDSourceName {
+		volumeTag = "Name"
+	}
+	interfaceTag := p.nodeIDTag
+	if p.interfaceNodeIDSource == nodeIDSourceDescription {
+		interfaceTag = ""
+	}
+	p.filtersMu.Lock()
+	defer p.filtersMu.Unlock()
+	p.volumeFilters = buildFilters(volumeTag, firstNonEmpty(o.VolumeFilters, filters))
+	p.interfaceFilters = buildFilters(interfaceTag, firstNonEmpty(o.InterfaceFilters, filters))
+}
+
+// filters returns the volume and network interface filters.
+func (p *AWSProvider) filters() ([]types.Filter, []types.Filter) {
+	p.filtersMu.Lock()
+	defer p.filtersMu.Unlock()
+	return p.volumeFilters, p.interfaceFilters
+}
+
+// getMetadata reads the instance from the metadata service. The region is
+// only looked up if region is empty.
+func (p *AWSProvider) getMetadata(ctx context.Context, region string) error {
+	// Get instance id
+	metadata := imds.New(imds.Options{})
+	p.imdsTags.metadata = metadata
+	id, err := metadataValue(ctx, metadata, "instance-id")
+	if err != nil {
+		log.Printf("Failed to get instance ID from the metadata service: %q.\n", err)
+		return err
+	}
+	p.instance.ID = id
+
+	// Get instance region
+	if region == "" {
+		r, err := metadata.GetRegion(ctx, &imds.GetRegionInput{})
+		if err != nil {
+			log.Printf("Failed to get instance region from the metadata service: %q.\n", err)
+			return err
+		}
+		region = r.Region
+	}
+	p.instance.Region = region
+
+	// Get AZ
+	az, err := metadataValue(ctx, metadata, "placement/availability-zone")
+	if err != nil {
+		log.Printf("Failed to get instance AZ from the metadata service: %q.\n.", err)
+		return err
+	}
+	p.instance.AZ = az
+	return nil
+}
+
+// awsOperation returns the service, in lower case, and the operation of the
+// AWS call in ctx.
+func awsOperation(ctx context.Context) (string, string) {
+	return strings.ToLower(middleware.GetServiceID(ctx)), middleware.GetOperationName(ctx)
+}
+
+// traceAWS adds a middleware to stack tracing every call, including its
+// retries, as a span named after the service and operation.
+func traceAWS(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("SmilodonTrace", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		service, op := awsOperation(ctx)
+		_, s := startSpan(ctx, service+"."+op)
+		out, md, err := next.HandleInitialize(ctx, in)
+		s.set("aws.request_id", awsRequestID(md, err))
+		s.set("aws.retries", strconv.Itoa(awsRetries(md)))
+		s.end(err)
+		return out, md, err
+	}), middleware.Before)
+}
+
+// limit adds a middleware to stack which sends every EC2 call once the rate
+// limit allows it, giving up after the provider timeout.
+func (p *AWSProvider) limit(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("SmilodonLimit", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		if p.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, p.timeout)
+			defer cancel()
+		}
+		if err := p.limiter.wait(ctx); err != nil {
+			return middleware.InitializeOutput{}, middleware.Metadata{}, err
+		}
+		return next.HandleInitialize(ctx, in)
+	}), middleware.Before)
+}
+
+// awsRequestID returns the request ID of an AWS call with result metadata md
+// and error err.
+func awsRequestID(md middleware.Metadata, err error) string {
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		return re.ServiceRequestID()
+	}
+	id, _ := awsmiddleware.GetRequestIDMetadata(md)
+	return id
+}
+
+// awsStatusCode returns the HTTP status code of an AWS call with result
+// metadata md and error err, or zero if there was no response.
+func awsStatusCode(md middleware.Metadata, err error) int {
+	var re *smithyhttp.ResponseError
+	if errors.As(err, &re) {
+		return re.HTTPStatusCode()
+	}
+	if r, ok := awsmiddleware.GetRawResponse(md).(*smithyhttp.Response); ok {
+		return r.StatusCode
+	}
+	return 0
+}
+
+// awsRetries returns the number of retries of an AWS call with result
+// metadata md.
+func awsRetries(md middleware.Metadata) int {
+	r, _ := retry.GetAttemptResults(md)
+	if len(r.Results) == 0 {
+		return 0
+	}
+	return len(r.Results) - 1
+}
+
+// getVPC returns the VPC ID of the instance, read from the metadata service
+// unless it fails.
+func (p *AWSProvider) getVPC(ctx context.Context) (string, error) {
+	if vpc, err := p.metadataVPC(ctx); err == nil && vpc != "" {
+		return vpc, nil
+	}
+	params := &ec2.DescribeInstancesInput{
+		InstanceIds: []string{p.instance.ID},
+	}
+	instances, err := p.ec2c.DescribeInstances(ctx, params)
+	if err != nil {
+		log.Printf("Failed to get instance VPC ID: %q.\n", err)
+		return "", err
+	}
+	return *instances.Reservations[0].Instances[0].VpcId, nil
+}
+
+// Metadata returns the instance smilodon runs on.
+func (p *AWSProvider) Metadata(ctx context.Context) (Instance, error) {
+	return p.instance, nil
+}
+
+func (p *AWSProvider) getResourceTagValue(ctx context.Context, id, tag string) string {
+	params := &ec2.DescribeTagsInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("resource-id"),
+				Values: []string{id},
+			},
+			{
+				Name:   aws.String("key"),
+				Values: []string{tag},
+			},
+		},
+	}
+	resp, err := p.ec2c.DescribeTags(ctx, params)
+	if err != nil {
+		log.Printf("Cannot get tag %q of %q resource: %q.\n", tag, id, err)
+		return ""
+	}
+	if len(resp.Tags) > 0 {
+		for _, t := range resp.Tags {
+			return *t.Value
+		}
+	}
+	log.Printf("Cannot get tag %q of %q resource.\n", tag, id)
+	return ""
+}
+
+// firstNonEmpty returns the first of ss which is not empty.
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// buildFilters builds a list of filters of type []types.Filter matching
+// resources with tag key t, if not empty. It parses optional comma-delimited
+// filters f. Resources are not restricted to the instance AZ here, as volume
+// lookups check that themselves.
+func buildFilters(t, f string) []types.Filter {
+	var filters []types.Filter
+	if t != "" {
+		filters = append(filters, types.Filter{
+			Name:   aws.String("tag-key"),
+			Values: []string{t},
+		})
+	}
+	if f != "" {
+		kvs := strings.Split(f, ",")
+		for _, i := range kvs {
+			parts := strings.Split(i, "=")
+			if len(parts) != 2 {
+				continue
+			}
+			filter := types.Filter{
+				Name:   aws.String(parts[0]),
+				Values: []string{parts[1]},
+			}
+
+			filters = append(filters, filter)
+		}
+	}
+	return filters
+}
+
+// DiscoverInterfaces returns network interfaces in the instance VPC and AZ
+// matching the filters.
+func (p *AWSProvider) DiscoverInterfaces(ctx context.Context) ([]NetworkInterface, error) {
+	filters := []types.Filter{
+		{
+			Name:   aws.String("vpc-id"),
+			Values: []string{p.instance.VPC},
+		},
+		{
+			Name:   aws.String("availability-zone"),
+			Values: []string{p.instance.AZ},
+		},
+	}
+	_, interfaceFilters := p.filters()
+	params := &ec2.DescribeNetworkInterfacesInput{
+		Filters: append(filters, interfaceFilters...),
+	}
+	r, err := p.ec2c.DescribeNetworkInterfaces(ctx, params)
+	var ns []NetworkInterface
+	if err != nil {
+		log.Printf("Failed to find network interfaces: %q.\n", err)
+		return ns, err
+	}
+	for _, i := range r.NetworkInterfaces {
+		if !p.managed(i.TagSet) {
+			log.Printf("WARNING: Skipping network interface %q without the managed-by tag.\n", *i.NetworkInterfaceId)
+			continue
+		}
+		var n NetworkInterface
+		n.ID = *i.NetworkInterfaceId
+		if p.interfaceNodeIDSource == nodeIDSourceDescription {
+			n.NodeID = aws.ToString(i.Description)
+		} else {
+			n.NodeID = p.getResourceTagValue(ctx, *i.NetworkInterfaceId, p.nodeIDTag)
+		}
+		n.IPAddress = *i.PrivateIpAddress
+		n.MACAddress = aws.ToString(i.MacAddress)
+		for _, a := range i.PrivateIpAddresses {
+			if !aws.ToBool(a.Primary) {
+				n.SecondaryIPAddresses = append(n.SecondaryIPAddresses, *a.PrivateIpAddress)
+			}
+		}
+		n.SubnetCIDR = p.subnetCIDR(ctx, aws.ToString(i.SubnetId))
+		if i.Attachment != nil {
+			n.AttachmentID = *i.Attachment.AttachmentId
+			if aws.ToString(i.Attachment.InstanceId) == p.instance.ID {
+				p.fixDeleteOnTermination(ctx, n.ID, i.Attachment)
+				p.fixSecurityGroups(ctx, n.ID, i.Groups)
+			}
+		}
+		if i.Status == types.NetworkInterfaceStatusAvailable {
+			n.Available = true
+		} else {
+			n.Available = false
+			n.AttachedTo = *i.Attachment.InstanceId
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+// subnetCIDR returns the CIDR block of subnet id, or an empty string if it
+// cannot be found.
+func (p *AWSProvider) subnetCIDR(ctx context.Context, id string) string {
+	if c, ok := p.subnets[id]; ok {
+		return c
+	}
+	r, err := p.ec2c.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+		SubnetIds: []string{id},
+	})
+	if err != nil || len(r.Subnets) == 0 {
+		log.Printf("Failed to get CIDR block of subnet %q: %v.\n", id, err)
+		return ""
+	}
+	if p.subnets == nil {
+		p.subnets = map[string]string{}
+	}
+	p.subnets[id] = *r.Subnets[0].CidrBlock
+	return p.subnets[id]
+}
+
+// DiscoverVolumes returns volumes in the instance AZ matching the filters.
+// Volumes in other AZs can never be attached, so they are skipped, but
+// logged if there are no volumes in the instance AZ.
+func (p *AWSProvider) DiscoverVolumes(ctx context.Context) ([]Volume, error) {
+	volumeFilters, _ := p.filters()
+	params := &ec2.DescribeVolumesInput{
+		Filters: volumeFilters,
+	}
+	r, err := p.ec2c.DescribeVolumes(ctx, params)
+	var vs []Volume
+	if err != nil {
+		log.Printf("Failed to find volumes: %q.\n", err)
+		return vs, err
+	}
+	var elsewhere []string
+	for _, i := range r.Volumes {
+		if hasTag(i.Tags, awsReplacedByTag) {
+			continue
+		}
+		if !p.managed(i.Tags) {
+			log.Printf("WARNING: Skipping volume %q without the managed-by tag.\n", *i.VolumeId)
+			continue
+		}
+		if *i.AvailabilityZone != p.instance.AZ {
+			elsewhere = append(elsewhere, fmt.Sprintf("%s (%s)", *i.VolumeId, *i.AvailabilityZone))
+			continue
+		}
+		var v Volume
+		v.ID = *i.VolumeId
+		v.NodeID = p.volumeNodeID(ctx, *i.VolumeId)
+		v.Snapshot = aws.ToString(i.SnapshotId)
+		v.WarmedUp = hasTag(i.Tags, awsWarmedUpTag)
+		if !attachedTo(i, p.instance.ID) {
+			if p.requireEncrypted && !aws.ToBool(i.Encrypted) {
+				log.Printf("WARNING: Skipping unencrypted volume %q of node %q, encryption is required.\n", v.ID, v.NodeID)
+				continue
+			}
+			if err := p.checkKMSKey(ctx, i); err != nil {
+				log.Printf("WARNING: Skipping volume %q of node %q: %q.\n", v.ID, v.NodeID, err)
+				continue
+			}
+		}
+		if i.State == types.VolumeStateAvailable {
+			v.Available = true
+		} else {
+			// Multi-attach volumes may be attached to several instances,
+			// of which this one takes precedence.
+			for _, a := range i.Attachments {
+				if v.AttachedTo != p.instance.ID {
+					v.AttachedTo = *a.InstanceId
+				}
+				if *a.InstanceId == p.instance.ID {
+					v.Device = aws.ToString(a.Device)
+				}
+			}
+			v.Available = false
+			v.MultiAttach = aws.ToBool(i.MultiAttachEnabled)
+		}
+		vs = append(vs, v)
+	}
+	if len(vs) == 0 && len(elsewhere) > 0 {
+		log.Printf("All matching volumes are outside of the instance AZ %q: %s.\n", p.instance.AZ, strings.Join(elsewhere, ", "))
+	}
+	return vs, nil
+}
+
+// volumeNodeID returns the node ID of volume id read from its node ID source.
+func (p *AWSProvider) volumeNodeID(ctx context.Context, id string) string {
+	if p.volumeNodeIDSource == nodeIDSourceName {
+		return p.getResourceTagValue(ctx, id, "Name")
+	}
+	return p.getResourceTagValue(ctx, id, p.nodeIDTag)
+}
+
+// AttachVolume attaches a volume v to the instance as block device d.
+func (p *AWSProvider) AttachVolume(ctx context.Context, v Volume, d string) error {
+	params := &ec2.AttachVolumeInput{
+		Device:     aws.String(d),
+		InstanceId: aws.String(p.instance.ID),
+		VolumeId:   aws.String(v.ID),
+	}
+	// FIXME: wait for the attachment to happen?
+	if _, err := p.ec2c.AttachVolume(ctx, params); err != nil {
+		return err
+	}
+	p.tagAttachment(ctx, v.ID)
+	return nil
+}
+
+// DetachVolume detaches a volume v from the instance.
+func (p *AWSProvider) DetachVolume(ctx context.Context, v Volume) error {
+	_, err := p.ec2c.DetachVolume(ctx, &ec2.DetachVolumeInput{
+		InstanceId: aws.String(p.instance.ID),
+		VolumeId:   aws.String(v.ID),
+	})
+	if err != nil {
+		return err
+	}
+	p.untagAttachment(ctx, v.ID)
+	return nil
+}
+
+// AttachInterface attaches a network interface n to the instance.
+func (p *AWSProvider) AttachInterface(ctx context.Context, n NetworkInterface) error {
+	params := &ec2.AttachNetworkInterfaceInput{
+		InstanceId:         aws.String(p.instance.ID),
+		NetworkInterfaceId: aws.String(n.ID),
+		DeviceIndex:        aws.Int32(p.freeDeviceIndex(ctx)),
+	}
+	// FIXME: wait for the attachment to happen?
+	if _, err := p.ec2c.AttachNetworkInterface(ctx, params); err != nil {
+		return err
+	}
+	p.tagAttachment(ctx, n.ID)
+	return nil
+}
+
+// freeDeviceIndex returns the preferred device index, or the next one not
+// taken by another network interface of the instance. If the instance cannot
+// be described, the preferred index is returned.
+func (p *AWSProvider) freeDeviceIndex(ctx context.Context) int32 {
+	r, err := p.ec2c.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{p.instance.ID},
+	})
+	if err != nil {
+		log.Printf("Failed to look up free device indexes: %q.\n", err)
+		return p.deviceIndex
+	}
+	used := map[int32]bool{}
+	for _, rs := range r.Reservations {
+		for _, i := range rs.Instances {
+			for _, n := range i.NetworkInterfaces {
+				if n.Attachment != nil {
+					used[aws.ToInt32(n.Attachment.DeviceIndex)] = true
+				}
+			}
+		}
+	}
+	i := p.deviceIndex
+	for used[i] {
+		i++
+	}
+	if i != p.deviceIndex {
+		log.Printf("Device index %d is taken, using %d.\n", p.deviceIndex, i)
+	}
+	return i
+}
+
+// DetachInterface detaches a network interface n.
+func (p *AWSProvider) DetachInterface(ctx context.Context, n NetworkInterface) error {
+	_, err := p.ec2c.DetachNetworkInterface(ctx, &ec2.DetachNetworkInterfaceInput{
+		AttachmentId: aws.String(n.AttachmentID),
+	})
+	if err != nil {
+		return err
+	}
+	p.untagAttachment(ctx, n.ID)
+	return nil
+}
+
+// RemoveNodeID deletes the node ID tag of resources ids, for example the
+// volume and network interface of a decommissioned node, so that they are
+// never picked up again.
+func (p *AWSProvider) RemoveNodeID(ctx context.Context, ids ...string) error {
+	for _, id := range ids {
+		log.Printf("Removing %q tag of %q.\n", p.nodeIDTag, id)
+	}
+	_, err := p.ec2c.DeleteTags(ctx, &ec2.DeleteTagsInput{
+		Resources: ids,
+		Tags:      []types.Tag{{Key: aws.String(p.nodeIDTag)}},
+	})
+	return err
+}
+
+// DisableSourceDestCheck sets SourceDestCheck attribute to false on all
+// instance network interfaces.
+func (p *AWSProvider) DisableSourceDestCheck(ctx context.Context) error {
+	i, err := p.ec2c.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{p.instance.ID}},
+	)
+	if err != nil {
+		return err
+	}
+	for _, n := range i.Reservations[0].Instances[0].NetworkInterfaces {
+		attr := &ec2.ModifyNetworkInterfaceAttributeInput{
+			NetworkInterfaceId: n.NetworkInterfaceId,
+			SourceDestCheck:    &types.AttributeBooleanValue{Value: aws.Bool(false)},
+		}
+		log.Printf("Disabling SourceDestCheck on %q network interface.\n", *n.NetworkInterfaceId)
+		if _, err := p.ec2c.ModifyNetworkInterfaceAttribute(ctx, attr); err != nil {
+			log.Printf("Failed to disable SourceDestCheck attribute of %q network interface: %q.\n", *n.NetworkInterfaceId, err)
+			continue
+		}
+		if p.sourceDestCheck == nil {
+			p.sourceDestCheck = map[string]bool{}
+		}
+		if _, ok := p.sourceDestCheck[*n.NetworkInterfaceId]; !ok {
+			p.sourceDestCheck[*n.NetworkInterfaceId] = aws.ToBool(n.SourceDestCheck)
+		}
+	}
+	return nil
+}
+
+// RestoreSourceDestCheck restores the SourceDestCheck attribute of network
+// interfaces changed by DisableSourceDestCheck to its original value. Every
+// network interface is tried, and the first error is returned. Interfaces
+// which failed are kept, so that they are retried on the next call.
+func (p *AWSProvider) RestoreSourceDestCheck(ctx context.Context) error {
+	var first error
+	for id, v := range p.sourceDestCheck {
+		if !v {
+			delete(p.sourceDestCheck, id)
+			continue
+		}
+		attr := &ec2.ModifyNetworkInterfaceAttributeInput{
+			NetworkInterfaceId: aws.String(id),
+			SourceDestCheck:    &types.AttributeBooleanValue{Value: aws.Bool(v)},
+		}
+		log.Printf("Restoring SourceDestCheck on %q network interface.\n", id)
+		if _, err := p.ec2c.ModifyNetworkInterfaceAttribute(ctx, attr); err != nil {
+			log.Printf("Failed to restore SourceDestCheck attribute of %q network interface: %q.\n", id, err)
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		delete(p.sourceDestCheck, id)
+	}
+	return first
+}
+
+// awsPreferredNodeIDTag is the instance tag holding the node ID preferred by
+// the instance.
+const awsPreferredNodeIDTag = "SmilodonPreferredNodeID"
+
+// PreferredNodeID returns the node ID preferred by the instance, read from
+// its SmilodonPreferredNodeID tag.
+func (p *AWSProvider) PreferredNodeID(ctx context.Context) (string, error) {
+	return p.instanceTag(ctx, awsPreferredNodeIDTag)
+}
+
+// UsedDevices returns the device names of all block device mappings of the
+// instance.
+func (p *AWSProvider) UsedDevices(ctx context.Context) ([]string, error) {
+	resp, err := p.ec2c.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{p.instance.ID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ds []string
+	for _, res := range resp.Reservations {
+		for _, i := range res.Instances {
+			for _, m := range i.BlockDeviceMappings {
+				ds = append(ds, aws.ToString(m.DeviceName))
+			}
+		}
+	}
+	return ds, nil
+}
+
+// VolumeHolder returns the instance volume v is attached to and the state of
+// the attachment, preferring this instance for multi-attach volumes.
+func (p *AWSProvider) VolumeHolder(ctx context.Context, v Volume) (string, string, error) {
+	r, err := p.ec2c.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: []string{v.ID},
+	})
+	if err != nil {
+		return "", "", err
+	}
+	var holder, state string
+	for _, i := range r.Volumes {
+		for _, a := range i.Attachments {
+			if holder != p.instance.ID {
+				holder, state = aws.ToString(a.InstanceId), string(a.State)
+			}
+		}
+	}
+	return holder, state, nil
+}
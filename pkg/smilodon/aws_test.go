@@ -0,0 +1,50 @@
+package smilodon
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestBuildFilters(t *testing.T) {
+	tagKey := types.Filter{Name: aws.String("tag-key"), Values: []string{"NodeID"}}
+	env := types.Filter{Name: aws.String("tag:Env"), Values: []string{"dev"}}
+	service := types.Filter{Name: aws.String("tag:Service"), Values: []string{"etcd"}}
+	for _, tc := range []struct {
+		name string
+		t, f string
+		want []types.Filter
+	}{
+		{
+			name: "none",
+		},
+		{
+			name: "tag key",
+			t:    "NodeID",
+			want: []types.Filter{tagKey},
+		},
+		{
+			name: "tag key and filters",
+			t:    "NodeID",
+			f:    "tag:Env=dev,tag:Service=etcd",
+			want: []types.Filter{tagKey, env, service},
+		},
+		{
+			name: "filters only",
+			f:    "tag:Env=dev",
+			want: []types.Filter{env},
+		},
+		{
+			name: "invalid filters",
+			t:    "NodeID",
+			f:    "tag:Env=dev,invalid,a=b=c",
+			want: []types.Filter{tagKey, env},
+		},
+	} {
+		if got := buildFilters(tc.t, tc.f); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: buildFilters(%q, %q) = %v, want %v", tc.name, tc.t, tc.f, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package smilodon
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Tags recording which instance a volume or network interface is attached to.
+const (
+	awsAttachedToTag       = "SmilodonAttachedTo"
+	awsAttachedHostnameTag = "SmilodonAttachedHostname"
+	awsAttachedAtTag       = "SmilodonAttachedAt"
+)
+
+// tagAttachment tags resource id with the instance ID, hostname and time it
+// is attached at, if enabled. Failures are logged and otherwise ignored.
+func (p *AWSProvider) tagAttachment(ctx context.Context, id string) {
+	if !p.attachmentTags {
+		return
+	}
+	h, _ := os.Hostname()
+	_, err := p.ec2c.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{id},
+		Tags: []types.Tag{
+			{Key: aws.String(awsAttachedToTag), Value: aws.String(p.instance.ID)},
+			{Key: aws.String(awsAttachedHostnameTag), Value: aws.String(h)},
+			{Key: aws.String(awsAttachedAtTag), Value: aws.String(time.Now().UTC().Format(time.RFC3339))},
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to tag attachment of %q: %q.\n", id, err)
+	}
+}
+
+// untagAttachment removes the attachment tags of resource id, if enabled.
+// Failures are logged and otherwise ignored.
+func (p *AWSProvider) untagAttachment(ctx context.Context, id string) {
+	if !p.attachmentTags {
+		return
+	}
+	_, err := p.ec2c.DeleteTags(ctx, &ec2.DeleteTagsInput{
+		Resources: []string{id},
+		Tags: []types.Tag{
+			{Key: aws.String(awsAttachedToTag)},
+			{Key: aws.String(awsAttachedHostnameTag)},
+			{Key: aws.String(awsAttachedAtTag)},
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to remove attachment tags of %q: %q.\n", id, err)
+	}
+}
@@ -0,0 +1,47 @@
+package smilodon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// debugAWS adds a middleware to stack logging every call with its
+// parameters, every failed attempt with its request ID and whether it is
+// retried, and the result with its request ID. Only parameters and results
+// are logged, never the signed HTTP request, so that credentials and session
+// tokens do not end up in the log.
+func debugAWS(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("SmilodonDebug", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		service, name := awsOperation(ctx)
+		op := service + "/" + name
+		log.Printf("DEBUG: %s: %s.\n", op, prettify(in.Parameters))
+		out, md, err := next.HandleInitialize(ctx, in)
+		results, _ := retry.GetAttemptResults(md)
+		for i, a := range results.Results {
+			if a.Err != nil {
+				log.Printf("DEBUG: %s attempt %d failed, request ID %q, retrying: %t: %q.\n", op, i+1, awsRequestID(a.ResponseMetadata, a.Err), a.Retried, a.Err)
+			}
+		}
+		if err != nil && len(results.Results) == 0 {
+			log.Printf("DEBUG: %s failed: %q.\n", op, err)
+		}
+		if err == nil {
+			log.Printf("DEBUG: %s succeeded, request ID %q: %s.\n", op, awsRequestID(md, nil), prettify(out.Result))
+		}
+		return out, md, err
+	}), middleware.After)
+}
+
+// prettify returns v as JSON, or formatted with %v if it cannot be encoded.
+func prettify(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
@@ -0,0 +1,66 @@
+package smilodon
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// encrypt makes volume creation params encrypted if encryption is required,
+// with the configured KMS key or the account default one.
+func (p *AWSProvider) encrypt(params *ec2.CreateVolumeInput) {
+	if !p.requireEncrypted {
+		return
+	}
+	params.Encrypted = aws.Bool(true)
+	if p.kmsKeyID != "" {
+		params.KmsKeyId = aws.String(p.kmsKeyID)
+	}
+}
+
+// attachedTo checks whether volume v is attached to instance id.
+func attachedTo(v types.Volume, id string) bool {
+	for _, a := range v.Attachments {
+		if aws.ToString(a.InstanceId) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveKMSKey returns the ARN of the configured KMS key, which may be given
+// as a key ID, ARN or alias.
+func (p *AWSProvider) resolveKMSKey(ctx context.Context) (string, error) {
+	if p.kmsKeyARN != "" {
+		return p.kmsKeyARN, nil
+	}
+	out, err := p.kms.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(p.kmsKeyID)})
+	if err != nil {
+		return "", err
+	}
+	p.kmsKeyARN = aws.ToString(out.KeyMetadata.Arn)
+	return p.kmsKeyARN, nil
+}
+
+// checkKMSKey checks that volume v is encrypted with the configured KMS key,
+// if any.
+func (p *AWSProvider) checkKMSKey(ctx context.Context, v types.Volume) error {
+	if p.kmsKeyID == "" {
+		return nil
+	}
+	arn, err := p.resolveKMSKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to resolve KMS key %q: %v", p.kmsKeyID, err)
+	}
+	if !aws.ToBool(v.Encrypted) {
+		return fmt.Errorf("not encrypted with KMS key %q", arn)
+	}
+	if k := aws.ToString(v.KmsKeyId); k != arn {
+		return fmt.Errorf("encrypted with KMS key %q instead of %q", k, arn)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package smilodon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// fixDeleteOnTermination corrects the DeleteOnTermination flag of attachment
+// a of network interface id, if it differs from the desired one. A wrongly
+// set flag silently destroys the interface, and with it the stable identity
+// of the node, when the instance dies.
+func (p *AWSProvider) fixDeleteOnTermination(ctx context.Context, id string, a *types.NetworkInterfaceAttachment) {
+	if aws.ToBool(a.DeleteOnTermination) == p.deleteOnTermination {
+		return
+	}
+	log.Printf("Setting DeleteOnTermination of %q attachment %q to %t.\n", id, aws.ToString(a.AttachmentId), p.deleteOnTermination)
+	_, err := p.ec2c.ModifyNetworkInterfaceAttribute(ctx, &ec2.ModifyNetworkInterfaceAttributeInput{
+		NetworkInterfaceId: aws.String(id),
+		Attachment: &types.NetworkInterfaceAttachmentChanges{
+			AttachmentId:        a.AttachmentId,
+			DeleteOnTermination: aws.Bool(p.deleteOnTermination),
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to set DeleteOnTermination of %q: %q.\n", id, err)
+		return
+	}
+	a.DeleteOnTermination = aws.Bool(p.deleteOnTermination)
+}
+
+// resolveSecurityGroups returns the IDs of the desired security groups, with
+// tag lookups resolved in the instance VPC. It fails if a lookup matches no
+// security group, rather than stripping the network interface of it.
+func (p *AWSProvider) resolveSecurityGroups(ctx context.Context) ([]string, error) {
+	var ids []string
+	for _, g := range p.securityGroups {
+		g = strings.TrimSpace(g)
+		if !strings.HasPrefix(g, "tag:") {
+			ids = append(ids, g)
+			continue
+		}
+		kv := strings.SplitN(g, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid security group lookup %q", g)
+		}
+		r, err := p.ec2c.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+			Filters: []types.Filter{
+				{Name: aws.String("vpc-id"), Values: []string{p.instance.VPC}},
+				{Name: aws.String(kv[0]), Values: []string{kv[1]}},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(r.SecurityGroups) == 0 {
+			return nil, fmt.Errorf("no security group matches %q", g)
+		}
+		for _, sg := range r.SecurityGroups {
+			ids = append(ids, aws.ToString(sg.GroupId))
+		}
+	}
+	sort.Strings(ids)
+	var unique []string
+	for i, id := range ids {
+		if i == 0 || id != ids[i-1] {
+			unique = append(unique, id)
+		}
+	}
+	return unique, nil
+}
+
+// fixSecurityGroups replaces security groups gs of network interface id with
+// the desired ones, if they differ.
+func (p *AWSProvider) fixSecurityGroups(ctx context.Context, id string, gs []types.GroupIdentifier) {
+	if len(p.securityGroups) == 0 {
+		return
+	}
+	want, err := p.resolveSecurityGroups(ctx)
+	if err != nil {
+		log.Printf("Failed to resolve security groups: %q.\n", err)
+		return
+	}
+	var have []string
+	for _, g := range gs {
+		have = append(have, aws.ToString(g.GroupId))
+	}
+	sort.Strings(have)
+	if strings.Join(have, ",") == strings.Join(want, ",") {
+		return
+	}
+	log.Printf("Setting security groups of %q to %q, was %q.\n", id, want, have)
+	_, err = p.ec2c.ModifyNetworkInterfaceAttribute(ctx, &ec2.ModifyNetworkInterfaceAttributeInput{
+		NetworkInterfaceId: aws.String(id),
+		Groups:             want,
+	})
+	if err != nil {
+		log.Printf("Failed to set security groups of %q: %q.\n", id, err)
+	}
+}
@@ -0,0 +1,88 @@
+package smilodon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// FastSnapshotRestores returns the fast snapshot restore states of snapshots
+// ids by snapshot ID and availability zone.
+func (p *AWSProvider) FastSnapshotRestores(ctx context.Context, ids []string) (map[string]map[string]string, error) {
+	params := &ec2.DescribeFastSnapshotRestoresInput{
+		Filters: []types.Filter{{Name: aws.String("snapshot-id"), Values: ids}},
+	}
+	states := map[string]map[string]string{}
+	for {
+		r, err := p.ec2c.DescribeFastSnapshotRestores(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range r.FastSnapshotRestores {
+			id := aws.ToString(i.SnapshotId)
+			if states[id] == nil {
+				states[id] = map[string]string{}
+			}
+			states[id][aws.ToString(i.AvailabilityZone)] = string(i.State)
+		}
+		if aws.ToString(r.NextToken) == "" {
+			return states, nil
+		}
+		params.NextToken = r.NextToken
+	}
+}
+
+// EnableFastSnapshotRestores enables fast snapshot restores of snapshot id in
+// availability zones azs, which reports failures per availability zone.
+func (p *AWSProvider) EnableFastSnapshotRestores(ctx context.Context, id string, azs []string) error {
+	r, err := p.ec2c.EnableFastSnapshotRestores(ctx, &ec2.EnableFastSnapshotRestoresInput{
+		SourceSnapshotIds: []string{id},
+		AvailabilityZones: azs,
+	})
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, u := range r.Unsuccessful {
+		for _, e := range u.FastSnapshotRestoreStateErrors {
+			if e.Error != nil {
+				errs = append(errs, aws.ToString(e.AvailabilityZone)+": "+aws.ToString(e.Error.Message))
+			}
+		}
+	}
+	return fastSnapshotRestoreErrors(errs)
+}
+
+// DisableFastSnapshotRestores disables fast snapshot restores of snapshot id
+// in availability zones azs, which reports failures per availability zone.
+func (p *AWSProvider) DisableFastSnapshotRestores(ctx context.Context, id string, azs []string) error {
+	r, err := p.ec2c.DisableFastSnapshotRestores(ctx, &ec2.DisableFastSnapshotRestoresInput{
+		SourceSnapshotIds: []string{id},
+		AvailabilityZones: azs,
+	})
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, u := range r.Unsuccessful {
+		for _, e := range u.FastSnapshotRestoreStateErrors {
+			if e.Error != nil {
+				errs = append(errs, aws.ToString(e.AvailabilityZone)+": "+aws.ToString(e.Error.Message))
+			}
+		}
+	}
+	return fastSnapshotRestoreErrors(errs)
+}
+
+// fastSnapshotRestoreErrors returns an error listing the per availability
+// zone failures errs, or nil if there are none.
+func fastSnapshotRestoreErrors(errs []string) error {
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package smilodon
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// imdsTags reads the tags of the instance from the metadata service, if
+// instance metadata tags are enabled, which saves EC2 calls.
+type imdsTags struct {
+	metadata *imds.Client
+
+	once sync.Once
+	// keys are the tag keys of the instance, nil if metadata tags are
+	// disabled.
+	keys map[string]bool
+}
+
+// enabled checks whether instance metadata tags are enabled. It is checked
+// once, enabling them later takes effect on restart.
+func (t *imdsTags) enabled(ctx context.Context) bool {
+	t.once.Do(func() {
+		ks, err := metadataValue(ctx, t.metadata, "tags/instance")
+		if err != nil {
+			log.Println("Instance metadata tags are disabled, reading instance tags from EC2.")
+			return
+		}
+		t.keys = map[string]bool{}
+		for _, k := range strings.Fields(ks) {
+			t.keys[k] = true
+		}
+	})
+	return t.keys != nil
+}
+
+// get returns the value of instance tag k, or an empty string if the
+// instance has no tag k.
+func (t *imdsTags) get(ctx context.Context, k string) (string, error) {
+	if !t.keys[k] {
+		return "", nil
+	}
+	return metadataValue(ctx, t.metadata, "tags/instance/"+k)
+}
+
+// instanceTag returns the value of tag k of the instance, read from the
+// metadata service if instance metadata tags are enabled.
+func (p *AWSProvider) instanceTag(ctx context.Context, k string) (string, error) {
+	if p.imdsTags.enabled(ctx) {
+		v, err := p.imdsTags.get(ctx, k)
+		if err == nil {
+			return v, nil
+		}
+		log.Printf("Failed to read instance tag %q from the metadata service: %q.\n", k, err)
+	}
+	resp, err := p.ec2c.DescribeTags(ctx, &ec2.DescribeTagsInput{
+		Filters: []types.Filter{
+			{Name: aws.String("resource-id"), Values: []string{p.instance.ID}},
+			{Name: aws.String("key"), Values: []string{k}},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, t := range resp.Tags {
+		return aws.ToString(t.Value), nil
+	}
+	return "", nil
+}
+
+// metadataVPC returns the VPC ID of the primary network interface of the
+// instance from the metadata service.
+func (p *AWSProvider) metadataVPC(ctx context.Context) (string, error) {
+	mac, err := metadataValue(ctx, p.imdsTags.metadata, "mac")
+	if err != nil {
+		return "", err
+	}
+	return metadataValue(ctx, p.imdsTags.metadata, "network/interfaces/macs/"+mac+"/vpc-id")
+}
+
+// metadataValue returns the instance metadata at path p of metadata service
+// client c.
+func metadataValue(ctx context.Context, c *imds.Client, p string) (string, error) {
+	out, err := c.GetMetadata(ctx, &imds.GetMetadataInput{Path: p})
+	if err != nil {
+		return "", err
+	}
+	defer out.Content.Close()
+	b, err := ioutil.ReadAll(out.Content)
+	return string(b), err
+}
@@ -0,0 +1,49 @@
+package smilodon
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// parseManagedByTag returns the key and value of ownership tag t, of the form
+// key=value.
+func parseManagedByTag(t string) (*types.Tag, error) {
+	i := strings.Index(t, "=")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid managed-by tag %q, expected key=value", t)
+	}
+	return &types.Tag{Key: aws.String(t[:i]), Value: aws.String(t[i+1:])}, nil
+}
+
+// managed checks whether a resource with tags carries the ownership tag, so
+// that it may be attached, detached or modified. Without an ownership tag,
+// all resources are managed.
+func (p *AWSProvider) managed(tags []types.Tag) bool {
+	if p.managedBy == nil {
+		return true
+	}
+	for _, t := range tags {
+		if *t.Key == *p.managedBy.Key && aws.ToString(t.Value) == *p.managedBy.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// ownTags returns tags of a resource created by smilodon with the ownership
+// tag added, if set.
+func (p *AWSProvider) ownTags(tags []types.Tag) []types.Tag {
+	if p.managedBy == nil || p.managed(tags) {
+		return tags
+	}
+	var own []types.Tag
+	for _, t := range tags {
+		if *t.Key != *p.managedBy.Key {
+			own = append(own, t)
+		}
+	}
+	return append(own, *p.managedBy)
+}
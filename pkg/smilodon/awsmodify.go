@@ -0,0 +1,129 @@
+package smilodon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Tags declaring the desired state of a volume, which take precedence over
+// the configured one.
+const (
+	awsVolumeTypeTag       = "smilodon:type"
+	awsVolumeSizeTag       = "smilodon:size"
+	awsVolumeIOPSTag       = "smilodon:iops"
+	awsVolumeThroughputTag = "smilodon:throughput"
+)
+
+// DescribeVolume returns the type, size, IOPS and throughput of volume v.
+func (p *AWSProvider) DescribeVolume(ctx context.Context, v Volume) (volumeSpec, error) {
+	cur, err := p.describeVolume(ctx, v)
+	if err != nil {
+		return volumeSpec{}, err
+	}
+	if cur == nil {
+		return volumeSpec{}, fmt.Errorf("volume %q not found", v.ID)
+	}
+	return awsVolumeSpec(*cur), nil
+}
+
+// describeVolume returns volume v, or nil if it does not exist.
+func (p *AWSProvider) describeVolume(ctx context.Context, v Volume) (*types.Volume, error) {
+	r, err := p.ec2c.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{VolumeIds: []string{v.ID}})
+	if err != nil || len(r.Volumes) == 0 {
+		return nil, err
+	}
+	return &r.Volumes[0], nil
+}
+
+// awsVolumeSpec returns the type, size, IOPS and throughput of volume v.
+func awsVolumeSpec(v types.Volume) volumeSpec {
+	return volumeSpec{
+		Type:       string(v.VolumeType),
+		Size:       int64(aws.ToInt32(v.Size)),
+		IOPS:       int64(aws.ToInt32(v.Iops)),
+		Throughput: int64(aws.ToInt32(v.Throughput)),
+	}
+}
+
+// ModifyVolume modifies volume v to match spec, overridden by its
+// smilodon:type, smilodon:size, smilodon:iops and smilodon:throughput tags.
+// It returns whether the latest modification is still in progress, during
+// which the volume cannot be modified again. Volumes are never shrunk.
+func (p *AWSProvider) ModifyVolume(ctx context.Context, v Volume, spec volumeSpec) (bool, error) {
+	ms, err := p.ec2c.DescribeVolumesModifications(ctx, &ec2.DescribeVolumesModificationsInput{
+		VolumeIds: []string{v.ID},
+	})
+	if err != nil {
+		return false, err
+	}
+	var latest time.Time
+	var state types.VolumeModificationState
+	for _, m := range ms.VolumesModifications {
+		if m.StartTime != nil && m.StartTime.After(latest) {
+			latest, state = *m.StartTime, m.ModificationState
+		}
+	}
+	if state == types.VolumeModificationStateModifying {
+		return true, nil
+	}
+	// Optimizing volumes are resized already, but cannot be modified yet.
+	if state == types.VolumeModificationStateOptimizing {
+		return false, nil
+	}
+	vol, err := p.describeVolume(ctx, v)
+	if err != nil || vol == nil {
+		return false, err
+	}
+	for _, t := range vol.Tags {
+		var err error
+		switch aws.ToString(t.Key) {
+		case awsVolumeTypeTag:
+			spec.Type = aws.ToString(t.Value)
+		case awsVolumeSizeTag:
+			spec.Size, err = strconv.ParseInt(aws.ToString(t.Value), 10, 32)
+		case awsVolumeIOPSTag:
+			spec.IOPS, err = strconv.ParseInt(aws.ToString(t.Value), 10, 32)
+		case awsVolumeThroughputTag:
+			spec.Throughput, err = strconv.ParseInt(aws.ToString(t.Value), 10, 32)
+		}
+		if err != nil {
+			log.Printf("Ignoring invalid %q tag of volume %q: %q.\n", aws.ToString(t.Key), v.ID, err)
+		}
+	}
+	cur := awsVolumeSpec(*vol)
+	params := &ec2.ModifyVolumeInput{VolumeId: aws.String(v.ID)}
+	changed := false
+	if spec.Type != "" && spec.Type != cur.Type {
+		params.VolumeType = types.VolumeType(spec.Type)
+		changed = true
+	}
+	if spec.Size > cur.Size {
+		params.Size = aws.Int32(int32(spec.Size))
+		changed = true
+	} else if spec.Size != 0 && spec.Size < cur.Size {
+		log.Printf("Not shrinking volume %q from %d to %d GiB.\n", v.ID, cur.Size, spec.Size)
+	}
+	if spec.IOPS != 0 && spec.IOPS != cur.IOPS {
+		params.Iops = aws.Int32(int32(spec.IOPS))
+		changed = true
+	}
+	if spec.Throughput != 0 && spec.Throughput != cur.Throughput {
+		params.Throughput = aws.Int32(int32(spec.Throughput))
+		changed = true
+	}
+	if !changed {
+		return false, nil
+	}
+	log.Printf("Modifying volume %q: %s.\n", v.ID, prettify(params))
+	if _, err := p.ec2c.ModifyVolume(ctx, params); err != nil {
+		return false, err
+	}
+	return true, nil
+}
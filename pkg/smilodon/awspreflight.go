@@ -0,0 +1,138 @@
+package smilodon
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// Outcomes of a permission check.
+const (
+	PermissionAllowed = "allowed"
+	PermissionDenied  = "denied"
+	// PermissionUnknown means the dry run failed for another reason, for
+	// example a placeholder resource which does not exist.
+	PermissionUnknown = "unknown"
+)
+
+// PermissionCheck is the outcome of dry-running an EC2 action.
+type PermissionCheck struct {
+	Action string `json:"action"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Placeholder resource IDs used when no candidate resource is found.
+const (
+	preflightVolumeID     = "vol-00000000000000000"
+	preflightInterfaceID  = "eni-00000000000000000"
+	preflightAttachmentID = "eni-attach-00000000000000000"
+)
+
+// Preflight dry-runs the EC2 actions smilodon needs against the instance and
+// the first candidate volume and network interface, and reports for each
+// whether it is allowed. d is the block device volumes are attached as.
+func (p *AWSProvider) Preflight(ctx context.Context, d string) []PermissionCheck {
+	dry := aws.Bool(true)
+	instance := aws.String(p.instance.ID)
+	volume, iface, attachment := preflightVolumeID, preflightInterfaceID, preflightAttachmentID
+	if vs, err := p.DiscoverVolumes(ctx); err == nil && len(vs) > 0 {
+		volume = vs[0].ID
+	}
+	if ns, err := p.DiscoverInterfaces(ctx); err == nil && len(ns) > 0 {
+		iface = ns[0].ID
+		attachment = firstNonEmpty(ns[0].AttachmentID, attachment)
+	}
+	filters := []types.Filter{{Name: aws.String("resource-id"), Values: []string{p.instance.ID}}}
+
+	var checks []PermissionCheck
+	check := func(action string, err error) {
+		checks = append(checks, dryRun(action, err))
+	}
+	_, err := p.ec2c.DescribeInstances(ctx, &ec2.DescribeInstancesInput{DryRun: dry, InstanceIds: []string{p.instance.ID}})
+	check("ec2:DescribeInstances", err)
+	_, err = p.ec2c.DescribeTags(ctx, &ec2.DescribeTagsInput{DryRun: dry, Filters: filters})
+	check("ec2:DescribeTags", err)
+	_, err = p.ec2c.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{DryRun: dry})
+	check("ec2:DescribeVolumes", err)
+	_, err = p.ec2c.DescribeNetworkInterfaces(ctx, &ec2.DescribeNetworkInterfacesInput{DryRun: dry})
+	check("ec2:DescribeNetworkInterfaces", err)
+	_, err = p.ec2c.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{DryRun: dry})
+	check("ec2:DescribeSubnets", err)
+	_, err = p.ec2c.AttachVolume(ctx, &ec2.AttachVolumeInput{
+		DryRun:     dry,
+		Device:     aws.String(d),
+		InstanceId: instance,
+		VolumeId:   aws.String(volume),
+	})
+	check("ec2:AttachVolume", err)
+	_, err = p.ec2c.DetachVolume(ctx, &ec2.DetachVolumeInput{
+		DryRun:     dry,
+		InstanceId: instance,
+		VolumeId:   aws.String(volume),
+	})
+	check("ec2:DetachVolume", err)
+	_, err = p.ec2c.AttachNetworkInterface(ctx, &ec2.AttachNetworkInterfaceInput{
+		DryRun:             dry,
+		DeviceIndex:        aws.Int32(p.deviceIndex),
+		InstanceId:         instance,
+		NetworkInterfaceId: aws.String(iface),
+	})
+	check("ec2:AttachNetworkInterface", err)
+	_, err = p.ec2c.DetachNetworkInterface(ctx, &ec2.DetachNetworkInterfaceInput{
+		DryRun:       dry,
+		AttachmentId: aws.String(attachment),
+	})
+	check("ec2:DetachNetworkInterface", err)
+	_, err = p.ec2c.ModifyNetworkInterfaceAttribute(ctx, &ec2.ModifyNetworkInterfaceAttributeInput{
+		DryRun:             dry,
+		NetworkInterfaceId: aws.String(iface),
+		SourceDestCheck:    &types.AttributeBooleanValue{Value: aws.Bool(true)},
+	})
+	check("ec2:ModifyNetworkInterfaceAttribute", err)
+	_, err = p.ec2c.DeleteTags(ctx, &ec2.DeleteTagsInput{
+		DryRun:    dry,
+		Resources: []string{volume},
+		Tags:      []types.Tag{{Key: aws.String(p.nodeIDTag)}},
+	})
+	check("ec2:DeleteTags", err)
+	if len(p.securityGroups) > 0 {
+		_, err = p.ec2c.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{DryRun: dry})
+		check("ec2:DescribeSecurityGroups", err)
+	}
+	if p.attachmentTags {
+		_, err = p.ec2c.CreateTags(ctx, &ec2.CreateTagsInput{
+			DryRun:    dry,
+			Resources: []string{volume},
+			Tags:      []types.Tag{{Key: aws.String(awsAttachedToTag), Value: instance}},
+		})
+		check("ec2:CreateTags", err)
+	}
+	return checks
+}
+
+// dryRun checks whether action is allowed from the error err of its dry run.
+func dryRun(action string, err error) PermissionCheck {
+	c := PermissionCheck{Action: action, Result: PermissionAllowed}
+	if err == nil {
+		return c
+	}
+	var e smithy.APIError
+	if errors.As(err, &e) {
+		switch e.ErrorCode() {
+		case "DryRunOperation":
+			return c
+		case "UnauthorizedOperation", "AccessDenied", "AuthFailure":
+			c.Result = PermissionDenied
+			c.Error = e.ErrorMessage()
+			return c
+		}
+	}
+	c.Result = PermissionUnknown
+	c.Error = err.Error()
+	return c
+}
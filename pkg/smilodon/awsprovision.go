@@ -0,0 +1,220 @@
+package smilodon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// ProvisionOptions configure the resource pool created by Provision.
+type ProvisionOptions struct {
+	// Count node IDs are provisioned, numbered from FirstNodeID.
+	Count       int
+	FirstNodeID int
+	// Subnets are the subnets network interfaces are created in, assigned to
+	// nodes round-robin. Volumes are created in the availability zone of the
+	// subnet of their node.
+	Subnets []string
+	// Size (GiB), VolumeType and IOPS of the created volumes.
+	Size       int64
+	VolumeType string
+	IOPS       int64
+	// Snapshots are the snapshots volumes are created from, one for all
+	// volumes or one per node. Size may be zero then.
+	Snapshots []string
+}
+
+// Provision creates a volume and a network interface for every node ID of
+// options o which does not have them yet, tagged with the node ID and the tags
+// of the volume and network interface filters, so that instances pick them up.
+// Volumes are encrypted as required. It returns the provisioned nodes.
+func (p *AWSProvider) Provision(ctx context.Context, o ProvisionOptions) ([]Node, error) {
+	if o.Count <= 0 {
+		return nil, fmt.Errorf("count must be positive")
+	}
+	if len(o.Subnets) == 0 {
+		return nil, fmt.Errorf("no subnets given")
+	}
+	if n := len(o.Snapshots); n > 1 && n != o.Count {
+		return nil, fmt.Errorf("got %d snapshots for %d nodes", n, o.Count)
+	}
+	if len(o.Snapshots) == 0 && o.Size <= 0 {
+		return nil, fmt.Errorf("size must be positive without snapshots")
+	}
+	azs := make(map[string]string)
+	for _, s := range o.Subnets {
+		az, err := p.subnetAZ(ctx, s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up subnet %q: %v", s, err)
+		}
+		azs[s] = az
+	}
+	var groups []string
+	if len(p.securityGroups) > 0 {
+		var err error
+		if groups, err = p.resolveSecurityGroups(ctx); err != nil {
+			return nil, err
+		}
+	}
+	volumeFilters, interfaceFilters := p.filters()
+
+	var ns []Node
+	for i := 0; i < o.Count; i++ {
+		id := strconv.Itoa(o.FirstNodeID + i)
+		subnet := o.Subnets[i%len(o.Subnets)]
+		n := Node{ID: id}
+		v, err := p.provisionVolume(ctx, o, i, id, azs[subnet], filterTags(volumeFilters))
+		if err != nil {
+			return ns, err
+		}
+		n.Volume = v
+		ni, err := p.provisionInterface(ctx, id, subnet, groups, filterTags(interfaceFilters))
+		if err != nil {
+			return ns, err
+		}
+		n.NetworkInterface = ni
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+// provisionVolume returns the volume of node id, the i-th provisioned node,
+// creating it in availability zone az with tags if there is none.
+func (p *AWSProvider) provisionVolume(ctx context.Context, o ProvisionOptions, i int, id, az string, tags []types.Tag) (*Volume, error) {
+	key := p.nodeIDTag
+	if p.volumeNodeIDSource == nodeIDSourceName {
+		key = "Name"
+	}
+	filters := append(tagFilters(tags), types.Filter{Name: aws.String("tag:" + key), Values: []string{id}})
+	r, err := p.ec2c.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{Filters: filters})
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Volumes) > 0 {
+		log.Printf("Volume %q of node %q exists already.\n", *r.Volumes[0].VolumeId, id)
+		return &Volume{ID: *r.Volumes[0].VolumeId, NodeID: id}, nil
+	}
+	params := &ec2.CreateVolumeInput{
+		AvailabilityZone: aws.String(az),
+		VolumeType:       types.VolumeType(o.VolumeType),
+	}
+	if o.Size > 0 {
+		params.Size = aws.Int32(int32(o.Size))
+	}
+	if o.IOPS > 0 {
+		params.Iops = aws.Int32(int32(o.IOPS))
+	}
+	switch len(o.Snapshots) {
+	case 0:
+	case 1:
+		params.SnapshotId = aws.String(o.Snapshots[0])
+	default:
+		params.SnapshotId = aws.String(o.Snapshots[i])
+	}
+	p.encrypt(params)
+	v, err := p.ec2c.CreateVolume(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create volume of node %q: %v", id, err)
+	}
+	log.Printf("Created volume %q of node %q in %q.\n", *v.VolumeId, id, az)
+	tags = append(tags, types.Tag{Key: aws.String(key), Value: aws.String(id)})
+	if err := p.createTags(ctx, *v.VolumeId, p.ownTags(tags)); err != nil {
+		return nil, err
+	}
+	return &Volume{ID: *v.VolumeId, NodeID: id, Available: true}, nil
+}
+
+// provisionInterface returns the network interface of node id, creating it in
+// subnet with security groups and tags if there is none.
+func (p *AWSProvider) provisionInterface(ctx context.Context, id, subnet string, groups []string, tags []types.Tag) (*NetworkInterface, error) {
+	filter := types.Filter{Name: aws.String("tag:" + p.nodeIDTag), Values: []string{id}}
+	if p.interfaceNodeIDSource == nodeIDSourceDescription {
+		filter.Name = aws.String("description")
+	}
+	r, err := p.ec2c.DescribeNetworkInterfaces(ctx, &ec2.DescribeNetworkInterfacesInput{
+		Filters: append(tagFilters(tags), filter),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(r.NetworkInterfaces) > 0 {
+		i := r.NetworkInterfaces[0]
+		log.Printf("Network interface %q of node %q exists already.\n", *i.NetworkInterfaceId, id)
+		return &NetworkInterface{ID: *i.NetworkInterfaceId, NodeID: id, IPAddress: aws.ToString(i.PrivateIpAddress)}, nil
+	}
+	params := &ec2.CreateNetworkInterfaceInput{SubnetId: aws.String(subnet)}
+	if len(groups) > 0 {
+		params.Groups = groups
+	}
+	if p.interfaceNodeIDSource == nodeIDSourceDescription {
+		params.Description = aws.String(id)
+	} else {
+		tags = append(tags, types.Tag{Key: aws.String(p.nodeIDTag), Value: aws.String(id)})
+	}
+	out, err := p.ec2c.CreateNetworkInterface(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network interface of node %q: %v", id, err)
+	}
+	i := out.NetworkInterface
+	log.Printf("Created network interface %q of node %q in %q.\n", *i.NetworkInterfaceId, id, subnet)
+	tags = p.ownTags(tags)
+	if len(tags) > 0 {
+		if err := p.createTags(ctx, *i.NetworkInterfaceId, tags); err != nil {
+			return nil, err
+		}
+	}
+	return &NetworkInterface{ID: *i.NetworkInterfaceId, NodeID: id, Available: true, IPAddress: aws.ToString(i.PrivateIpAddress)}, nil
+}
+
+// subnetAZ returns the availability zone of subnet id.
+func (p *AWSProvider) subnetAZ(ctx context.Context, id string) (string, error) {
+	r, err := p.ec2c.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{SubnetIds: []string{id}})
+	if err != nil {
+		return "", err
+	}
+	if len(r.Subnets) == 0 {
+		return "", fmt.Errorf("subnet %q not found", id)
+	}
+	return aws.ToString(r.Subnets[0].AvailabilityZone), nil
+}
+
+// createTags tags resource id with tags.
+func (p *AWSProvider) createTags(ctx context.Context, id string, tags []types.Tag) error {
+	_, err := p.ec2c.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{id},
+		Tags:      tags,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to tag %q: %v", id, err)
+	}
+	return nil
+}
+
+// tagFilters returns filters matching resources with tags.
+func tagFilters(tags []types.Tag) []types.Filter {
+	var filters []types.Filter
+	for _, t := range tags {
+		filters = append(filters, types.Filter{Name: aws.String("tag:" + *t.Key), Values: []string{aws.ToString(t.Value)}})
+	}
+	return filters
+}
+
+// filterTags returns the tags matching the tag:<key> filters of filters, so
+// that provisioned resources are found with them.
+func filterTags(filters []types.Filter) []types.Tag {
+	var tags []types.Tag
+	for _, f := range filters {
+		k := aws.ToString(f.Name)
+		if !strings.HasPrefix(k, "tag:") || len(f.Values) != 1 {
+			continue
+		}
+		tags = append(tags, types.Tag{Key: aws.String(strings.TrimPrefix(k, "tag:")), Value: aws.String(f.Values[0])})
+	}
+	return tags
+}
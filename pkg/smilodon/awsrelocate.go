@@ -0,0 +1,201 @@
+package smilodon
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Tags recording the progress of volume relocations. Snapshots taken for a
+// relocation are tagged with the target availability zone, relocated volumes
+// with the ID of their replacement, so that they can be cleaned up.
+const (
+	awsRelocateToTag     = "SmilodonRelocateTo"
+	awsRelocatedToTag    = "SmilodonRelocatedTo"
+	awsReservedTagPrefix = "aws:"
+)
+
+// DiscoverRemoteVolumes returns available volumes matching the filters in
+// availability zones other than the instance one, which have not been
+// relocated yet.
+func (p *AWSProvider) DiscoverRemoteVolumes(ctx context.Context) ([]Volume, error) {
+	volumeFilters, _ := p.filters()
+	var vs []Volume
+	r, err := p.ec2c.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		Filters: volumeFilters,
+	})
+	if err != nil {
+		log.Printf("Failed to find volumes: %q.\n", err)
+		return vs, err
+	}
+	for _, i := range r.Volumes {
+		if *i.AvailabilityZone == p.instance.AZ || i.State != types.VolumeStateAvailable || hasTag(i.Tags, awsRelocatedToTag) || !p.managed(i.Tags) {
+			continue
+		}
+		vs = append(vs, Volume{
+			ID:        *i.VolumeId,
+			NodeID:    p.volumeNodeID(ctx, *i.VolumeId),
+			Available: true,
+		})
+	}
+	return vs, nil
+}
+
+// RelocateVolume advances the relocation of volume v into the instance
+// availability zone by one step: it snapshots v, creates a volume from the
+// completed snapshot with the tags of v, and marks v as relocated. Steps
+// already done by this or another instance are skipped, so that it is called
+// on every pass until the new volume is discovered.
+func (p *AWSProvider) RelocateVolume(ctx context.Context, v Volume) error {
+	r, err := p.ec2c.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: []string{v.ID},
+	})
+	if err != nil {
+		return err
+	}
+	if len(r.Volumes) == 0 {
+		return nil
+	}
+	src := r.Volumes[0]
+	snap, err := p.relocationSnapshot(ctx, v)
+	if err != nil || snap == nil {
+		return err
+	}
+	switch snap.State {
+	case types.SnapshotStatePending:
+		log.Printf("Waiting for snapshot %q of volume %q to complete: %s.\n", *snap.SnapshotId, v.ID, aws.ToString(snap.Progress))
+		return nil
+	case types.SnapshotStateError:
+		log.Printf("Snapshot %q of volume %q failed, taking another one.\n", *snap.SnapshotId, v.ID)
+		return p.deleteSnapshot(ctx, *snap.SnapshotId)
+	}
+	id, err := p.relocationVolume(ctx, src, *snap.SnapshotId)
+	if err != nil {
+		return err
+	}
+	var tags []types.Tag
+	for _, t := range src.Tags {
+		if copyTag(t) {
+			tags = append(tags, t)
+		}
+	}
+	tags = p.ownTags(tags)
+	if len(tags) > 0 {
+		_, err := p.ec2c.CreateTags(ctx, &ec2.CreateTagsInput{
+			Resources: []string{id},
+			Tags:      tags,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("Marking volume %q as relocated to %q.\n", v.ID, id)
+	_, err = p.ec2c.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{v.ID},
+		Tags:      []types.Tag{{Key: aws.String(awsRelocatedToTag), Value: aws.String(id)}},
+	})
+	return err
+}
+
+// relocationSnapshot returns the latest snapshot of volume v taken for a
+// relocation into the instance availability zone, taking one if there is
+// none.
+func (p *AWSProvider) relocationSnapshot(ctx context.Context, v Volume) (*types.Snapshot, error) {
+	r, err := p.ec2c.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{"self"},
+		Filters: []types.Filter{
+			{Name: aws.String("volume-id"), Values: []string{v.ID}},
+			{Name: aws.String("tag:" + awsRelocateToTag), Values: []string{p.instance.AZ}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var latest *types.Snapshot
+	for i, s := range r.Snapshots {
+		if latest == nil || s.StartTime.After(*latest.StartTime) {
+			latest = &r.Snapshots[i]
+		}
+	}
+	if latest != nil {
+		return latest, nil
+	}
+	log.Printf("Taking a snapshot of volume %q to relocate it to %q.\n", v.ID, p.instance.AZ)
+	s, err := p.ec2c.CreateSnapshot(ctx, &ec2.CreateSnapshotInput{
+		VolumeId:    aws.String(v.ID),
+		Description: aws.String("smilodon relocation of node " + v.NodeID + " to " + p.instance.AZ),
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.ec2c.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{*s.SnapshotId},
+		Tags:      p.ownTags([]types.Tag{{Key: aws.String(awsRelocateToTag), Value: aws.String(p.instance.AZ)}}),
+	})
+	return &types.Snapshot{SnapshotId: s.SnapshotId, State: s.State, Progress: s.Progress}, err
+}
+
+// relocationVolume returns the ID of the volume created from snapshot snap in
+// the instance availability zone, creating it with the type and encryption of
+// volume src if there is none.
+func (p *AWSProvider) relocationVolume(ctx context.Context, src types.Volume, snap string) (string, error) {
+	r, err := p.ec2c.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("snapshot-id"), Values: []string{snap}},
+			{Name: aws.String("availability-zone"), Values: []string{p.instance.AZ}},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(r.Volumes) > 0 {
+		return *r.Volumes[0].VolumeId, nil
+	}
+	params := &ec2.CreateVolumeInput{
+		AvailabilityZone: aws.String(p.instance.AZ),
+		SnapshotId:       aws.String(snap),
+		VolumeType:       src.VolumeType,
+		Encrypted:        src.Encrypted,
+		KmsKeyId:         src.KmsKeyId,
+	}
+	if src.VolumeType == types.VolumeTypeIo1 {
+		params.Iops = src.Iops
+	}
+	p.encrypt(params)
+	v, err := p.ec2c.CreateVolume(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Created volume %q from snapshot %q of volume %q.\n", *v.VolumeId, snap, *src.VolumeId)
+	return *v.VolumeId, nil
+}
+
+// deleteSnapshot deletes snapshot id.
+func (p *AWSProvider) deleteSnapshot(ctx context.Context, id string) error {
+	_, err := p.ec2c.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{SnapshotId: aws.String(id)})
+	return err
+}
+
+// copyTag checks whether tag t is copied onto snapshots and volumes created
+// from a volume. AWS reserved tags and smilodon state tags are not.
+func copyTag(t types.Tag) bool {
+	switch *t.Key {
+	case awsRelocatedToTag, awsReplacedByTag, awsWarmedUpTag, awsAttachedToTag, awsAttachedHostnameTag, awsAttachedAtTag:
+		return false
+	}
+	return !strings.HasPrefix(*t.Key, awsReservedTagPrefix)
+}
+
+// hasTag checks whether tags contain key k.
+func hasTag(tags []types.Tag, k string) bool {
+	for _, t := range tags {
+		if *t.Key == k {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package smilodon
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// awsRouteCIDRsTag is the network interface tag holding space-delimited
+// per-node route CIDRs. EC2 tag values cannot contain commas.
+const awsRouteCIDRsTag = "RouteCIDRs"
+
+// UpsertRoutes creates or replaces routes to cidrs and to the CIDRs in the
+// RouteCIDRs tag of n in route tables, so that they target n.
+func (p *AWSProvider) UpsertRoutes(ctx context.Context, n NetworkInterface, tables, cidrs []string) error {
+	cidrs = append(append([]string(nil), cidrs...), strings.Fields(p.getResourceTagValue(ctx, n.ID, awsRouteCIDRsTag))...)
+	if len(cidrs) == 0 {
+		return nil
+	}
+	r, err := p.ec2c.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+		RouteTableIds: tables,
+	})
+	if err != nil {
+		return err
+	}
+	for _, t := range r.RouteTables {
+		targets := map[string]string{}
+		for _, rt := range t.Routes {
+			if rt.DestinationCidrBlock != nil {
+				targets[*rt.DestinationCidrBlock] = aws.ToString(rt.NetworkInterfaceId)
+			}
+		}
+		for _, c := range cidrs {
+			target, exists := targets[c]
+			if target == n.ID {
+				continue
+			}
+			var err error
+			if exists {
+				log.Printf("Moving route %q of %q to network interface %q.\n", c, *t.RouteTableId, n.ID)
+				_, err = p.ec2c.ReplaceRoute(ctx, &ec2.ReplaceRouteInput{
+					RouteTableId:         t.RouteTableId,
+					DestinationCidrBlock: aws.String(c),
+					NetworkInterfaceId:   aws.String(n.ID),
+				})
+			} else {
+				log.Printf("Creating route %q in %q to network interface %q.\n", c, *t.RouteTableId, n.ID)
+				_, err = p.ec2c.CreateRoute(ctx, &ec2.CreateRouteInput{
+					RouteTableId:         t.RouteTableId,
+					DestinationCidrBlock: aws.String(c),
+					NetworkInterfaceId:   aws.String(n.ID),
+				})
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package smilodon
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// awsSnapshotTag marks scheduled snapshots, as opposed to relocation or
+// manual snapshots, which are never deleted by smilodon.
+const awsSnapshotTag = "SmilodonSnapshot"
+
+// Snapshots returns the scheduled snapshots of volume v.
+func (p *AWSProvider) Snapshots(ctx context.Context, v Volume) ([]snapshot, error) {
+	r, err := p.ec2c.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{"self"},
+		Filters: []types.Filter{
+			{Name: aws.String("volume-id"), Values: []string{v.ID}},
+			{Name: aws.String("tag-key"), Values: []string{awsSnapshotTag}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ss []snapshot
+	for _, s := range r.Snapshots {
+		ss = append(ss, snapshot{
+			ID:        *s.SnapshotId,
+			StartTime: *s.StartTime,
+			Completed: s.State == types.SnapshotStateCompleted,
+		})
+	}
+	return ss, nil
+}
+
+// SnapshotVolume takes a snapshot of volume v tagged with the tags of v, so
+// that the node can be restored from it if the volume is lost.
+func (p *AWSProvider) SnapshotVolume(ctx context.Context, v Volume) error {
+	r, err := p.ec2c.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: []string{v.ID},
+	})
+	if err != nil {
+		return err
+	}
+	tags := []types.Tag{{Key: aws.String(awsSnapshotTag), Value: aws.String(p.instance.ID)}}
+	for _, i := range r.Volumes {
+		for _, t := range i.Tags {
+			if copyTag(t) {
+				tags = append(tags, t)
+			}
+		}
+	}
+	s, err := p.ec2c.CreateSnapshot(ctx, &ec2.CreateSnapshotInput{
+		VolumeId:    aws.String(v.ID),
+		Description: aws.String("smilodon snapshot of node " + v.NodeID),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = p.ec2c.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{*s.SnapshotId},
+		Tags:      p.ownTags(tags),
+	})
+	return err
+}
+
+// DeleteSnapshot deletes snapshot id.
+func (p *AWSProvider) DeleteSnapshot(ctx context.Context, id string) error {
+	return p.deleteSnapshot(ctx, id)
+}
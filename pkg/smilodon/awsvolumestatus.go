@@ -0,0 +1,73 @@
+package smilodon
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// awsReplacedByTag marks volumes restored from a snapshot with the ID of
+// their replacement, so that they are no longer discovered.
+const awsReplacedByTag = "SmilodonReplacedBy"
+
+// VolumeStatus returns the status of volume v: ok, impaired, warning or
+// insufficient-data.
+func (p *AWSProvider) VolumeStatus(ctx context.Context, v Volume) (string, error) {
+	r, err := p.ec2c.DescribeVolumeStatus(ctx, &ec2.DescribeVolumeStatusInput{
+		VolumeIds: []string{v.ID},
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, s := range r.VolumeStatuses {
+		if s.VolumeStatus != nil {
+			return string(s.VolumeStatus.Status), nil
+		}
+	}
+	return string(types.VolumeStatusInfoStatusInsufficientData), nil
+}
+
+// RestoreVolume creates a replacement of volume v from its latest completed
+// scheduled snapshot, with the tags of v, and marks v as replaced.
+func (p *AWSProvider) RestoreVolume(ctx context.Context, v Volume) error {
+	r, err := p.ec2c.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: []string{v.ID},
+	})
+	if err != nil {
+		return err
+	}
+	if len(r.Volumes) == 0 {
+		return fmt.Errorf("volume %q not found", v.ID)
+	}
+	src := r.Volumes[0]
+	ss, err := p.Snapshots(ctx, v)
+	if err != nil {
+		return err
+	}
+	latest := latestSnapshot(ss)
+	if latest == nil {
+		return fmt.Errorf("no completed snapshot of volume %q", v.ID)
+	}
+	id, err := p.relocationVolume(ctx, src, latest.ID)
+	if err != nil {
+		return err
+	}
+	var tags []types.Tag
+	for _, t := range src.Tags {
+		if copyTag(t) {
+			tags = append(tags, t)
+		}
+	}
+	tags = p.ownTags(tags)
+	if len(tags) > 0 {
+		if err := p.createTags(ctx, id, tags); err != nil {
+			return err
+		}
+	}
+	log.Printf("Marking volume %q as replaced by %q.\n", v.ID, id)
+	return p.createTags(ctx, v.ID, []types.Tag{{Key: aws.String(awsReplacedByTag), Value: aws.String(id)}})
+}
@@ -0,0 +1,20 @@
+package smilodon
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// awsWarmedUpTag records the time a volume restored from a snapshot was
+// warmed up, so that it is not read in full again.
+const awsWarmedUpTag = "SmilodonWarmedUp"
+
+// MarkWarmedUp tags volume v as warmed up.
+func (p *AWSProvider) MarkWarmedUp(ctx context.Context, v Volume) error {
+	return p.createTags(ctx, v.ID, []types.Tag{
+		{Key: aws.String(awsWarmedUpTag), Value: aws.String(time.Now().UTC().Format(time.RFC3339))},
+	})
+}
@@ -0,0 +1,476 @@
+package smilodon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v8"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v9"
+)
+
+const (
+	azureIMDSURL = "http://169.254.169.254/metadata/"
+	// azureNodeIPTag is the disk tag holding the private IP address of the
+	// node.
+	azureNodeIPTag = "NodeIP"
+)
+
+var azureLUNRe = regexp.MustCompile(`lun(\d+)$`)
+
+// azurePollOptions are the options of waiting for VM and network interface
+// updates to finish.
+var azurePollOptions = &runtime.PollUntilDoneOptions{Frequency: 5 * time.Second}
+
+// AzureProvider is a Provider backed by managed disks and secondary IP
+// configurations of the VM primary network interface. Azure does not allow
+// adding network interfaces to running VMs, so node IP addresses are defined
+// by the NodeIP tag of disks instead.
+type AzureProvider struct {
+	instance      Instance
+	subscription  string
+	resourceGroup string
+	zone          string
+	primaryNIC    string
+	filters       map[string]string
+	client        *http.Client
+	vms           *armcompute.VirtualMachinesClient
+	disks         *armcompute.DisksClient
+	nics          *armnetwork.InterfacesClient
+	nodeIPs       map[string]string
+	nodeIPsMu     sync.Mutex
+}
+
+// NewAzureProvider returns an AzureProvider for the VM it runs on. Filters is
+// a comma-delimited list of tags, for example 'tag:Env=dev,tag:Service=etcd'.
+// The resource manager API is called with the VM managed identity.
+func NewAzureProvider(filters string) (*AzureProvider, error) {
+	p := &AzureProvider{
+		client:  &http.Client{Timeout: 30 * time.Second},
+		filters: parseTagFilters(filters),
+		nodeIPs: map[string]string{},
+	}
+	ctx := context.Background()
+	if err := p.getMetadata(ctx); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMetadata, err)
+	}
+	cred, err := azidentity.NewManagedIdentityCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	o := &arm.ClientOptions{ClientOptions: policy.ClientOptions{Transport: p.client}}
+	if p.vms, err = armcompute.NewVirtualMachinesClient(p.subscription, cred, o); err != nil {
+		return nil, err
+	}
+	if p.disks, err = armcompute.NewDisksClient(p.subscription, cred, o); err != nil {
+		return nil, err
+	}
+	if p.nics, err = armnetwork.NewInterfacesClient(p.subscription, cred, o); err != nil {
+		return nil, err
+	}
+	if err := p.getPrimaryNIC(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseTagFilters parses a comma-delimited list of tag:key=value filters into
+// a map of tag values by key.
+func parseTagFilters(f string) map[string]string {
+	tags := map[string]string{}
+	if f == "" {
+		return tags
+	}
+	for _, kv := range strings.Split(f, ",") {
+		parts := strings.Split(kv, "=")
+		if len(parts) != 2 {
+			continue
+		}
+		tags[strings.TrimPrefix(parts[0], "tag:")] = parts[1]
+	}
+	return tags
+}
+
+// imds calls the instance metadata service endpoint e and decodes the
+// response into out.
+func (p *AzureProvider) imds(ctx context.Context, e string, out interface{}) error {
+	req, err := http.NewRequest("GET", azureIMDSURL+e, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Metadata", "true")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("metadata %q: %s", e, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (p *AzureProvider) getMetadata(ctx context.Context) error {
+	var m struct {
+		Compute struct {
+			Name              string `json:"name"`
+			Location          string `json:"location"`
+			Zone              string `json:"zone"`
+			SubscriptionID    string `json:"subscriptionId"`
+			ResourceGroupName string `json:"resourceGroupName"`
+		} `json:"compute"`
+	}
+	if err := p.imds(ctx, "instance?api-version=2021-02-01", &m); err != nil {
+		log.Printf("Failed to get VM metadata from the metadata service: %q.\n", err)
+		return err
+	}
+	p.instance.ID = m.Compute.Name
+	p.instance.Region = m.Compute.Location
+	p.instance.AZ = m.Compute.Location
+	if m.Compute.Zone != "" {
+		p.instance.AZ = m.Compute.Location + "-" + m.Compute.Zone
+	}
+	p.zone = m.Compute.Zone
+	p.subscription = m.Compute.SubscriptionID
+	p.resourceGroup = m.Compute.ResourceGroupName
+	return nil
+}
+
+// azureString returns the value of s, or an empty string if s is nil.
+func azureString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// getPrimaryNIC looks up the VM primary network interface and its virtual
+// network.
+func (p *AzureProvider) getPrimaryNIC(ctx context.Context) error {
+	vm, err := p.vms.Get(ctx, p.resourceGroup, p.instance.ID, nil)
+	if err != nil {
+		log.Printf("Failed to get VM %q: %q.\n", p.instance.ID, err)
+		return err
+	}
+	if props := vm.Properties; props != nil && props.NetworkProfile != nil {
+		for _, n := range props.NetworkProfile.NetworkInterfaces {
+			primary := n.Properties != nil && n.Properties.Primary != nil && *n.Properties.Primary
+			if n.ID != nil && (primary || p.primaryNIC == "") {
+				p.primaryNIC = *n.ID
+			}
+		}
+	}
+	if p.primaryNIC == "" {
+		return fmt.Errorf("VM %q has no network interfaces", p.instance.ID)
+	}
+	nic, err := p.getNIC(ctx, p.primaryNIC)
+	if err != nil {
+		return err
+	}
+	// Subnet IDs look like .../virtualNetworks/<vnet>/subnets/<subnet>.
+	for _, c := range ipConfigurations(nic) {
+		parts := strings.Split(subnetID(c), "/")
+		if len(parts) >= 3 {
+			p.instance.VPC = parts[len(parts)-3]
+		}
+	}
+	return nil
+}
+
+// ipConfigurations returns the IP configurations of network interface n.
+func ipConfigurations(n armnetwork.Interface) []*armnetwork.InterfaceIPConfiguration {
+	if n.Properties == nil {
+		return nil
+	}
+	return n.Properties.IPConfigurations
+}
+
+// virtualMachine returns the name of the VM n is attached to, if any.
+func virtualMachine(n *armnetwork.Interface) string {
+	if n.Properties == nil || n.Properties.VirtualMachine == nil {
+		return ""
+	}
+	return path.Base(azureString(n.Properties.VirtualMachine.ID))
+}
+
+// privateIP returns the private IP address of IP configuration c.
+func privateIP(c *armnetwork.InterfaceIPConfiguration) string {
+	if c.Properties == nil {
+		return ""
+	}
+	return azureString(c.Properties.PrivateIPAddress)
+}
+
+// subnetID returns the subnet ID of IP configuration c.
+func subnetID(c *armnetwork.InterfaceIPConfiguration) string {
+	if c.Properties == nil || c.Properties.Subnet == nil {
+		return ""
+	}
+	return azureString(c.Properties.Subnet.ID)
+}
+
+// getNIC returns the network interface with resource ID id.
+func (p *AzureProvider) getNIC(ctx context.Context, id string) (armnetwork.Interface, error) {
+	rid, err := arm.ParseResourceID(id)
+	if err != nil {
+		return armnetwork.Interface{}, err
+	}
+	r, err := p.nics.Get(ctx, rid.ResourceGroupName, rid.Name, nil)
+	return r.Interface, err
+}
+
+// updateNIC replaces the primary network interface with nic and waits for
+// the update to finish.
+func (p *AzureProvider) updateNIC(ctx context.Context, nic armnetwork.Interface) error {
+	rid, err := arm.ParseResourceID(p.primaryNIC)
+	if err != nil {
+		return err
+	}
+	poller, err := p.nics.BeginCreateOrUpdate(ctx, rid.ResourceGroupName, rid.Name, nic, nil)
+	if err != nil {
+		return err
+	}
+	_, err = poller.PollUntilDone(ctx, azurePollOptions)
+	return err
+}
+
+// dataDisks returns the data disks of the VM.
+func (p *AzureProvider) dataDisks(ctx context.Context) ([]*armcompute.DataDisk, error) {
+	vm, err := p.vms.Get(ctx, p.resourceGroup, p.instance.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if vm.Properties == nil || vm.Properties.StorageProfile == nil {
+		return nil, nil
+	}
+	return vm.Properties.StorageProfile.DataDisks, nil
+}
+
+// updateDataDisks replaces the data disks of the VM with disks and waits for
+// the update to finish.
+func (p *AzureProvider) updateDataDisks(ctx context.Context, disks []*armcompute.DataDisk) error {
+	vm := armcompute.VirtualMachineUpdate{
+		Properties: &armcompute.VirtualMachineProperties{
+			StorageProfile: &armcompute.StorageProfile{DataDisks: disks},
+		},
+	}
+	poller, err := p.vms.BeginUpdate(ctx, p.resourceGroup, p.instance.ID, vm, nil)
+	if err != nil {
+		return err
+	}
+	_, err = poller.PollUntilDone(ctx, azurePollOptions)
+	return err
+}
+
+// Metadata returns the VM smilodon runs on.
+func (p *AzureProvider) Metadata(ctx context.Context) (Instance, error) {
+	return p.instance, nil
+}
+
+// matchesTags checks whether tags contain all filter tags.
+func (p *AzureProvider) matchesTags(tags map[string]string) bool {
+	if _, ok := tags["NodeID"]; !ok {
+		return false
+	}
+	for k, v := range p.filters {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// DiscoverVolumes returns managed disks in the resource group and VM zone
+// matching the filters.
+func (p *AzureProvider) DiscoverVolumes(ctx context.Context) ([]Volume, error) {
+	var vs []Volume
+	ips := map[string]string{}
+	pager := p.disks.NewListByResourceGroupPager(p.resourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to find volumes: %q.\n", err)
+			return vs, err
+		}
+		for _, d := range page.Value {
+			tags := azureTags(d.Tags)
+			if !p.matchesTags(tags) {
+				continue
+			}
+			if p.zone != "" && len(d.Zones) > 0 && azureString(d.Zones[0]) != p.zone {
+				continue
+			}
+			v := Volume{
+				ID:        azureString(d.ID),
+				NodeID:    tags["NodeID"],
+				Available: azureString(d.ManagedBy) == "",
+			}
+			if !v.Available {
+				v.AttachedTo = path.Base(*d.ManagedBy)
+			}
+			if ip := tags[azureNodeIPTag]; ip != "" {
+				ips[v.NodeID] = ip
+			}
+			vs = append(vs, v)
+		}
+	}
+	p.nodeIPsMu.Lock()
+	p.nodeIPs = ips
+	p.nodeIPsMu.Unlock()
+	return vs, nil
+}
+
+// azureTags returns the values of resource tags.
+func azureTags(tags map[string]*string) map[string]string {
+	out := make(map[string]string, len(tags))
+	for k, v := range tags {
+		out[k] = azureString(v)
+	}
+	return out
+}
+
+// azureIPConfigName returns the IP configuration name used for node ID id.
+func azureIPConfigName(id string) string {
+	return "smilodon-" + id
+}
+
+// DiscoverInterfaces returns node IP addresses defined by the NodeIP tag of
+// the disks and where they are currently configured.
+func (p *AzureProvider) DiscoverInterfaces(ctx context.Context) ([]NetworkInterface, error) {
+	var ns []NetworkInterface
+	holders := map[string]string{}
+	pager := p.nics.NewListPager(p.resourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to find network interfaces: %q.\n", err)
+			return ns, err
+		}
+		for _, nic := range page.Value {
+			for _, c := range ipConfigurations(*nic) {
+				holders[privateIP(c)] = virtualMachine(nic)
+			}
+		}
+	}
+	p.nodeIPsMu.Lock()
+	defer p.nodeIPsMu.Unlock()
+	for id, ip := range p.nodeIPs {
+		n := NetworkInterface{
+			ID:        azureIPConfigName(id),
+			NodeID:    id,
+			IPAddress: ip,
+			Available: true,
+		}
+		if vm, ok := holders[ip]; ok {
+			n.Available = false
+			n.AttachedTo = vm
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+// AttachVolume attaches managed disk v to the VM. The LUN is taken from block
+// device d if it looks like /dev/disk/azure/scsi1/lun<N>, otherwise the first
+// free LUN is used.
+func (p *AzureProvider) AttachVolume(ctx context.Context, v Volume, d string) error {
+	disks, err := p.dataDisks(ctx)
+	if err != nil {
+		return err
+	}
+	used := map[int32]bool{}
+	for _, dd := range disks {
+		if dd.Lun != nil {
+			used[*dd.Lun] = true
+		}
+	}
+	var lun int32
+	if m := azureLUNRe.FindStringSubmatch(d); m != nil {
+		l, _ := strconv.Atoi(m[1])
+		lun = int32(l)
+	} else {
+		for used[lun] {
+			lun++
+		}
+	}
+	disks = append(disks, &armcompute.DataDisk{
+		Lun:          to.Ptr(lun),
+		CreateOption: to.Ptr(armcompute.DiskCreateOptionTypesAttach),
+		ManagedDisk:  &armcompute.ManagedDiskParameters{ID: to.Ptr(v.ID)},
+	})
+	return p.updateDataDisks(ctx, disks)
+}
+
+// DetachVolume removes managed disk v from the VM data disks.
+func (p *AzureProvider) DetachVolume(ctx context.Context, v Volume) error {
+	all, err := p.dataDisks(ctx)
+	if err != nil {
+		return err
+	}
+	// An empty list, unlike nil, removes the last data disk.
+	disks := []*armcompute.DataDisk{}
+	for _, dd := range all {
+		if dd.ManagedDisk != nil && strings.EqualFold(azureString(dd.ManagedDisk.ID), v.ID) {
+			continue
+		}
+		disks = append(disks, dd)
+	}
+	return p.updateDataDisks(ctx, disks)
+}
+
+// AttachInterface adds the node IP address of n as a secondary IP
+// configuration of the VM primary network interface.
+func (p *AzureProvider) AttachInterface(ctx context.Context, n NetworkInterface) error {
+	nic, err := p.getNIC(ctx, p.primaryNIC)
+	if err != nil {
+		return err
+	}
+	cs := ipConfigurations(nic)
+	if len(cs) == 0 {
+		return fmt.Errorf("network interface %q has no IP configurations", p.primaryNIC)
+	}
+	nic.Properties.IPConfigurations = append(cs, &armnetwork.InterfaceIPConfiguration{
+		Name: to.Ptr(n.ID),
+		Properties: &armnetwork.InterfaceIPConfigurationPropertiesFormat{
+			PrivateIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodStatic),
+			PrivateIPAddress:          to.Ptr(n.IPAddress),
+			Subnet:                    &armnetwork.Subnet{ID: to.Ptr(subnetID(cs[0]))},
+			Primary:                   to.Ptr(false),
+		},
+	})
+	return p.updateNIC(ctx, nic)
+}
+
+// DetachInterface removes the IP configuration of n from the VM primary
+// network interface.
+func (p *AzureProvider) DetachInterface(ctx context.Context, n NetworkInterface) error {
+	nic, err := p.getNIC(ctx, p.primaryNIC)
+	if err != nil {
+		return err
+	}
+	if nic.Properties == nil {
+		return fmt.Errorf("network interface %q has no IP configurations", p.primaryNIC)
+	}
+	var cs []*armnetwork.InterfaceIPConfiguration
+	for _, c := range ipConfigurations(nic) {
+		if azureString(c.Name) != n.ID && privateIP(c) != n.IPAddress {
+			cs = append(cs, c)
+		}
+	}
+	nic.Properties.IPConfigurations = cs
+	return p.updateNIC(ctx, nic)
+}
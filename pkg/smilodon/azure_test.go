@@ -0,0 +1,23 @@
+package smilodon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTagFilters(t *testing.T) {
+	for _, tc := range []struct {
+		f    string
+		want map[string]string
+	}{
+		{"", map[string]string{}},
+		{"tag:Env=dev", map[string]string{"Env": "dev"}},
+		{"tag:Env=dev,tag:Service=etcd", map[string]string{"Env": "dev", "Service": "etcd"}},
+		{"env=dev", map[string]string{"env": "dev"}},
+		{"tag:Env=dev,invalid,a=b=c", map[string]string{"Env": "dev"}},
+	} {
+		if got := parseTagFilters(tc.f); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseTagFilters(%q) = %v, want %v", tc.f, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package smilodon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Mount propagation types of Config.MountPropagation.
+var mountPropagations = []string{"shared", "rshared", "slave", "rslave", "private", "rprivate"}
+
+// bindMount is a bind mount of source, a directory beneath the mount point,
+// to target.
+type bindMount struct {
+	source string
+	target string
+}
+
+// parseBindMounts parses bind mounts bs of the form target[=source], with
+// targets absolute and sources relative to the mount point, for example
+// '/var/lib/kafka=kafka'. The source defaults to the mount point itself.
+func parseBindMounts(bs []string) ([]bindMount, error) {
+	var out []bindMount
+	for _, b := range bs {
+		kv := strings.SplitN(b, "=", 2)
+		t := filepath.Clean(kv[0])
+		if !filepath.IsAbs(t) || t == "/" {
+			return nil, fmt.Errorf("invalid bind mount %q, the target must be an absolute path", b)
+		}
+		s := "."
+		if len(kv) == 2 {
+			s = filepath.Clean(strings.TrimPrefix(kv[1], "/"))
+			if s == ".." || strings.HasPrefix(s, "../") {
+				return nil, fmt.Errorf("invalid bind mount %q, the source must be beneath the mount point", b)
+			}
+		}
+		out = append(out, bindMount{s, t})
+	}
+	return out, nil
+}
+
+// parseMountPropagation checks mount propagation type p, which may be empty.
+func parseMountPropagation(p string) error {
+	if p == "" {
+		return nil
+	}
+	for _, t := range mountPropagations {
+		if p == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown mount propagation %q, expected one of %s", p, strings.Join(mountPropagations, ", "))
+}
+
+// reconcileMounts bind mounts the configured sources missing at their
+// targets and sets the configured propagation of the mount point and the
+// targets, if it differs.
+func (r *Reconciler) reconcileMounts() {
+	if !r.cfg.MountFs || !r.fsMounted() {
+		return
+	}
+	r.ensurePropagation(r.mountPoint())
+	for _, b := range r.bindMounts {
+		if r.host.isMountPoint(b.target) {
+			r.ensurePropagation(b.target)
+			continue
+		}
+		src := filepath.Join(r.mountPoint(), b.source)
+		if err := r.host.bind(src, b.target); err != nil {
+			continue
+		}
+		r.ensurePropagation(b.target)
+	}
+}
+
+// unmountBinds unmounts the bind mounts of the mount point in reverse order,
+// lazily if lazy is set, so that the mount point can be unmounted.
+func (r *Reconciler) unmountBinds(lazy bool) error {
+	for i := len(r.bindMounts) - 1; i >= 0; i-- {
+		t := r.bindMounts[i].target
+		if !r.host.isMountPoint(t) {
+			continue
+		}
+		u := r.host.unmount
+		if lazy {
+			u = r.host.unmountLazy
+		}
+		if err := u(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensurePropagation sets the configured propagation of mount point p unless
+// it already has it.
+func (r *Reconciler) ensurePropagation(p string) {
+	want := r.cfg.MountPropagation
+	if want == "" {
+		return
+	}
+	got, err := r.host.propagation(p)
+	if err != nil {
+		log.Printf("Failed to read the propagation of %q: %q.\n", p, err)
+		return
+	}
+	if got == strings.TrimPrefix(want, "r") {
+		return
+	}
+	log.Printf("Making %q %s.\n", p, want)
+	if o, err := r.host.command("/usr/bin/mount", "--make-"+want, p).CombinedOutput(); err != nil {
+		log.Printf("Failed to make %q %s: %q.\n", p, want, string(o))
+	}
+}
+
+// bind bind mounts directory src to target, creating both if missing.
+func (h host) bind(src, target string) error {
+	for _, d := range []string{src, target} {
+		if err := os.MkdirAll(h.path(d), 0755); err != nil {
+			log.Printf("Failed to create %q: %q.\n", d, err)
+			return err
+		}
+	}
+	log.Printf("Bind mounting %q to %q.\n", src, target)
+	if o, err := h.command("/usr/bin/mount", "--bind", src, target).CombinedOutput(); err != nil {
+		log.Printf("Bind mount failed: %q to %q: %q.\n", src, target, string(o))
+		return err
+	}
+	return nil
+}
+
+// propagation returns the propagation type of the last mount at mount point
+// p: shared, slave or private. A mount that is both is reported as shared.
+func (h host) propagation(p string) (string, error) {
+	v, err := ioutil.ReadFile(h.mountInfoFile())
+	if err != nil {
+		return "", err
+	}
+	t := ""
+	for _, l := range strings.Split(string(v), "\n") {
+		f := strings.Fields(l)
+		if len(f) < 7 || f[4] != p {
+			continue
+		}
+		t = "private"
+		// The optional fields end with a single hyphen.
+		for _, o := range f[6:] {
+			if o == "-" {
+				break
+			}
+			if strings.HasPrefix(o, "shared:") {
+				t = "shared"
+				break
+			}
+			if strings.HasPrefix(o, "master:") {
+				t = "slave"
+			}
+		}
+	}
+	if t == "" {
+		return "", fmt.Errorf("%q is not a mount point", p)
+	}
+	return t, nil
+}
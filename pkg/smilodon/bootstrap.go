@@ -0,0 +1,66 @@
+package smilodon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Ready checks whether the node identity is fully established: the instance
+// holds a complete node, its file system is mounted if MountFs is enabled and
+// the environment file is written.
+func (r *Reconciler) Ready() bool {
+	if !r.Stable() {
+		return false
+	}
+	if r.cfg.MountFs && !r.Mounted() {
+		return false
+	}
+	if r.cfg.EnvFile != "" {
+		if _, err := os.Stat(r.host.path(r.cfg.EnvFile)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// checkReadyDeadline fails if no node was acquired within ReadyDeadline of
+// start, with ErrPermissionDenied if the last error was denied permission and
+// ErrNoResources otherwise.
+func (r *Reconciler) checkReadyDeadline(start time.Time) error {
+	if r.acquired || r.Stable() {
+		r.acquired = true
+		return nil
+	}
+	if r.cfg.ReadyDeadline <= 0 || time.Since(start) < r.cfg.ReadyDeadline {
+		return nil
+	}
+	if err := categorize(r.lastErr); errors.Is(err, ErrPermissionDenied) {
+		return fmt.Errorf("no node acquired within %s: %w", r.cfg.ReadyDeadline, err)
+	}
+	return fmt.Errorf("%w: no node acquired within %s", ErrNoResources, r.cfg.ReadyDeadline)
+}
+
+// Bootstrap reconciles every interval until the node is Ready, and returns
+// without tearing anything down, so that a daemon started afterwards takes
+// over. It fails with ErrAttachTimeout once the deadline of ctx passes.
+func (r *Reconciler) Bootstrap(ctx context.Context, interval time.Duration) error {
+	for {
+		r.Reconcile(ctx)
+		if r.Ready() {
+			log.Printf("Node %q is ready.\n", r.node.ID)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("%w: node is not ready", ErrAttachTimeout)
+			}
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package smilodon
+
+import "time"
+
+// Config configures a Reconciler.
+type Config struct {
+	// NodeIDFormat is the format of node IDs: string or numeric. Numeric
+	// node IDs match regardless of prefixes and leading zeros.
+	NodeIDFormat string
+	// NodeSelection is how an instance without a node picks the volume to
+	// attach: first, lowest, random or preferred. PreferredNodeIDs are tried
+	// first with preferred, followed by a node ID hinted by the instance.
+	NodeSelection    string
+	PreferredNodeIDs []string
+	// StickyTimeout is how long an instance waits to re-claim the node ID it
+	// last held, which is recorded in StateFile, before picking another one.
+	// Zero disables sticky node IDs.
+	StickyTimeout time.Duration
+	StateFile     string
+	// ReadyDeadline is how long Run waits for a node to be acquired before
+	// it fails. Zero waits forever.
+	ReadyDeadline time.Duration
+
+	// BlockDevice is the linux block device path the volume is attached as.
+	// With AutoDevice, the next free device name is used if it is taken.
+	BlockDevice string
+	AutoDevice  bool
+	// AttachTimeout is how long to wait for BlockDevice to appear after the
+	// volume is attached, and for the network interface to appear in static
+	// interface mode.
+	AttachTimeout time.Duration
+	// AttachRetries is the number of reconcile passes a half-attached node
+	// waits for its matching volume or network interface before releasing
+	// the attached one. Zero waits forever.
+	AttachRetries int
+	// IOScheduler, ReadAheadKB and NrRequests are queue settings applied to
+	// the block device through sysfs, if not empty or zero.
+	IOScheduler string
+	ReadAheadKB int
+	NrRequests  int
+	// Partition enables creating a GPT with a single partition on the block
+	// device on first use. The file system is created on and mounted from the
+	// partition instead of the raw block device.
+	Partition bool
+	// CreateFs enables creating a file system of FsType on the volume.
+	CreateFs bool
+	FsType   string
+	// ForceMkfs allows creating a file system over existing file system,
+	// RAID, LVM or partition table signatures, which is refused otherwise.
+	ForceMkfs bool
+	// MkfsOptions are extra space-delimited options passed to mkfs, or to
+	// zpool create for FsType zfs.
+	MkfsOptions string
+	// FsReservedPercent is the reserved block percentage, FsLabel a template
+	// of the label, for example 'node-{{.NodeID}}', and FsUUID the UUID, or
+	// random or time, set on a file system after creating it, if not empty.
+	FsReservedPercent string
+	FsLabel           string
+	FsUUID            string
+	// ZFSPool is the name of the ZFS pool created with FsType zfs, which is
+	// mounted at MountPoint. ZFSDatasets are created in it if missing.
+	ZFSPool     string
+	ZFSDatasets []string
+	// MountFs enables mounting the file system to MountPoint.
+	MountFs    bool
+	MountPoint string
+	// SELinuxContext is the SELinux context the file system is mounted with,
+	// for example 'system_u:object_r:container_file_t:s0'. Without it, newly
+	// created file systems are relabeled after mounting on SELinux hosts.
+	SELinuxContext string
+	// MountOwner (user:group) and MountMode (octal) are applied to the mount
+	// point after mounting, if not empty.
+	MountOwner string
+	MountMode  string
+	// MountDirs are directories created beneath the mount point if missing,
+	// of the form path[=mode[:user:group]], for example 'kafka/logs=0750'.
+	MountDirs []string
+	// BindMounts bind mount directories beneath the mount point to further
+	// paths, of the form target[=source], for example
+	// '/var/lib/kafka=kafka'. They are unmounted before the mount point.
+	BindMounts []string
+	// MountPropagation is the propagation type set on the mount point and
+	// the bind mount targets, for example rshared, if not empty.
+	MountPropagation string
+	// Discard mounts the file system with the discard option, so that freed
+	// blocks are discarded right away. TrimInterval is the interval between
+	// fstrim runs on the mount point instead, zero disables them.
+	Discard      bool
+	TrimInterval time.Duration
+	// WarmUp reads the whole volume in the background once, if it was
+	// restored from a snapshot, with WarmUpParallel readers at no more than
+	// WarmUpRate MiB/s, if positive.
+	WarmUp         bool
+	WarmUpParallel int
+	WarmUpRate     int
+	// HostRoot is the directory the root file system of the host is mounted
+	// at when running in a privileged container, for example /host. Device,
+	// sysfs and output file paths are prefixed with it, and file system
+	// commands run in the mount namespace of the host with nsenter, which
+	// requires sharing the host PID namespace. Network interfaces and their
+	// sysctls are those of the network namespace of smilodon, which must be
+	// that of the host.
+	HostRoot string
+	// NodeOverrides override BlockDevice, MountPoint, FsType or MkfsOptions
+	// for some node IDs, for example '1-3:block-device=/dev/xvdf'.
+	NodeOverrides []string
+
+	// Scratch enables formatting the instance-store disks, ScratchDevices or
+	// the discovered NVMe ones, with ScratchFsType and mounting them to
+	// ScratchMountPoint. Multiple disks are striped as RAID 0.
+	Scratch           bool
+	ScratchDevices    []string
+	ScratchFsType     string
+	ScratchMountPoint string
+
+	// MultiAttach is what to do with multi-attach volumes attached to other
+	// instances: refuse to attach them, or share them for clustered file
+	// systems.
+	MultiAttach string
+
+	// RelocateVolumes enables recreating the volume of a free node ID from
+	// a snapshot when it is only found in another availability zone.
+	RelocateVolumes bool
+
+	// SnapshotInterval is the interval between snapshots of the attached
+	// volume, zero disables them. The last SnapshotRetain completed
+	// snapshots are kept.
+	SnapshotInterval time.Duration
+	SnapshotRetain   int
+	// FastSnapshotRestoreAZs are the availability zones fast snapshot
+	// restores of the latest snapshot are enabled in, so that volumes
+	// restored from it are initialized right away.
+	FastSnapshotRestoreAZs []string
+
+	// ModifyVolume enables modifying the attached volume to the desired type,
+	// size (GiB), IOPS and throughput (MiB/s), which the provider may
+	// override per volume. The file system is grown after resizing.
+	ModifyVolume     bool
+	VolumeType       string
+	VolumeSize       int64
+	VolumeIOPS       int64
+	VolumeThroughput int64
+	// MinVolumeIOPS, MinVolumeThroughput (MiB/s) and VolumeTypes, a
+	// comma-delimited list, are performance requirements the attached volume
+	// is checked against once, which node overrides may set per node class.
+	// An under-provisioned volume is reported, and with
+	// VolumeRequirementAction modify, modified to meet them.
+	MinVolumeIOPS           int64
+	MinVolumeThroughput     int64
+	VolumeTypes             string
+	VolumeRequirementAction string
+
+	// RPFilter is the rp_filter value set on the attached network interface,
+	// which is left untouched if empty. Sysctls are further per-interface
+	// IPv4 sysctls of the form key=value, for example 'arp_ignore=1'.
+	RPFilter string
+	Sysctls  []string
+
+	// IfaceMode is how the attached network interface gets its IP address:
+	// wait for DHCP or assign it statically. IfaceWaitTimeout is how long to
+	// wait for DHCP.
+	IfaceMode        string
+	IfaceWaitTimeout time.Duration
+	// IfaceMTU is the MTU set on the attached network interface, if not zero.
+	IfaceMTU int
+	// GratuitousARP enables sending gratuitous ARP and unsolicited neighbor
+	// advertisements after the network interface is attached.
+	GratuitousARP bool
+	// PolicyRoutingTable is the routing table used to route traffic from the
+	// attached network interface IP through it. Zero disables policy routing.
+	PolicyRoutingTable int
+
+	// AliasIPs are extra addresses assigned to the attached network interface
+	// as single host prefixes. AliasSecondaryIPs adds the secondary IP
+	// addresses of the network interface to them.
+	AliasIPs          []string
+	AliasSecondaryIPs bool
+
+	// RouteTables are route tables in which routes to RouteCIDRs are pointed
+	// at the attached network interface.
+	RouteTables []string
+	RouteCIDRs  []string
+
+	// ZooKeeperMyID enables writing the numeric node ID to ZooKeeperMyIDFile,
+	// <MountPoint>/myid by default.
+	ZooKeeperMyID     bool
+	ZooKeeperMyIDFile string
+
+	// EnvFile is the environment file path and EnvFormat its format: systemd,
+	// dotenv, json or shell.
+	EnvFile   string
+	EnvFormat string
+	// EnvFileMode is the octal mode and EnvFileOwner the user:group owner
+	// of the environment file, which take precedence over FilePerms.
+	EnvFileMode  string
+	EnvFileOwner string
+	// EnvPrefix is prepended to the keys of the environment file, for
+	// example 'SMILODON_'.
+	EnvPrefix string
+	// Templates are Go template files rendered to TemplateOutputs.
+	Templates       []string
+	TemplateOutputs []string
+	// AppPresets are built-in templates of the form name[=output]: kafka,
+	// cassandra or elasticsearch.
+	AppPresets []string
+	// FilePerms is a comma-delimited list of output file permissions, for
+	// example '/run/smilodon/environment=0600:root:root'.
+	FilePerms string
+	// WatchFiles enables restoring output files modified externally.
+	WatchFiles bool
+
+	// EventsTopic and EventsQueue are an SNS topic ARN and an SQS queue URL
+	// attach/detach events are published to.
+	EventsTopic string
+	EventsQueue string
+
+	// MetricsNamespace is the CloudWatch namespace reconcile metrics are
+	// pushed to after every pass, if not empty.
+	MetricsNamespace string
+
+	// OTLPEndpoint is the OTLP HTTP endpoint of an OpenTelemetry collector,
+	// for example http://localhost:4318, spans of every pass and the AWS
+	// calls and commands in it are exported to, if not empty.
+	OTLPEndpoint string
+
+	// TriggerQueue is an SQS queue URL fed with EventBridge events, which
+	// trigger a reconcile pass right away.
+	TriggerQueue string
+
+	// PollInterval is the interval between reconcile passes, jittered by
+	// PollJitter. StablePollInterval is used instead once the node is
+	// complete.
+	PollInterval       time.Duration
+	StablePollInterval time.Duration
+	PollJitter         float64
+
+	// ClusterRecordZone is a Route 53 hosted zone ID, in which the record set
+	// ClusterRecordName holds the IP addresses of all nodes, if not empty.
+	ClusterRecordZone string
+	ClusterRecordName string
+	ClusterRecordTTL  int64
+
+	// ConsulService is the Consul service name the node is registered as
+	// with the agent at ConsulAddr, if not empty.
+	ConsulAddr    string
+	ConsulService string
+	ConsulToken   string
+
+	// EtcdEndpoints is a comma-delimited list of etcd endpoints, in which the
+	// node ID held by the instance is recorded and locked under EtcdPrefix,
+	// if not empty.
+	EtcdEndpoints string
+	EtcdPrefix    string
+
+	// KubeNodeName is the Kubernetes Node the instance runs as, which is
+	// labeled and annotated with the node ID and IP address if not empty.
+	// KubeAPIServer defaults to the in-cluster API server, KubeTokenFile and
+	// KubeCAFile to the pod service account credentials.
+	KubeNodeName  string
+	KubeAPIServer string
+	KubeTokenFile string
+	KubeCAFile    string
+
+	// IOErrorWatchdog enables watching the kernel log for I/O errors of the
+	// block device. After errors in IOErrorPasses passes in a row, the volume
+	// is reported as degraded and, with IOErrorReattach, detached to be
+	// attached again.
+	IOErrorWatchdog bool
+	IOErrorPasses   int
+	IOErrorReattach bool
+
+	// VolumeStatusInterval is the interval between status checks of the
+	// volume, zero disables them. After VolumeStatusChecks impaired checks
+	// in a row, the volume is recovered with VolumeStatusRecovery: reattach
+	// detaches it to be attached again, restore replaces it with a volume
+	// restored from its latest snapshot.
+	VolumeStatusInterval time.Duration
+	VolumeStatusChecks   int
+	VolumeStatusRecovery string
+
+	// HealthProbe is a command or http(s) URL probing the local service on
+	// every pass once the node is complete. With HealthRelease, the node is
+	// released after HealthThreshold consecutive failures, and no node is
+	// claimed for HealthHoldOff afterwards.
+	HealthProbe     string
+	HealthThreshold int
+	HealthRelease   bool
+	HealthHoldOff   time.Duration
+
+	// Hooks are commands run at certain points of the attach lifecycle.
+	PreMountHook  string
+	PostMountHook string
+	PreDetachHook string
+	// VolumeLostHook is run after the volume was detached externally and its
+	// file system unmounted.
+	VolumeLostHook string
+}
+
+// DefaultConfig returns a Config with default values.
+func DefaultConfig() Config {
+	return Config{
+		NodeIDFormat:            nodeIDFormatString,
+		NodeSelection:           nodeSelectionFirst,
+		StateFile:               "/var/lib/smilodon/node-id",
+		BlockDevice:             "/dev/xvde",
+		AttachTimeout:           60 * time.Second,
+		AttachRetries:           3,
+		HealthThreshold:         3,
+		IOErrorPasses:           3,
+		VolumeStatusChecks:      2,
+		VolumeRequirementAction: volumeRequirementWarn,
+		HealthHoldOff:           10 * time.Minute,
+		IfaceWaitTimeout:        25 * time.Second,
+		FsType:                  "ext4",
+		ZFSPool:                 "smilodon",
+		WarmUpParallel:          4,
+		MountPoint:              "/data",
+		ScratchFsType:           "ext4",
+		MultiAttach:             multiAttachRefuse,
+		ScratchMountPoint:       "/scratch",
+		IfaceMode:               ifaceModeWait,
+		RPFilter:                "2",
+		GratuitousARP:           true,
+		EnvFile:                 "/run/smilodon/environment",
+		EnvFormat:               envFormatSystemd,
+		PollInterval:            120 * time.Second,
+		PollJitter:              0.2,
+		SnapshotRetain:          7,
+		ClusterRecordTTL:        30,
+		ConsulAddr:              "http://127.0.0.1:8500",
+		EtcdPrefix:              "/smilodon/nodes/",
+		KubeTokenFile:           kubeServiceAccountToken,
+		KubeCAFile:              kubeServiceAccountCA,
+	}
+}
@@ -0,0 +1,109 @@
+package smilodon
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// consulClient registers the node as a service with a Consul agent.
+type consulClient struct {
+	addr    string
+	service string
+	token   string
+	ttl     time.Duration
+	client  *http.Client
+
+	// serviceID is the ID of the registered service, empty if none.
+	serviceID string
+}
+
+// newConsulClient returns a consulClient registering service with the agent
+// at addr, or nil if service is empty. The health check fails unless passed
+// within ttl.
+func newConsulClient(addr, service, token string, ttl time.Duration) *consulClient {
+	if service == "" {
+		return nil
+	}
+	return &consulClient{
+		addr:    strings.TrimSuffix(addr, "/"),
+		service: service,
+		token:   token,
+		ttl:     ttl,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// put calls the agent endpoint path with body in, which may be nil.
+func (c *consulClient) put(ctx context.Context, path string, in interface{}) error {
+	h := http.Header{}
+	if c.token != "" {
+		h.Set("X-Consul-Token", c.token)
+	}
+	return doJSON(ctx, c.client, "PUT", c.addr+path, h, in, nil)
+}
+
+// registerConsul registers the node held by the instance with Consul, with
+// the IP address of its network interface as the service address and its
+// node ID as service metadata, if enabled. Failures are logged and otherwise
+// ignored.
+func (r *Reconciler) registerConsul(ctx context.Context) {
+	c := r.consul
+	if c == nil || r.node.NetworkInterface == nil {
+		return
+	}
+	id := c.service + "-" + r.node.ID
+	body := map[string]interface{}{
+		"ID":      id,
+		"Name":    c.service,
+		"Address": r.node.NetworkInterface.IPAddress,
+		"Meta": map[string]string{
+			"node_id":     r.node.ID,
+			"instance_id": r.instance.ID,
+		},
+		"Check": map[string]string{
+			"CheckID": "service:" + id,
+			"TTL":     c.ttl.String(),
+		},
+	}
+	log.Printf("Registering %q with Consul.\n", id)
+	if err := c.put(ctx, "/v1/agent/service/register", body); err != nil {
+		log.Printf("Failed to register %q with Consul: %q.\n", id, err)
+		return
+	}
+	c.serviceID = id
+	r.updateConsulHealth(ctx)
+}
+
+// updateConsulHealth passes the health check of the registered service if
+// the node is complete and its file system mounted, if enabled, and fails it
+// otherwise.
+func (r *Reconciler) updateConsulHealth(ctx context.Context) {
+	c := r.consul
+	if c == nil || c.serviceID == "" {
+		return
+	}
+	status := "pass"
+	if !r.Stable() || (r.cfg.MountFs && !r.Mounted()) {
+		status = "fail"
+	}
+	if err := c.put(ctx, "/v1/agent/check/"+status+"/service:"+c.serviceID, nil); err != nil {
+		log.Printf("Failed to update Consul health check of %q: %q.\n", c.serviceID, err)
+	}
+}
+
+// deregisterConsul deregisters the registered service from Consul.
+func (r *Reconciler) deregisterConsul(ctx context.Context) {
+	c := r.consul
+	if c == nil || c.serviceID == "" {
+		return
+	}
+	log.Printf("Deregistering %q from Consul.\n", c.serviceID)
+	if err := c.put(ctx, "/v1/agent/service/deregister/"+c.serviceID, nil); err != nil {
+		log.Printf("Failed to deregister %q from Consul: %q.\n", c.serviceID, err)
+		return
+	}
+	c.serviceID = ""
+}
@@ -0,0 +1,169 @@
+package smilodon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Operations of the control API.
+const (
+	ControlReconcile = "reconcile"
+	ControlRelease   = "release"
+	ControlPause     = "pause"
+	ControlResume    = "resume"
+)
+
+// controlRequest is an operation passed to the reconcile loop, which replies
+// on done once it is carried out.
+type controlRequest struct {
+	op   string
+	done chan error
+}
+
+// Control passes operation op to the reconcile loop and waits for it to be
+// carried out: reconcile runs a pass right away, even while paused; release
+// detaches the node and claims none for HealthHoldOff; pause and resume stop
+// and restart periodic passes.
+func (r *Reconciler) Control(ctx context.Context, op string) error {
+	switch op {
+	case ControlReconcile, ControlRelease, ControlPause, ControlResume:
+	default:
+		return fmt.Errorf("unknown control operation %q", op)
+	}
+	c := controlRequest{op, make(chan error, 1)}
+	select {
+	case r.controls <- c:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-c.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// control carries out control request c in the reconcile loop.
+func (r *Reconciler) control(ctx context.Context, c controlRequest) {
+	var err error
+	switch c.op {
+	case ControlReconcile:
+		log.Println("Reconciling on request.")
+		r.Reconcile(ctx)
+	case ControlRelease:
+		log.Printf("Releasing node %q on request.\n", r.node.ID)
+		r.publishEvent(ctx, eventNodeReleased, "released on request")
+		if err = r.Detach(ctx); err == nil {
+			r.holdOffUntil = time.Now().Add(r.cfg.HealthHoldOff)
+		}
+	case ControlPause:
+		log.Println("Pausing reconcile passes on request.")
+		r.paused = true
+	case ControlResume:
+		log.Println("Resuming reconcile passes on request.")
+		r.paused = false
+	}
+	r.recordError(err)
+	r.recordState()
+	c.done <- err
+}
+
+// ServeControl serves the control API on Unix socket path until ctx is done.
+// GET /state returns the State, POST /<operation> carries out a control
+// operation. The socket is only accessible by the owner.
+func (r *Reconciler) ServeControl(ctx context.Context, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// A stale socket is left behind if the daemon was killed.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := listenPrivate(path)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			controlReply(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+			return
+		}
+		controlReply(w, http.StatusOK, r.State())
+	})
+	for _, op := range []string{ControlReconcile, ControlRelease, ControlPause, ControlResume} {
+		op := op
+		mux.HandleFunc("/"+op, func(w http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodPost {
+				controlReply(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+				return
+			}
+			if err := r.Control(req.Context(), op); err != nil {
+				controlReply(w, http.StatusInternalServerError, err)
+				return
+			}
+			controlReply(w, http.StatusOK, r.State())
+		})
+	}
+	s := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		s.Close()
+		os.Remove(path)
+	}()
+	log.Printf("Serving the control API on %q.\n", path)
+	if err := s.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// listenPrivate listens on Unix socket path, which is only accessible by the
+// owner from the start. The socket is created in a private directory next to
+// path, restricted and then renamed to path, so that there is no window in
+// which other users can connect, without changing the umask of the process.
+func listenPrivate(path string) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// The socket is removed on shutdown by path instead.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// controlReply writes v, or the message of error v, as the JSON response with
+// status code.
+func controlReply(w http.ResponseWriter, code int, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = map[string]string{"error": err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	e.Encode(v)
+}
@@ -0,0 +1,38 @@
+package smilodon
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenPrivate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smilodon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "control.sock")
+	l, err := listenPrivate(path)
+	if err != nil {
+		t.Fatalf("listenPrivate() error = %v", err)
+	}
+	defer l.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Errorf("listenPrivate() created %v, want a socket with mode 0600", fi.Mode())
+	}
+	if fs, _ := ioutil.ReadDir(dir); len(fs) != 1 {
+		t.Errorf("listenPrivate() left %d files behind, want only the socket", len(fs))
+	}
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect to the socket: %v", err)
+	}
+	c.Close()
+}
@@ -0,0 +1,94 @@
+package smilodon
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// waitForDevice blocks until block device d appears or timeout passes. It
+// watches the device directory with inotify, so the device is picked up as
+// soon as udev creates it, and checks again at least every second in case
+// the directory itself does not exist yet.
+func (h host) waitForDevice(d string, timeout time.Duration) error {
+	if _, err := os.Stat(h.path(d)); err == nil {
+		return nil
+	}
+	log.Printf("Waiting for block device %q to appear.\n", d)
+	var events *os.File
+	if fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK); err == nil {
+		syscall.InotifyAddWatch(fd, filepath.Dir(h.path(d)), syscall.IN_CREATE|syscall.IN_MOVED_TO)
+		events = os.NewFile(uintptr(fd), "inotify")
+		defer events.Close()
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		if _, err := os.Stat(h.path(d)); err == nil {
+			log.Printf("Block device %q appeared.\n", d)
+			return nil
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return fmt.Errorf("block device %q did not appear within %s", d, timeout)
+		}
+		if left > time.Second {
+			left = time.Second
+		}
+		waitReadable(events, left)
+	}
+}
+
+// deviceReadyTries is the number of times a block device is checked before
+// giving up, deviceReadyInterval apart.
+const (
+	deviceReadyTries    = 5
+	deviceReadyInterval = 2 * time.Second
+)
+
+// waitDeviceReady checks that d is a block device which is not busy, that is
+// it can be opened exclusively, retrying while it settles.
+func (h host) waitDeviceReady(d string) error {
+	var err error
+	for tries := 0; tries < deviceReadyTries; tries++ {
+		if tries > 0 {
+			time.Sleep(deviceReadyInterval)
+		}
+		if err = h.deviceReady(d); err == nil {
+			return nil
+		}
+		log.Printf("Block device %q is not ready: %q.\n", d, err)
+	}
+	return err
+}
+
+// deviceReady checks that d is a block device which is not busy.
+func (h host) deviceReady(d string) error {
+	fi, err := os.Stat(h.path(d))
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeDevice == 0 || fi.Mode()&os.ModeCharDevice != 0 {
+		return fmt.Errorf("%q is not a block device", d)
+	}
+	// Opening a block device exclusively fails while it is mounted or held
+	// by another exclusive user.
+	fd, err := syscall.Open(h.path(d), syscall.O_RDONLY|syscall.O_EXCL|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("%q is busy: %v", d, err)
+	}
+	return syscall.Close(fd)
+}
+
+// waitReadable waits until non-blocking file f is readable and drains it, or
+// waits for timeout t. It just sleeps if f is nil.
+func waitReadable(f *os.File, t time.Duration) {
+	if f == nil || f.SetReadDeadline(time.Now().Add(t)) != nil {
+		time.Sleep(t)
+		return
+	}
+	buf := make([]byte, syscall.SizeofInotifyEvent*16+syscall.NAME_MAX+1)
+	f.Read(buf)
+}
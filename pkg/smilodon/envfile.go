@@ -0,0 +1,104 @@
+package smilodon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Supported environment file formats.
+const (
+	envFormatSystemd = "systemd"
+	envFormatDotenv  = "dotenv"
+	envFormatJSON    = "json"
+	envFormatShell   = "shell"
+)
+
+// envVar is a single environment file variable.
+type envVar struct {
+	key   string
+	value string
+}
+
+// envVars returns environment file variables of the node, whose keys are
+// prefixed with EnvPrefix, so that dependent services need not query the
+// provider for anything smilodon knows already.
+func (r *Reconciler) envVars() []envVar {
+	n, d := r.node, r.fsDevice()
+	// The interface name and device path are left empty if they cannot be
+	// looked up.
+	iface, _ := findIface(*n.NetworkInterface)
+	path, err := r.host.evalSymlinks(d)
+	if err != nil {
+		path = ""
+	}
+	vs := []envVar{
+		{"NODE_IP", n.NetworkInterface.IPAddress},
+		{"NODE_ID", n.ID},
+		{"VOLUME_ID", n.Volume.ID},
+		{"NETWORK_INTERFACE_ID", n.NetworkInterface.ID},
+		{"DEVICE", d},
+		{"NODE_IPS", strings.Join(append([]string{n.NetworkInterface.IPAddress}, n.NetworkInterface.SecondaryIPAddresses...), ",")},
+		{"INTERFACE_NAME", iface},
+		{"MAC_ADDRESS", n.NetworkInterface.MACAddress},
+		{"DEVICE_PATH", path},
+		{"MOUNT_POINT", r.mountPoint()},
+		{"AVAILABILITY_ZONE", r.instance.AZ},
+		{"REGION", r.instance.Region},
+	}
+	for i := range vs {
+		vs[i].key = r.cfg.EnvPrefix + vs[i].key
+	}
+	return vs
+}
+
+// formatEnv formats variables vs in format f.
+func formatEnv(vs []envVar, f string) ([]byte, error) {
+	var b bytes.Buffer
+	switch f {
+	case envFormatSystemd:
+		for _, v := range vs {
+			fmt.Fprintf(&b, "%s=%s\n", v.key, v.value)
+		}
+	case envFormatDotenv:
+		for _, v := range vs {
+			fmt.Fprintf(&b, "%s=%s\n", v.key, strconv.Quote(v.value))
+		}
+	case envFormatShell:
+		for _, v := range vs {
+			fmt.Fprintf(&b, "export %s='%s'\n", v.key, strings.Replace(v.value, "'", `'\''`, -1))
+		}
+	case envFormatJSON:
+		m := map[string]string{}
+		for _, v := range vs {
+			m[v.key] = v.value
+		}
+		j, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		b.Write(j)
+		b.WriteString("\n")
+	default:
+		return nil, fmt.Errorf("unknown environment file format %q", f)
+	}
+	return b.Bytes(), nil
+}
+
+// writeEnvFile writes an environment file f and returns an error if any. A
+// path to a file gets created as well.
+func (r *Reconciler) writeEnvFile(f string) (err error) {
+	s, err := formatEnv(r.envVars(), r.cfg.EnvFormat)
+	if err != nil {
+		log.Printf("Failed to format an environment file %q: %q.\n", f, err)
+		return err
+	}
+	if err := r.files.write(f, s); err != nil {
+		log.Printf("Failed to write an environment file %q: %q.\n", f, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package smilodon
+
+import "testing"
+
+func TestFormatEnv(t *testing.T) {
+	vs := []envVar{{"NODE_ID", "3"}, {"NODE_IP", "10.0.1.20"}, {"MOUNT_POINT", "/data/it's"}}
+	for _, tc := range []struct {
+		f       string
+		want    string
+		wantErr bool
+	}{
+		{
+			f:    envFormatSystemd,
+			want: "NODE_ID=3\nNODE_IP=10.0.1.20\nMOUNT_POINT=/data/it's\n",
+		},
+		{
+			f:    envFormatDotenv,
+			want: "NODE_ID=\"3\"\nNODE_IP=\"10.0.1.20\"\nMOUNT_POINT=\"/data/it's\"\n",
+		},
+		{
+			f:    envFormatShell,
+			want: "export NODE_ID='3'\nexport NODE_IP='10.0.1.20'\nexport MOUNT_POINT='/data/it'\\''s'\n",
+		},
+		{
+			f:    envFormatJSON,
+			want: "{\n  \"MOUNT_POINT\": \"/data/it's\",\n  \"NODE_ID\": \"3\",\n  \"NODE_IP\": \"10.0.1.20\"\n}\n",
+		},
+		{
+			f:       "yaml",
+			wantErr: true,
+		},
+	} {
+		got, err := formatEnv(vs, tc.f)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("formatEnv(%s) error = %v, want error %t", tc.f, err, tc.wantErr)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("formatEnv(%s) = %q, want %q", tc.f, got, tc.want)
+		}
+	}
+}
+
+func TestFormatEnvEmpty(t *testing.T) {
+	for _, tc := range []struct{ f, want string }{
+		{envFormatSystemd, ""},
+		{envFormatJSON, "{}\n"},
+	} {
+		got, err := formatEnv(nil, tc.f)
+		if err != nil || string(got) != tc.want {
+			t.Errorf("formatEnv(%s) = %q, %v, want %q", tc.f, got, err, tc.want)
+		}
+	}
+}
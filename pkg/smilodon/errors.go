@@ -0,0 +1,55 @@
+package smilodon
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/smithy-go"
+)
+
+// Error categories, which errors returned by the package wrap where they
+// apply, so that callers can branch on them with errors.Is.
+var (
+	// ErrMetadata means the instance metadata could not be read.
+	ErrMetadata = errors.New("metadata failure")
+	// ErrPermissionDenied means the cloud provider denied an API call.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrNoResources means no suitable volume or network interface was found.
+	ErrNoResources = errors.New("no resources")
+	// ErrAttachTimeout means an attached volume did not show up in time.
+	ErrAttachTimeout = errors.New("attach timeout")
+	// ErrFilesystem means the file system could not be created or mounted.
+	ErrFilesystem = errors.New("file system failure")
+)
+
+// categorize wraps API error err with ErrPermissionDenied if the call was
+// denied, and returns it unchanged otherwise.
+func categorize(err error) error {
+	var e smithy.APIError
+	if err == nil || !errors.As(err, &e) {
+		return err
+	}
+	switch e.ErrorCode() {
+	case "UnauthorizedOperation", "AccessDenied", "AccessDeniedException", "AuthFailure":
+		return fmt.Errorf("%w: %v", ErrPermissionDenied, err)
+	}
+	return err
+}
+
+// errVolumeHeld means a volume could not be attached as another instance
+// holds it.
+var errVolumeHeld = errors.New("volume held by another instance")
+
+// errVolumeDetaching means a volume could not be attached as it is still
+// being detached from another instance.
+var errVolumeDetaching = errors.New("volume being detached")
+
+// volumeInUse checks whether API error err means the volume to attach is
+// attached already.
+func volumeInUse(err error) bool {
+	var e smithy.APIError
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.ErrorCode() == "VolumeInUse" || e.ErrorCode() == "IncorrectState"
+}
@@ -0,0 +1,274 @@
+package smilodon
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etcdRegistry records which instance holds which node ID in etcd, through
+// the JSON gateway of the etcd v3 API. Keys are attached to a lease kept
+// alive on every reconcile pass, so that the node IDs of dead instances are
+// released.
+type etcdRegistry struct {
+	endpoints []string
+	prefix    string
+	ttl       time.Duration
+	client    *http.Client
+
+	// leaseID is the ID of the current lease, empty if none.
+	leaseID string
+}
+
+// registryEntry is the value of a registry key.
+type registryEntry struct {
+	InstanceID         string `json:"instance_id"`
+	AvailabilityZone   string `json:"availability_zone"`
+	NodeID             string `json:"node_id"`
+	VolumeID           string `json:"volume_id,omitempty"`
+	NetworkInterfaceID string `json:"network_interface_id,omitempty"`
+	IPAddress          string `json:"ip_address,omitempty"`
+}
+
+type etcdKV struct {
+	Value       string `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+// newEtcdRegistry returns an etcdRegistry of comma-delimited endpoints, or
+// nil if endpoints is empty.
+func newEtcdRegistry(endpoints, prefix string, ttl time.Duration) *etcdRegistry {
+	if endpoints == "" {
+		return nil
+	}
+	var es []string
+	for _, e := range strings.Split(endpoints, ",") {
+		es = append(es, strings.TrimSuffix(strings.TrimSpace(e), "/"))
+	}
+	return &etcdRegistry{
+		endpoints: es,
+		prefix:    prefix,
+		ttl:       ttl,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// call calls gateway path p on the first endpoint that responds.
+func (e *etcdRegistry) call(ctx context.Context, p string, in, out interface{}) error {
+	var err error
+	for _, ep := range e.endpoints {
+		if err = doJSON(ctx, e.client, "POST", ep+"/v3"+p, nil, in, out); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// key returns the base64 encoded registry key of node ID id.
+func (e *etcdRegistry) key(id string) string {
+	return base64.StdEncoding.EncodeToString([]byte(e.prefix + id))
+}
+
+// lease returns the current lease, granting one if there is none.
+func (e *etcdRegistry) lease(ctx context.Context) (string, error) {
+	if e.leaseID != "" {
+		return e.leaseID, nil
+	}
+	var r struct {
+		ID string `json:"ID"`
+	}
+	ttl := strconv.Itoa(int(e.ttl.Seconds()))
+	if err := e.call(ctx, "/lease/grant", map[string]string{"TTL": ttl}, &r); err != nil {
+		return "", err
+	}
+	e.leaseID = r.ID
+	return r.ID, nil
+}
+
+// keepAlive renews the current lease. It returns false if the lease expired,
+// in which case its keys are gone.
+func (e *etcdRegistry) keepAlive(ctx context.Context) (bool, error) {
+	if e.leaseID == "" {
+		return false, nil
+	}
+	var r struct {
+		Result struct {
+			TTL string `json:"TTL"`
+		} `json:"result"`
+	}
+	if err := e.call(ctx, "/lease/keepalive", map[string]string{"ID": e.leaseID}, &r); err != nil {
+		return true, err
+	}
+	if r.Result.TTL == "" || r.Result.TTL == "0" {
+		e.leaseID = ""
+		return false, nil
+	}
+	return true, nil
+}
+
+// get returns the key of node ID id and its entry, or nil if there is none.
+func (e *etcdRegistry) get(ctx context.Context, id string) (*etcdKV, *registryEntry, error) {
+	var r struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	if err := e.call(ctx, "/kv/range", map[string]string{"key": e.key(id)}, &r); err != nil {
+		return nil, nil, err
+	}
+	if len(r.KVs) == 0 {
+		return nil, nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(r.KVs[0].Value)
+	if err != nil {
+		return nil, nil, err
+	}
+	var en registryEntry
+	if err := json.Unmarshal(b, &en); err != nil {
+		return nil, nil, err
+	}
+	return &r.KVs[0], &en, nil
+}
+
+// put stores entry en under its node ID with the current lease if the key is
+// absent or, if kv is not nil, unchanged since kv was read. It returns false
+// if the condition failed.
+func (e *etcdRegistry) put(ctx context.Context, en registryEntry, kv *etcdKV) (bool, error) {
+	lease, err := e.lease(ctx)
+	if err != nil {
+		return false, err
+	}
+	b, err := json.Marshal(en)
+	if err != nil {
+		return false, err
+	}
+	k := e.key(en.NodeID)
+	cmp := map[string]string{"key": k, "result": "EQUAL", "target": "CREATE", "create_revision": "0"}
+	if kv != nil {
+		cmp = map[string]string{"key": k, "result": "EQUAL", "target": "MOD", "mod_revision": kv.ModRevision}
+	}
+	txn := map[string]interface{}{
+		"compare": []interface{}{cmp},
+		"success": []interface{}{map[string]interface{}{
+			"request_put": map[string]string{
+				"key":   k,
+				"value": base64.StdEncoding.EncodeToString(b),
+				"lease": lease,
+			},
+		}},
+	}
+	var r struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	if err := e.call(ctx, "/kv/txn", txn, &r); err != nil {
+		return false, err
+	}
+	return r.Succeeded, nil
+}
+
+// claim stores entry en unless its node ID is held by another instance. It
+// returns whether the instance holds the node ID.
+func (e *etcdRegistry) claim(ctx context.Context, en registryEntry) (bool, error) {
+	kv, cur, err := e.get(ctx, en.NodeID)
+	if err != nil {
+		return false, err
+	}
+	if cur != nil && cur.InstanceID != en.InstanceID {
+		return false, nil
+	}
+	return e.put(ctx, en, kv)
+}
+
+// release deletes the key of node ID id if it is held by instance i.
+func (e *etcdRegistry) release(ctx context.Context, id, i string) error {
+	kv, cur, err := e.get(ctx, id)
+	if err != nil || cur == nil || cur.InstanceID != i {
+		return err
+	}
+	k := e.key(id)
+	txn := map[string]interface{}{
+		"compare": []interface{}{
+			map[string]string{"key": k, "result": "EQUAL", "target": "MOD", "mod_revision": kv.ModRevision},
+		},
+		"success": []interface{}{map[string]interface{}{
+			"request_delete_range": map[string]string{"key": k},
+		}},
+	}
+	return e.call(ctx, "/kv/txn", txn, nil)
+}
+
+// registryEntry returns the registry entry of node ID id held by the
+// instance.
+func (r *Reconciler) registryEntry(id string) registryEntry {
+	en := registryEntry{
+		InstanceID:       r.instance.ID,
+		AvailabilityZone: r.instance.AZ,
+		NodeID:           id,
+	}
+	if v := r.node.Volume; v != nil && v.NodeID == id {
+		en.VolumeID = v.ID
+	}
+	if n := r.node.NetworkInterface; n != nil && n.NodeID == id {
+		en.NetworkInterfaceID = n.ID
+		en.IPAddress = n.IPAddress
+	}
+	return en
+}
+
+// claimNode claims node ID id in the registry before its volume is attached,
+// if enabled. It returns false if another instance holds it or the registry
+// is unavailable, so that a node ID is never attached twice.
+func (r *Reconciler) claimNode(ctx context.Context, id string) bool {
+	if r.registry == nil {
+		return true
+	}
+	ok, err := r.registry.claim(ctx, r.registryEntry(id))
+	if err != nil {
+		log.Printf("Failed to claim node %q in etcd: %q.\n", id, err)
+		return false
+	}
+	if !ok {
+		log.Printf("Node %q is held by another instance in etcd.\n", id)
+	}
+	return ok
+}
+
+// refreshRegistry keeps the registry lease alive and reclaims the node held
+// by the instance if the lease expired, if enabled.
+func (r *Reconciler) refreshRegistry(ctx context.Context) {
+	if r.registry == nil || r.node.Volume == nil {
+		return
+	}
+	ok, err := r.registry.keepAlive(ctx)
+	if err != nil {
+		log.Printf("Failed to keep etcd lease alive: %q.\n", err)
+		return
+	}
+	if ok {
+		return
+	}
+	if !r.claimNode(ctx, r.node.Volume.NodeID) {
+		log.Printf("Lost node %q in etcd.\n", r.node.Volume.NodeID)
+	}
+}
+
+// releaseClaim releases the claim of node ID id after its volume failed to
+// attach, unless the node is held through its attached network interface.
+func (r *Reconciler) releaseClaim(ctx context.Context, id string) {
+	if n := r.node.NetworkInterface; n == nil || n.NodeID != id {
+		r.releaseNode(ctx, id)
+	}
+}
+
+// releaseNode deletes the registry entry of node ID id, if enabled.
+func (r *Reconciler) releaseNode(ctx context.Context, id string) {
+	if r.registry == nil {
+		return
+	}
+	if err := r.registry.release(ctx, id, r.instance.ID); err != nil {
+		log.Printf("Failed to release node %q in etcd: %q.\n", id, err)
+	}
+}
@@ -0,0 +1,109 @@
+package smilodon
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// Event types published on node identity changes.
+const (
+	eventVolumeAttached           = "VolumeAttached"
+	eventNetworkInterfaceAttached = "NetworkInterfaceAttached"
+	eventNetworkInterfaceDetached = "NetworkInterfaceDetached"
+	eventVolumeDetached           = "VolumeDetached"
+	eventNodeIDAcquired           = "NodeIDAcquired"
+	eventAttachFailed             = "AttachFailed"
+	eventVolumeLost               = "VolumeLost"
+	eventNodeReleased             = "NodeReleased"
+	eventVolumeDegraded           = "VolumeDegraded"
+	eventVolumeImpaired           = "VolumeImpaired"
+)
+
+// event describes a node identity change.
+type event struct {
+	Type               string    `json:"type"`
+	Time               time.Time `json:"time"`
+	InstanceID         string    `json:"instance_id"`
+	AvailabilityZone   string    `json:"availability_zone"`
+	NodeID             string    `json:"node_id,omitempty"`
+	VolumeID           string    `json:"volume_id,omitempty"`
+	NetworkInterfaceID string    `json:"network_interface_id,omitempty"`
+	IPAddress          string    `json:"ip_address,omitempty"`
+	Message            string    `json:"message,omitempty"`
+}
+
+// eventPublisher publishes events to an SNS topic and an SQS queue.
+type eventPublisher struct {
+	topic string
+	queue string
+	snsc  *sns.Client
+	sqsc  *sqs.Client
+}
+
+// newEventPublisher creates SNS and SQS clients with config cfg if event
+// publishing is enabled.
+func newEventPublisher(topic, queue string, cfg aws.Config) *eventPublisher {
+	e := &eventPublisher{topic: topic, queue: queue}
+	if topic != "" {
+		e.snsc = sns.NewFromConfig(cfg)
+	}
+	if queue != "" {
+		e.sqsc = sqs.NewFromConfig(cfg)
+	}
+	return e
+}
+
+// publishEvent publishes an event of type t about the node held by the
+// instance to the configured SNS topic and SQS queue. Failures are logged and
+// otherwise ignored.
+func (r *Reconciler) publishEvent(ctx context.Context, t, msg string) {
+	p := r.events
+	if p.snsc == nil && p.sqsc == nil {
+		return
+	}
+	e := event{
+		Type:             t,
+		Time:             time.Now().UTC(),
+		InstanceID:       r.instance.ID,
+		AvailabilityZone: r.instance.AZ,
+		NodeID:           r.node.ID,
+		Message:          msg,
+	}
+	if r.node.Volume != nil {
+		e.VolumeID = r.node.Volume.ID
+	}
+	if r.node.NetworkInterface != nil {
+		e.NetworkInterfaceID = r.node.NetworkInterface.ID
+		e.IPAddress = r.node.NetworkInterface.IPAddress
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Failed to encode %q event: %q.\n", t, err)
+		return
+	}
+	if p.snsc != nil {
+		_, err := p.snsc.Publish(ctx, &sns.PublishInput{
+			TopicArn: aws.String(p.topic),
+			Subject:  aws.String("smilodon: " + t),
+			Message:  aws.String(string(b)),
+		})
+		if err != nil {
+			log.Printf("Failed to publish %q event to %q: %q.\n", t, p.topic, err)
+		}
+	}
+	if p.sqsc != nil {
+		_, err := p.sqsc.SendMessage(ctx, &sqs.SendMessageInput{
+			QueueUrl:    aws.String(p.queue),
+			MessageBody: aws.String(string(b)),
+		})
+		if err != nil {
+			log.Printf("Failed to send %q event to %q: %q.\n", t, p.queue, err)
+		}
+	}
+}
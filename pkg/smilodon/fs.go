@@ -0,0 +1,297 @@
+package smilodon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// fsType returns the file system type of d, or an empty string if it has
+// none.
+func (h host) fsType(d string) (string, error) {
+	o, err := h.command("/usr/bin/lsblk", "-n", "-o", "FSTYPE", d).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(string(o), "\n"), nil
+}
+
+// hasFs checks if d has a file system created and returns a bool.
+func (h host) hasFs(d, f string) bool {
+	fs, err := h.fsType(d)
+	if err != nil {
+		log.Printf("Failed to read file system type of %q: %q.\n", d, err)
+		// Return true here just to be on the safe side
+		// FIXME: I think the process should exit here?
+		return true
+	}
+	if fs == f {
+		return true
+	}
+	if fs == "" {
+		return false
+	}
+	log.Printf("Device %q appears to have a %q file system. However specified file system is %q.\n", d, fs, f)
+	return true
+}
+
+// signatures returns the file system, RAID, LVM and partition table
+// signatures found on device d, for example 'TYPE=LVM2_member'.
+func (h host) signatures(d string) ([]string, error) {
+	o, err := h.command("/usr/sbin/blkid", "-p", "-o", "export", d).Output()
+	if err != nil {
+		// blkid exits with 2 if no signatures are found.
+		if e, ok := err.(*exec.ExitError); ok && e.Sys().(syscall.WaitStatus).ExitStatus() == 2 {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var sigs []string
+	for _, l := range strings.Split(string(o), "\n") {
+		if strings.HasPrefix(l, "TYPE=") || strings.HasPrefix(l, "PTTYPE=") {
+			sigs = append(sigs, l)
+		}
+	}
+	return sigs, nil
+}
+
+// wipe erases all signatures of device d.
+func (h host) wipe(d string) error {
+	log.Printf("Erasing all signatures of %q.\n", d)
+	o, err := h.command("/usr/sbin/wipefs", "-a", d).CombinedOutput()
+	if err != nil {
+		log.Printf("Failed to erase signatures of %q: %q.\n", d, string(o))
+		return err
+	}
+	return nil
+}
+
+// partition creates a GPT with a single partition spanning device d.
+func (h host) partition(d string) error {
+	log.Printf("Creating a partition table on %q.\n", d)
+	cmd := h.command("/usr/sbin/sfdisk", "-q", d)
+	cmd.Stdin = strings.NewReader("label: gpt\n,\n")
+	if o, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Failed to create a partition table on %q: %q.\n", d, string(o))
+		return err
+	}
+	return nil
+}
+
+// partitionDevice returns the path of partition n of device d, for example
+// /dev/xvde1 or /dev/nvme1n1p1 for devices ending with a digit.
+func partitionDevice(d string, n int) string {
+	if c := d[len(d)-1]; c >= '0' && c <= '9' {
+		return d + "p" + strconv.Itoa(n)
+	}
+	return d + strconv.Itoa(n)
+}
+
+// growPartition grows partition n of device d to the end of the device.
+func (h host) growPartition(d string, n int) error {
+	log.Printf("Growing partition %d of %q.\n", n, d)
+	cmd := h.command("/usr/sbin/sfdisk", "-q", "--no-reread", "-N", strconv.Itoa(n), d)
+	cmd.Stdin = strings.NewReader(", +\n")
+	if o, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Failed to grow partition %d of %q: %q.\n", n, d, string(o))
+		return err
+	}
+	// The kernel does not reread the partition table of a disk in use.
+	if o, err := h.command("/usr/sbin/partx", "-u", d).CombinedOutput(); err != nil {
+		log.Printf("Failed to update partitions of %q: %q.\n", d, string(o))
+		return err
+	}
+	return nil
+}
+
+// growFs grows file system f on device d mounted at p to the size of d.
+func (h host) growFs(d, p, f string) error {
+	var cmd *exec.Cmd
+	switch f {
+	case "ext2", "ext3", "ext4":
+		cmd = h.command("/usr/sbin/resize2fs", d)
+	case "xfs":
+		cmd = h.command("/usr/sbin/xfs_growfs", p)
+	case "btrfs":
+		cmd = h.command("/usr/sbin/btrfs", "filesystem", "resize", "max", p)
+	case fsZFS:
+		log.Printf("Growing ZFS pool on %q.\n", d)
+		return h.zfsGrow(d)
+	default:
+		log.Printf("Growing %q file systems is not supported.\n", f)
+		return fmt.Errorf("growing %q file systems is not supported", f)
+	}
+	log.Printf("Growing file system on %q.\n", d)
+	if o, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Failed to grow file system on %q: %q.\n", d, string(o))
+		return err
+	}
+	return nil
+}
+
+// mkfs creates file system f on device d, passing extra options opts to
+// mkfs.
+func (h host) mkfs(d, f string, opts []string) error {
+	mkfsCmd := "/usr/sbin/mkfs." + f
+	args := append([]string{"-q"}, opts...)
+	cmd := h.command(mkfsCmd, append(args, d)...)
+	err := cmd.Run()
+	if err != nil {
+		log.Printf("Failed to create %q file system on %q device: %q.\n", f, d, err)
+		return err
+	}
+	log.Printf("Successfully formatted device %q with file system %q.\n", d, f)
+	return nil
+}
+
+// mount mounts device d with file system type t and options opts, if any, to
+// mount point p and returns an error if any.
+func (h host) mount(d, p, t string, opts []string) (err error) {
+	if _, err := os.Stat(h.path(p)); os.IsNotExist(err) {
+		log.Printf("Mount point %q does not exist. Creating %q.\n", p, p)
+		if err := os.MkdirAll(h.path(p), 0750); err != nil {
+			log.Printf("Failed to create the mount path: %q.\n", err)
+			return err
+		}
+	}
+	log.Printf("Mounting %q to %q.\n", d, p)
+	args := []string{"-t", t}
+	if len(opts) > 0 {
+		args = append(args, "-o", strings.Join(opts, ","))
+	}
+	cmd := h.command("/usr/bin/mount", append(args, d, p)...)
+	o, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Printf("Mount failed: %q to %q: %q.\n", d, p, string(o))
+		return err
+	}
+	log.Printf("Successfully mounted device %q to %q.\n", d, p)
+	return nil
+}
+
+// unmount unmounts the file system mounted to mount point p.
+func (h host) unmount(p string) error {
+	log.Printf("Unmounting %q.\n", p)
+	o, err := h.command("/usr/bin/umount", p).CombinedOutput()
+	if err != nil {
+		log.Printf("Unmount failed: %q: %q.\n", p, string(o))
+		return err
+	}
+	log.Printf("Successfully unmounted %q.\n", p)
+	return nil
+}
+
+// unmountLazy detaches the file system mounted to mount point p right away
+// and cleans it up once it is no longer busy, which works for file systems
+// whose device is gone.
+func (h host) unmountLazy(p string) error {
+	log.Printf("Lazily unmounting %q.\n", p)
+	o, err := h.command("/usr/bin/umount", "-l", p).CombinedOutput()
+	if err != nil {
+		log.Printf("Unmount failed: %q: %q.\n", p, string(o))
+		return err
+	}
+	return nil
+}
+
+// isMountPoint checks if a file system is mounted to mount point p.
+func (h host) isMountPoint(p string) bool {
+	v, err := ioutil.ReadFile(h.mountsFile())
+	if err != nil {
+		log.Printf("Failed to read mounts information from %s: %q.\n", h.mountsFile(), err)
+		return false
+	}
+	for _, l := range strings.Split(string(v), "\n") {
+		if f := strings.Fields(l); len(f) > 1 && f[1] == p {
+			return true
+		}
+	}
+	return false
+}
+
+// isMounted checks if device d is mounted. It returns a boolean
+func (h host) isMounted(d string) bool {
+	v, err := ioutil.ReadFile(h.mountsFile())
+	if err != nil {
+		log.Printf("Failed to read mounts information from %s: %q.\n", h.mountsFile(), err)
+	}
+	if strings.Contains(string(v), d+" ") {
+		return true
+	}
+	return false
+}
+
+// mountPerms is the ownership and mode applied to the mount point after
+// mounting. A zero mode and negative IDs are left untouched.
+type mountPerms struct {
+	mode os.FileMode
+	uid  int
+	gid  int
+}
+
+// parseMountPerms parses mount point owner o of the form user:group, with
+// names or numeric IDs, and octal mode m. Both may be empty.
+func parseMountPerms(o, m string) (mountPerms, error) {
+	p := mountPerms{uid: -1, gid: -1}
+	if o != "" {
+		parts := strings.Split(o, ":")
+		if len(parts) != 2 {
+			return p, fmt.Errorf("invalid mount owner %q, expected user:group", o)
+		}
+		var err error
+		if p.uid, err = lookupUID(parts[0]); err != nil {
+			return p, err
+		}
+		if p.gid, err = lookupGID(parts[1]); err != nil {
+			return p, err
+		}
+	}
+	if m != "" {
+		mode, err := strconv.ParseUint(m, 8, 32)
+		if err != nil {
+			return p, fmt.Errorf("invalid mount mode %q: %v", m, err)
+		}
+		p.mode = os.FileMode(mode)
+	}
+	return p, nil
+}
+
+// apply sets the ownership and mode of mount point mp.
+func (p mountPerms) apply(h host, mp string) error {
+	if p.uid >= 0 || p.gid >= 0 {
+		if err := os.Chown(h.path(mp), p.uid, p.gid); err != nil {
+			log.Printf("Failed to set ownership of %q: %q.\n", mp, err)
+			return err
+		}
+	}
+	if p.mode != 0 {
+		if err := os.Chmod(h.path(mp), p.mode); err != nil {
+			log.Printf("Failed to set mode of %q: %q.\n", mp, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// selinuxEnabled checks whether SELinux is enabled on the host.
+func (h host) selinuxEnabled() bool {
+	_, err := os.Stat(h.path("/sys/fs/selinux/enforce"))
+	return err == nil
+}
+
+// relabel restores the default SELinux contexts of the files under mount
+// point p.
+func (h host) relabel(p string) error {
+	log.Printf("Relabeling %q.\n", p)
+	o, err := h.command("/usr/sbin/restorecon", "-R", p).CombinedOutput()
+	if err != nil {
+		log.Printf("Relabeling failed: %q: %q.\n", p, string(o))
+		return err
+	}
+	return nil
+}
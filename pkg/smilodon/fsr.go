@@ -0,0 +1,138 @@
+package smilodon
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// fsrCheckInterval is the interval between fast snapshot restore checks.
+const fsrCheckInterval = 10 * time.Minute
+
+// Fast snapshot restore states which count as enabled.
+var fsrActive = map[string]bool{"enabling": true, "optimizing": true, "enabled": true}
+
+// fastSnapshotRestorer is implemented by providers which can initialize
+// volumes restored from snapshots in advance.
+type fastSnapshotRestorer interface {
+	// FastSnapshotRestores returns the fast snapshot restore states of
+	// snapshots ids by snapshot ID and availability zone.
+	FastSnapshotRestores(ctx context.Context, ids []string) (map[string]map[string]string, error)
+	// EnableFastSnapshotRestores enables fast snapshot restores of snapshot
+	// id in availability zones azs.
+	EnableFastSnapshotRestores(ctx context.Context, id string, azs []string) error
+	// DisableFastSnapshotRestores disables fast snapshot restores of
+	// snapshot id in availability zones azs.
+	DisableFastSnapshotRestores(ctx context.Context, id string, azs []string) error
+}
+
+// latestSnapshot returns the latest completed snapshot of ss, or nil.
+func latestSnapshot(ss []snapshot) *snapshot {
+	var latest *snapshot
+	for i, s := range ss {
+		if s.Completed && (latest == nil || s.StartTime.After(latest.StartTime)) {
+			latest = &ss[i]
+		}
+	}
+	return latest
+}
+
+// fastSnapshotRestore enables fast snapshot restores of the latest completed
+// scheduled snapshot of the volume held by the node in the
+// FastSnapshotRestoreAZs, and disables them for its older snapshots, as they
+// are billed by the hour. It runs at most once every fsrCheckInterval.
+func (r *Reconciler) fastSnapshotRestore(ctx context.Context) {
+	if len(r.cfg.FastSnapshotRestoreAZs) == 0 || !r.Stable() {
+		return
+	}
+	if !r.lastFSRCheck.IsZero() && time.Since(r.lastFSRCheck) < fsrCheckInterval {
+		return
+	}
+	r.lastFSRCheck = time.Now()
+	v := *r.node.Volume
+	latest, states, err := r.fsrStates(ctx, v)
+	if err != nil {
+		log.Printf("Failed to check fast snapshot restores of volume %q: %q.\n", v.ID, err)
+		return
+	}
+	r.fsrState = states[latest]
+	if latest == "" {
+		return
+	}
+	var missing []string
+	for _, az := range r.cfg.FastSnapshotRestoreAZs {
+		if !fsrActive[states[latest][az]] {
+			missing = append(missing, az)
+		}
+	}
+	p := r.provider.(fastSnapshotRestorer)
+	if len(missing) > 0 {
+		log.Printf("Enabling fast snapshot restores of snapshot %q in %q.\n", latest, missing)
+		if err := p.EnableFastSnapshotRestores(ctx, latest, missing); err != nil {
+			log.Printf("Failed to enable fast snapshot restores of snapshot %q: %q.\n", latest, err)
+		}
+	}
+	for id, s := range states {
+		if id == latest {
+			continue
+		}
+		var azs []string
+		for az, state := range s {
+			if fsrActive[state] {
+				azs = append(azs, az)
+			}
+		}
+		if len(azs) == 0 {
+			continue
+		}
+		sort.Strings(azs)
+		log.Printf("Disabling fast snapshot restores of snapshot %q in %q.\n", id, azs)
+		if err := p.DisableFastSnapshotRestores(ctx, id, azs); err != nil {
+			log.Printf("Failed to disable fast snapshot restores of snapshot %q: %q.\n", id, err)
+		}
+	}
+}
+
+// fsrStates returns the ID of the latest completed scheduled snapshot of
+// volume v and the fast snapshot restore states of its snapshots.
+func (r *Reconciler) fsrStates(ctx context.Context, v Volume) (string, map[string]map[string]string, error) {
+	p, ok := r.provider.(fastSnapshotRestorer)
+	sp, snapshots := r.provider.(volumeSnapshotter)
+	if !ok || !snapshots {
+		return "", nil, errors.New("fast snapshot restores are not supported by the provider")
+	}
+	ss, err := sp.Snapshots(ctx, v)
+	if err != nil {
+		return "", nil, err
+	}
+	var ids []string
+	for _, s := range ss {
+		if s.Completed {
+			ids = append(ids, s.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return "", nil, nil
+	}
+	states, err := p.FastSnapshotRestores(ctx, ids)
+	if err != nil {
+		return "", nil, err
+	}
+	return latestSnapshot(ss).ID, states, nil
+}
+
+// FastSnapshotRestores returns the fast snapshot restore states of the latest
+// completed scheduled snapshot of the volume held by the node by
+// availability zone, or nil if there is none.
+func (r *Reconciler) FastSnapshotRestores(ctx context.Context, n Node) (map[string]string, error) {
+	if n.Volume == nil {
+		return nil, nil
+	}
+	latest, states, err := r.fsrStates(ctx, *n.Volume)
+	if err != nil {
+		return nil, err
+	}
+	return states[latest], nil
+}
@@ -0,0 +1,86 @@
+package smilodon
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+)
+
+// fsLabel renders the file system label template of the config with the node
+// data, or returns an empty string if there is none.
+func (r *Reconciler) fsLabel() (string, error) {
+	if r.cfg.FsLabel == "" {
+		return "", nil
+	}
+	t, err := template.New("label").Parse(r.cfg.FsLabel)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, r.templateData()); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// tuneFs sets the reserved block percentage, label and UUID of the file system
+// just created on the block device, as configured.
+func (r *Reconciler) tuneFs() error {
+	label, err := r.fsLabel()
+	if err != nil {
+		return fmt.Errorf("invalid file system label %q: %v", r.cfg.FsLabel, err)
+	}
+	reserved, uuid := r.cfg.FsReservedPercent, r.cfg.FsUUID
+	if label == "" && reserved == "" && uuid == "" {
+		return nil
+	}
+	d, t := r.fsDevice(), r.fileSystemType()
+	var cmds [][]string
+	switch t {
+	case "ext2", "ext3", "ext4":
+		args := []string{"/usr/sbin/tune2fs"}
+		if reserved != "" {
+			args = append(args, "-m", reserved)
+		}
+		if label != "" {
+			args = append(args, "-L", label)
+		}
+		if uuid != "" {
+			args = append(args, "-U", uuid)
+		}
+		cmds = append(cmds, append(args, d))
+	case "xfs":
+		if reserved != "" {
+			log.Println("XFS has no reserved blocks, ignoring the reserved block percentage.")
+		}
+		args := []string{"/usr/sbin/xfs_admin"}
+		if label != "" {
+			args = append(args, "-L", label)
+		}
+		if uuid != "" {
+			// xfs_admin calls a random UUID a generated one.
+			args = append(args, "-U", strings.Replace(uuid, "random", "generate", 1))
+		}
+		if len(args) > 1 {
+			cmds = append(cmds, append(args, d))
+		}
+	case "btrfs":
+		if label != "" {
+			cmds = append(cmds, []string{"/usr/sbin/btrfs", "filesystem", "label", d, label})
+		}
+		if reserved != "" || uuid != "" {
+			log.Println("Only the label of btrfs file systems can be set, ignoring the reserved block percentage and UUID.")
+		}
+	default:
+		return fmt.Errorf("tuning %q file systems is not supported", t)
+	}
+	for _, c := range cmds {
+		log.Printf("Tuning file system on %q: %s.\n", d, strings.Join(c[1:], " "))
+		if o, err := r.host.command(c[0], c[1:]...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %v: %s", c[0], err, strings.TrimSpace(string(o)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package smilodon
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// announceCount is the number of gratuitous ARP and unsolicited neighbor
+// advertisement rounds sent after attachment.
+const announceCount = 3
+
+// announce sends gratuitous ARP for IPv4 address ip and unsolicited neighbor
+// advertisements for global IPv6 addresses of interface iface, so that peers
+// update stale neighbor entries pointing at the previous instance.
+func announce(iface, ip string) {
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		log.Printf("failed to announce addresses of %q: %v", iface, err)
+		return
+	}
+	var v6 []net.IP
+	if addrs, err := i.Addrs(); err == nil {
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.To4() == nil && n.IP.IsGlobalUnicast() {
+				v6 = append(v6, n.IP)
+			}
+		}
+	}
+	for c := 0; c < announceCount; c++ {
+		if c > 0 {
+			time.Sleep(time.Second)
+		}
+		if err := sendGratuitousARP(i, net.ParseIP(ip)); err != nil {
+			log.Printf("failed to send gratuitous ARP on %q: %v", iface, err)
+		}
+		for _, a := range v6 {
+			if err := sendUnsolicitedNA(i, a); err != nil {
+				log.Printf("failed to send unsolicited neighbor advertisement on %q: %v", iface, err)
+			}
+		}
+	}
+	log.Printf("Announced %q on %q.\n", ip, iface)
+}
+
+// htons converts a 16 bit integer to network byte order.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// sendGratuitousARP broadcasts an ARP request and reply for ip from interface
+// i.
+func sendGratuitousARP(i *net.Interface, ip net.IP) error {
+	v4 := ip.To4()
+	if v4 == nil {
+		return fmt.Errorf("%q is not an IPv4 address", ip)
+	}
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	addr := &syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ARP),
+		Ifindex:  i.Index,
+		Halen:    6,
+	}
+	copy(addr.Addr[:], broadcast)
+	for _, op := range []uint16{1, 2} {
+		// Ethernet header followed by the ARP packet.
+		b := make([]byte, 42)
+		copy(b[0:6], broadcast)
+		copy(b[6:12], i.HardwareAddr)
+		binary.BigEndian.PutUint16(b[12:14], syscall.ETH_P_ARP)
+		binary.BigEndian.PutUint16(b[14:16], 1)      // Ethernet
+		binary.BigEndian.PutUint16(b[16:18], 0x0800) // IPv4
+		b[18], b[19] = 6, 4
+		binary.BigEndian.PutUint16(b[20:22], op)
+		copy(b[22:28], i.HardwareAddr)
+		copy(b[28:32], v4)
+		if op == 2 {
+			copy(b[32:38], broadcast)
+		}
+		copy(b[38:42], v4)
+		if err := syscall.Sendto(fd, b, 0, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendUnsolicitedNA sends an unsolicited neighbor advertisement for IPv6
+// address ip to all nodes from interface i. The kernel computes the ICMPv6
+// checksum.
+func sendUnsolicitedNA(i *net.Interface, ip net.IP) error {
+	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_RAW, syscall.IPPROTO_ICMPV6)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	// Neighbor discovery messages must have a hop limit of 255.
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, 255); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, i.Index); err != nil {
+		return err
+	}
+
+	b := make([]byte, 32)
+	b[0] = 136  // Neighbor Advertisement
+	b[4] = 0x20 // Override flag
+	copy(b[8:24], ip.To16())
+	b[24], b[25] = 2, 1 // Target link-layer address option
+	copy(b[26:32], i.HardwareAddr)
+
+	addr := &syscall.SockaddrInet6{ZoneId: uint32(i.Index)}
+	copy(addr.Addr[:], net.IPv6linklocalallnodes)
+	return syscall.Sendto(fd, b, 0, addr)
+}
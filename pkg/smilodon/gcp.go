@@ -0,0 +1,378 @@
+package smilodon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcpMetadataURL = "http://metadata.google.internal/computeMetadata/v1/"
+	gcpComputeURL  = "https://compute.googleapis.com/compute/v1/"
+	// gcpNodeIDLabel is the label holding the node ID. GCP labels have to be
+	// lower case.
+	gcpNodeIDLabel = "nodeid"
+)
+
+// GCPProvider is a Provider backed by persistent disks and reserved internal
+// addresses, which are attached to the instance as alias IPs.
+type GCPProvider struct {
+	instance Instance
+	project  string
+	filter   string
+	client   *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewGCPProvider returns a GCPProvider for the instance it runs on. Filters
+// is a comma-delimited list of label or field filters, for example
+// 'labels.env=dev,labels.service=etcd'.
+func NewGCPProvider(filters string) (*GCPProvider, error) {
+	p := &GCPProvider{client: &http.Client{Timeout: 30 * time.Second}}
+	if err := p.getMetadata(context.Background()); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMetadata, err)
+	}
+	p.filter = buildGCPFilter(filters)
+	return p, nil
+}
+
+// metadata returns the value of metadata key k.
+func (p *GCPProvider) metadata(ctx context.Context, k string) (string, error) {
+	req, err := http.NewRequest("GET", gcpMetadataURL+k, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata %q: %s", k, resp.Status)
+	}
+	return string(b), nil
+}
+
+func (p *GCPProvider) getMetadata(ctx context.Context) error {
+	name, err := p.metadata(ctx, "instance/name")
+	if err != nil {
+		log.Printf("Failed to get instance name from the metadata service: %q.\n", err)
+		return err
+	}
+	p.instance.ID = name
+
+	project, err := p.metadata(ctx, "project/project-id")
+	if err != nil {
+		log.Printf("Failed to get project ID from the metadata service: %q.\n", err)
+		return err
+	}
+	p.project = project
+
+	// Zone is returned as projects/<number>/zones/<zone>.
+	zone, err := p.metadata(ctx, "instance/zone")
+	if err != nil {
+		log.Printf("Failed to get instance zone from the metadata service: %q.\n", err)
+		return err
+	}
+	p.instance.AZ = path.Base(zone)
+	p.instance.Region = p.instance.AZ[:strings.LastIndex(p.instance.AZ, "-")]
+
+	network, err := p.metadata(ctx, "instance/network-interfaces/0/network")
+	if err != nil {
+		log.Printf("Failed to get instance network from the metadata service: %q.\n", err)
+		return err
+	}
+	p.instance.VPC = path.Base(network)
+	return nil
+}
+
+// accessToken returns an OAuth2 access token of the instance service account.
+func (p *GCPProvider) accessToken(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token != "" && time.Now().Before(p.tokenExpiry) {
+		return p.token, nil
+	}
+	s, err := p.metadata(ctx, "instance/service-accounts/default/token")
+	if err != nil {
+		return "", err
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal([]byte(s), &t); err != nil {
+		return "", err
+	}
+	p.token = t.AccessToken
+	p.tokenExpiry = time.Now().Add(time.Duration(t.ExpiresIn)*time.Second - time.Minute)
+	return p.token, nil
+}
+
+// do calls the compute API method m on resource r relative to the project.
+// Body in is encoded as JSON and the response decoded into out, if not nil.
+func (p *GCPProvider) do(ctx context.Context, m, r string, in, out interface{}) error {
+	token, err := p.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	u := r
+	if !strings.HasPrefix(r, "https://") {
+		u = gcpComputeURL + "projects/" + p.project + "/" + r
+	}
+	return doJSON(ctx, p.client, m, u, bearer(token), in, out)
+}
+
+// gcpOperation is a long running compute API operation.
+type gcpOperation struct {
+	SelfLink string `json:"selfLink"`
+	Status   string `json:"status"`
+	Error    *struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"error"`
+}
+
+// wait blocks until operation o is done and returns its error, if any.
+func (p *GCPProvider) wait(ctx context.Context, o gcpOperation) error {
+	for o.Status != "DONE" {
+		if err := p.do(ctx, "POST", o.SelfLink+"/wait", nil, &o); err != nil {
+			return err
+		}
+	}
+	if o.Error != nil && len(o.Error.Errors) > 0 {
+		e := o.Error.Errors[0]
+		return fmt.Errorf("%s: %s", e.Code, e.Message)
+	}
+	return nil
+}
+
+// buildGCPFilter builds a compute API filter expression matching resources
+// with a node ID label and comma-delimited filters f.
+func buildGCPFilter(f string) string {
+	exprs := []string{fmt.Sprintf("(labels.%s:*)", gcpNodeIDLabel)}
+	if f != "" {
+		for _, kv := range strings.Split(f, ",") {
+			parts := strings.Split(kv, "=")
+			if len(parts) != 2 {
+				continue
+			}
+			exprs = append(exprs, fmt.Sprintf("(%s = %q)", parts[0], parts[1]))
+		}
+	}
+	return strings.Join(exprs, " AND ")
+}
+
+// Metadata returns the instance smilodon runs on.
+func (p *GCPProvider) Metadata(ctx context.Context) (Instance, error) {
+	return p.instance, nil
+}
+
+// DiscoverVolumes returns persistent disks in the instance zone matching the
+// filters.
+func (p *GCPProvider) DiscoverVolumes(ctx context.Context) ([]Volume, error) {
+	var r struct {
+		Items []struct {
+			Name   string            `json:"name"`
+			Labels map[string]string `json:"labels"`
+			Users  []string          `json:"users"`
+		} `json:"items"`
+	}
+	var vs []Volume
+	err := p.do(ctx, "GET", "zones/"+p.instance.AZ+"/disks?filter="+url.QueryEscape(p.filter), nil, &r)
+	if err != nil {
+		log.Printf("Failed to find volumes: %q.\n", err)
+		return vs, err
+	}
+	for _, d := range r.Items {
+		v := Volume{
+			ID:        d.Name,
+			NodeID:    d.Labels[gcpNodeIDLabel],
+			Available: len(d.Users) == 0,
+		}
+		for _, u := range d.Users {
+			v.AttachedTo = path.Base(u)
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+// DiscoverInterfaces returns reserved internal addresses in the instance
+// region matching the filters.
+func (p *GCPProvider) DiscoverInterfaces(ctx context.Context) ([]NetworkInterface, error) {
+	var r struct {
+		Items []struct {
+			Name        string            `json:"name"`
+			Address     string            `json:"address"`
+			AddressType string            `json:"addressType"`
+			Status      string            `json:"status"`
+			Labels      map[string]string `json:"labels"`
+			Users       []string          `json:"users"`
+		} `json:"items"`
+	}
+	var ns []NetworkInterface
+	err := p.do(ctx, "GET", "regions/"+p.instance.Region+"/addresses?filter="+url.QueryEscape(p.filter), nil, &r)
+	if err != nil {
+		log.Printf("Failed to find network interfaces: %q.\n", err)
+		return ns, err
+	}
+	// Alias IPs do not always show up in address users, so check the instance
+	// network interface too.
+	nic, err := p.networkInterface(ctx)
+	if err != nil {
+		log.Printf("Failed to get instance network interface: %q.\n", err)
+		return ns, err
+	}
+	attached := map[string]bool{}
+	for _, a := range nic.AliasIPRange {
+		attached[strings.TrimSuffix(a.IPCidrRange, "/32")] = true
+	}
+	for _, a := range r.Items {
+		if a.AddressType != "INTERNAL" {
+			continue
+		}
+		n := NetworkInterface{
+			ID:        a.Name,
+			NodeID:    a.Labels[gcpNodeIDLabel],
+			IPAddress: a.Address,
+			Available: a.Status == "RESERVED",
+		}
+		for _, u := range a.Users {
+			n.AttachedTo = path.Base(u)
+		}
+		if attached[a.Address] {
+			n.Available = false
+			n.AttachedTo = p.instance.ID
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+// gcpDeviceName returns the GCP device name of linux block device d.
+// Persistent disks show up as /dev/disk/by-id/google-<device name>.
+func gcpDeviceName(d string) string {
+	return strings.TrimPrefix(path.Base(d), "google-")
+}
+
+// AttachVolume attaches persistent disk v to the instance with the device
+// name derived from block device d.
+func (p *GCPProvider) AttachVolume(ctx context.Context, v Volume, d string) error {
+	body := map[string]interface{}{
+		"source":     "zones/" + p.instance.AZ + "/disks/" + v.ID,
+		"deviceName": gcpDeviceName(d),
+		"autoDelete": false,
+	}
+	var o gcpOperation
+	if err := p.do(ctx, "POST", "zones/"+p.instance.AZ+"/instances/"+p.instance.ID+"/attachDisk", body, &o); err != nil {
+		return err
+	}
+	return p.wait(ctx, o)
+}
+
+// DetachVolume detaches persistent disk v from the instance.
+func (p *GCPProvider) DetachVolume(ctx context.Context, v Volume) error {
+	var inst struct {
+		Disks []struct {
+			Source     string `json:"source"`
+			DeviceName string `json:"deviceName"`
+		} `json:"disks"`
+	}
+	r := "zones/" + p.instance.AZ + "/instances/" + p.instance.ID
+	if err := p.do(ctx, "GET", r, nil, &inst); err != nil {
+		return err
+	}
+	for _, d := range inst.Disks {
+		if path.Base(d.Source) != v.ID {
+			continue
+		}
+		var o gcpOperation
+		if err := p.do(ctx, "POST", r+"/detachDisk?deviceName="+url.QueryEscape(d.DeviceName), nil, &o); err != nil {
+			return err
+		}
+		return p.wait(ctx, o)
+	}
+	return fmt.Errorf("disk %q is not attached to instance %q", v.ID, p.instance.ID)
+}
+
+// gcpNetworkInterface is the alias IP part of an instance network interface.
+type gcpNetworkInterface struct {
+	Fingerprint  string            `json:"fingerprint"`
+	AliasIPRange []gcpAliasIPRange `json:"aliasIpRanges"`
+}
+
+type gcpAliasIPRange struct {
+	IPCidrRange         string `json:"ipCidrRange"`
+	SubnetworkRangeName string `json:"subnetworkRangeName,omitempty"`
+}
+
+// networkInterface returns the first instance network interface.
+func (p *GCPProvider) networkInterface(ctx context.Context) (gcpNetworkInterface, error) {
+	var inst struct {
+		NetworkInterfaces []gcpNetworkInterface `json:"networkInterfaces"`
+	}
+	r := "zones/" + p.instance.AZ + "/instances/" + p.instance.ID
+	if err := p.do(ctx, "GET", r, nil, &inst); err != nil {
+		return gcpNetworkInterface{}, err
+	}
+	if len(inst.NetworkInterfaces) == 0 {
+		return gcpNetworkInterface{}, fmt.Errorf("instance %q has no network interfaces", p.instance.ID)
+	}
+	return inst.NetworkInterfaces[0], nil
+}
+
+// updateAliasIPs adds or removes IP ip to or from the alias IP ranges of the
+// first instance network interface.
+func (p *GCPProvider) updateAliasIPs(ctx context.Context, ip string, add bool) error {
+	nic, err := p.networkInterface(ctx)
+	if err != nil {
+		return err
+	}
+	cidr := ip + "/32"
+	ranges := nic.AliasIPRange[:0]
+	for _, a := range nic.AliasIPRange {
+		if a.IPCidrRange != cidr {
+			ranges = append(ranges, a)
+		}
+	}
+	if add {
+		ranges = append(ranges, gcpAliasIPRange{IPCidrRange: cidr})
+	}
+	nic.AliasIPRange = ranges
+	var o gcpOperation
+	r := "zones/" + p.instance.AZ + "/instances/" + p.instance.ID + "/updateNetworkInterface?networkInterface=nic0"
+	if err := p.do(ctx, "PATCH", r, nic, &o); err != nil {
+		return err
+	}
+	return p.wait(ctx, o)
+}
+
+// AttachInterface adds the address of n to the instance as an alias IP.
+func (p *GCPProvider) AttachInterface(ctx context.Context, n NetworkInterface) error {
+	return p.updateAliasIPs(ctx, n.IPAddress, true)
+}
+
+// DetachInterface removes the address of n from the instance alias IPs.
+func (p *GCPProvider) DetachInterface(ctx context.Context, n NetworkInterface) error {
+	return p.updateAliasIPs(ctx, n.IPAddress, false)
+}
@@ -0,0 +1,16 @@
+package smilodon
+
+import "testing"
+
+func TestBuildGCPFilter(t *testing.T) {
+	for _, tc := range []struct{ f, want string }{
+		{"", `(labels.nodeid:*)`},
+		{"labels.env=dev", `(labels.nodeid:*) AND (labels.env = "dev")`},
+		{"labels.env=dev,labels.service=etcd", `(labels.nodeid:*) AND (labels.env = "dev") AND (labels.service = "etcd")`},
+		{"labels.env=dev,invalid,a=b=c", `(labels.nodeid:*) AND (labels.env = "dev")`},
+	} {
+		if got := buildGCPFilter(tc.f); got != tc.want {
+			t.Errorf("buildGCPFilter(%q) = %s, want %s", tc.f, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package smilodon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// healthProbeTimeout bounds a single run of the health probe.
+const healthProbeTimeout = 10 * time.Second
+
+// probeHealth runs the health probe: a GET of an http(s) URL, which succeeds
+// with a 2xx or 3xx status, or a shell command, which succeeds with exit
+// status 0 and gets the node environment.
+func (r *Reconciler) probeHealth(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+	p := r.cfg.HealthProbe
+	if strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://") {
+		req, err := http.NewRequest("GET", p, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("%s returned %s", p, resp.Status)
+		}
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", p)
+	cmd.Env = append(os.Environ(), r.hookEnv()...)
+	if o, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(o)))
+	}
+	return nil
+}
+
+// checkHealth probes the health of the local service of a complete node. With
+// HealthRelease, the node is released once the probe failed HealthThreshold
+// times in a row, and no node is claimed for HealthHoldOff, so that a
+// standby instance takes over.
+func (r *Reconciler) checkHealth(ctx context.Context) {
+	if r.cfg.HealthProbe == "" || !r.Stable() {
+		r.healthFailures = 0
+		return
+	}
+	if err := r.probeHealth(ctx); err != nil {
+		r.healthFailures++
+		log.Printf("Health probe failed (%d/%d): %q.\n", r.healthFailures, r.cfg.HealthThreshold, err)
+	} else {
+		r.healthFailures = 0
+		return
+	}
+	if !r.cfg.HealthRelease || r.healthFailures < r.cfg.HealthThreshold {
+		return
+	}
+	log.Printf("Releasing node %q after %d failed health probes.\n", r.node.ID, r.healthFailures)
+	r.publishEvent(ctx, eventNodeReleased, fmt.Sprintf("released after %d failed health probes", r.healthFailures))
+	if err := r.Detach(ctx); err != nil {
+		log.Printf("Failed to release node: %q.\n", err)
+		return
+	}
+	r.healthFailures = 0
+	r.holdOffUntil = time.Now().Add(r.cfg.HealthHoldOff)
+}
+
+// heldOff checks whether the instance must not claim a node after releasing
+// one.
+func (r *Reconciler) heldOff() bool {
+	if time.Now().Before(r.holdOffUntil) {
+		log.Printf("Not claiming a node until %s after releasing one.\n", r.holdOffUntil.Format(time.RFC3339))
+		return true
+	}
+	return false
+}
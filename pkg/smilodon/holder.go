@@ -0,0 +1,50 @@
+package smilodon
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// volumeHolderFinder is implemented by providers which can look up the
+// instance a volume is attached to.
+type volumeHolderFinder interface {
+	// VolumeHolder returns the instance volume v is attached to and the
+	// state of the attachment, or empty strings if it is not attached.
+	VolumeHolder(ctx context.Context, v Volume) (string, string, error)
+}
+
+// resolveVolumeInUse looks up the actual holder of volume v after attaching
+// it as block device d failed with err as it is in use. If the volume turns
+// out to be attached to the instance already, for example by an earlier
+// attempt which timed out, the node adopts it and nil is returned. If it is
+// attached to another instance, errVolumeHeld is returned,
+// errVolumeDetaching if it is about to be free.
+func (r *Reconciler) resolveVolumeInUse(ctx context.Context, v Volume, d string, err error) error {
+	p, ok := r.provider.(volumeHolderFinder)
+	if !ok {
+		return err
+	}
+	holder, state, herr := p.VolumeHolder(ctx, v)
+	if herr != nil {
+		log.Printf("Failed to look up the holder of volume %q: %q.\n", v.ID, herr)
+		return err
+	}
+	switch {
+	case holder == "":
+		log.Printf("Volume %q is not attached to any instance, retrying on the next pass.\n", v.ID)
+		return errVolumeDetaching
+	case holder == r.instance.ID:
+		log.Printf("Volume %q is attached to this instance already (%s), adopting it.\n", v.ID, state)
+		v.Device = d
+		v.AttachedTo = holder
+		r.node.Volume = &v
+		return nil
+	case state == "detaching":
+		log.Printf("Volume %q is being detached from instance %q.\n", v.ID, holder)
+		return errVolumeDetaching
+	}
+	log.Printf("Volume %q of node %q is held by instance %q (%s).\n", v.ID, v.NodeID, holder, state)
+	r.publishEvent(ctx, eventAttachFailed, fmt.Sprintf("volume %s is held by instance %s", v.ID, holder))
+	return fmt.Errorf("%w: %s", errVolumeHeld, holder)
+}
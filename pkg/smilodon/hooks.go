@@ -0,0 +1,41 @@
+package smilodon
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// runHook runs a hook command c of a given name with the node environment.
+// It does nothing if c is empty.
+func (r *Reconciler) runHook(ctx context.Context, name, c string) error {
+	if c == "" {
+		return nil
+	}
+	ctx, s := startSpan(ctx, "hook "+name)
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", c)
+	cmd.Env = append(os.Environ(), r.hookEnv()...)
+	log.Printf("Running %s hook: %q.\n", name, c)
+	o, err := cmd.CombinedOutput()
+	s.end(err)
+	if err != nil {
+		log.Printf("The %s hook failed: %q: %q.\n", name, err, string(o))
+		return err
+	}
+	log.Printf("The %s hook finished successfully.\n", name)
+	return nil
+}
+
+// hookEnv returns environment variables describing the node.
+func (r *Reconciler) hookEnv() []string {
+	env := []string{
+		"NODE_ID=" + r.node.ID,
+		"DEVICE=" + r.fsDevice(),
+		"MOUNT_POINT=" + r.mountPoint(),
+	}
+	if r.node.NetworkInterface != nil {
+		env = append(env, "ENI_IP="+r.node.NetworkInterface.IPAddress)
+	}
+	return env
+}
@@ -0,0 +1,68 @@
+package smilodon
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// host is the host whose devices, mounts and files a reconciler manages.
+// root is the directory its root file system is mounted at when smilodon
+// runs in a container, from Config.HostRoot, and empty otherwise.
+type host struct {
+	root string
+}
+
+// nsenter is the command entering the mount namespace of the host.
+const nsenter = "/usr/bin/nsenter"
+
+// path returns the path host path p is accessible at.
+func (h host) path(p string) string {
+	if h.root == "" {
+		return p
+	}
+	return filepath.Join(h.root, p)
+}
+
+// evalSymlinks resolves the symbolic links of host path p and returns the
+// resulting host path.
+func (h host) evalSymlinks(p string) (string, error) {
+	r, err := filepath.EvalSymlinks(h.path(p))
+	if err != nil || h.root == "" {
+		return r, err
+	}
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(r, filepath.Clean(h.root)), "/"), nil
+}
+
+// mountsFile returns the mount table of the host, which is that of process
+// 1 of the host when running in a container sharing its PID namespace.
+func (h host) mountsFile() string {
+	if h.root == "" {
+		return "/proc/mounts"
+	}
+	return "/proc/1/mounts"
+}
+
+// mountInfoFile returns the mount information of the host, with the
+// propagation of each mount, like mountsFile.
+func (h host) mountInfoFile() string {
+	if h.root == "" {
+		return "/proc/self/mountinfo"
+	}
+	return "/proc/1/mountinfo"
+}
+
+// command returns a command running name with args in the mount namespace
+// of the host, so that devices and mounts are those of the host.
+func (h host) command(name string, args ...string) *exec.Cmd {
+	return h.commandContext(context.Background(), name, args...)
+}
+
+// commandContext is command killed when ctx is done.
+func (h host) commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if h.root == "" {
+		return exec.CommandContext(ctx, name, args...)
+	}
+	return exec.CommandContext(ctx, nsenter, append([]string{"--target", "1", "--mount", "--", name}, args...)...)
+}
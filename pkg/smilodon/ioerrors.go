@@ -0,0 +1,142 @@
+package smilodon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ioErrorRe matches kernel log messages about I/O errors of a block device,
+// for example 'blk_update_request: I/O error, dev nvme1n1, sector 2048' or
+// 'Buffer I/O error on dev nvme1n1p1, logical block 0'.
+var ioErrorRe = regexp.MustCompile(`I/O error,? (?:on )?dev ([a-z0-9]+)`)
+
+// ioWatchdog counts kernel I/O errors by device name, read from /dev/kmsg.
+type ioWatchdog struct {
+	mu     sync.Mutex
+	host   host
+	errors map[string]int
+}
+
+// newIOWatchdog returns an ioWatchdog following the kernel log of host h from
+// now on, or nil if disabled.
+func newIOWatchdog(h host, enabled bool) (*ioWatchdog, error) {
+	if !enabled {
+		return nil, nil
+	}
+	f, err := os.Open(h.path("/dev/kmsg"))
+	if err != nil {
+		return nil, err
+	}
+	// Skip messages logged before smilodon started.
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	w := &ioWatchdog{host: h, errors: map[string]int{}}
+	go w.follow(f)
+	return w, nil
+}
+
+// follow counts I/O errors in kernel log records read from f.
+func (w *ioWatchdog) follow(f *os.File) {
+	defer f.Close()
+	// Every read returns a single record.
+	buf := make([]byte, 8192)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			// Records overwritten before they were read are skipped.
+			if e, ok := err.(*os.PathError); ok && e.Err == syscall.EPIPE {
+				continue
+			}
+			log.Printf("Stopped watching the kernel log for I/O errors: %q.\n", err)
+			return
+		}
+		rec := string(buf[:n])
+		if i := strings.Index(rec, ";"); i >= 0 {
+			rec = rec[i+1:]
+		}
+		if m := ioErrorRe.FindStringSubmatch(rec); m != nil {
+			w.mu.Lock()
+			w.errors[m[1]]++
+			w.mu.Unlock()
+		}
+	}
+}
+
+// take returns and resets the number of I/O errors of block device d and its
+// partitions.
+func (w *ioWatchdog) take(d string) int {
+	dev, err := w.host.evalSymlinks(d)
+	if err != nil {
+		return 0
+	}
+	name := filepath.Base(dev)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := 0
+	// Partition names are the device name followed by a number, with a p
+	// in between for device names ending with a digit.
+	part := strings.TrimSuffix(partitionDevice(name, 1), "1")
+	for k, c := range w.errors {
+		if k == name || strings.HasPrefix(k, part) && strings.Trim(k[len(part):], "0123456789") == "" {
+			n += c
+			delete(w.errors, k)
+		}
+	}
+	return n
+}
+
+// checkIOErrors checks the block device of the node for kernel I/O errors
+// since the last pass. Once there were errors in IOErrorPasses passes in a
+// row, the volume is reported as degraded and, with IOErrorReattach, its file
+// system is unmounted and the volume detached, to be attached again on the
+// next pass.
+func (r *Reconciler) checkIOErrors(ctx context.Context) {
+	if r.ioWatchdog == nil || r.node.Volume == nil {
+		return
+	}
+	n := r.ioWatchdog.take(r.blockDevice())
+	r.metrics.ioErrored(n)
+	if n == 0 {
+		r.ioErrorPasses = 0
+		return
+	}
+	r.ioErrorPasses++
+	log.Printf("Block device %q logged %d I/O errors (%d/%d passes).\n", r.blockDevice(), n, r.ioErrorPasses, r.cfg.IOErrorPasses)
+	if r.ioErrorPasses < r.cfg.IOErrorPasses {
+		return
+	}
+	v := *r.node.Volume
+	log.Printf("Volume %q is degraded.\n", v.ID)
+	r.publishEvent(ctx, eventVolumeDegraded, fmt.Sprintf("%d I/O errors in %d passes", n, r.ioErrorPasses))
+	r.ioErrorPasses = 0
+	if !r.cfg.IOErrorReattach {
+		return
+	}
+	r.reattachVolume(ctx, v)
+}
+
+// reattachVolume unmounts the file system of degraded volume v and detaches
+// it, to be attached again, or its replacement, on the next pass.
+func (r *Reconciler) reattachVolume(ctx context.Context, v Volume) {
+	log.Printf("Detaching volume %q to attach it again.\n", v.ID)
+	r.runHook(ctx, "pre-detach", r.cfg.PreDetachHook)
+	if err := r.unmountBinds(true); err != nil {
+		return
+	}
+	if mp := r.mountPoint(); r.host.isMountPoint(mp) {
+		if err := r.host.unmountLazy(mp); err != nil {
+			return
+		}
+	}
+	r.detachVolume(ctx)
+}
@@ -0,0 +1,166 @@
+package smilodon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// journalSocket is the socket of the native journal protocol.
+const journalSocket = "/run/systemd/journal/socket"
+
+// Syslog priorities of journal entries.
+const (
+	journalPriorityErr     = 3
+	journalPriorityWarning = 4
+	journalPriorityInfo    = 6
+)
+
+var (
+	journalVolumeID    = regexp.MustCompile(`\bvol-[0-9a-f]+\b`)
+	journalInterfaceID = regexp.MustCompile(`\beni-[0-9a-f]+\b`)
+)
+
+// JournalConnected checks whether stderr is connected to the systemd journal,
+// as is the case for services started by systemd with the default output.
+func JournalConnected() bool {
+	s := os.Getenv("JOURNAL_STREAM")
+	if s == "" {
+		return false
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(os.Stderr.Fd()), &st); err != nil {
+		return false
+	}
+	return s == fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+}
+
+// JournalWriter is a log output writing every line as a journal entry with
+// structured fields: PRIORITY, derived from the message, and NODE_ID,
+// VOLUME_ID and ENI_ID of the node the message is about. Entries that cannot
+// be sent are written to stderr.
+type JournalWriter struct {
+	conn       *net.UnixConn
+	addr       *net.UnixAddr
+	identifier string
+}
+
+// NewJournalWriter returns a JournalWriter logging entries as identifier.
+func NewJournalWriter(identifier string) (*JournalWriter, error) {
+	if _, err := os.Stat(journalSocket); err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	w := &JournalWriter{
+		conn:       conn,
+		addr:       &net.UnixAddr{Name: journalSocket, Net: "unixgram"},
+		identifier: identifier,
+	}
+	return w, nil
+}
+
+// Write sends log line p as a journal entry.
+func (w *JournalWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", msg)
+	journalField(&b, "PRIORITY", strconv.Itoa(journalPriority(msg)))
+	journalField(&b, "SYSLOG_IDENTIFIER", w.identifier)
+	for _, f := range loggedNodes.fields(msg) {
+		journalField(&b, f[0], f[1])
+	}
+	if _, _, err := w.conn.WriteMsgUnix(b.Bytes(), nil, w.addr); err != nil {
+		return os.Stderr.Write(p)
+	}
+	return len(p), nil
+}
+
+// journalField appends field k with value v to entry b in the native journal
+// protocol. Values with newlines are length-prefixed.
+func journalField(b *bytes.Buffer, k, v string) {
+	if !strings.Contains(v, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", k, v)
+		return
+	}
+	b.WriteString(k + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(v)))
+	b.WriteString(v + "\n")
+}
+
+// journalPriority returns the syslog priority of log message msg.
+func journalPriority(msg string) int {
+	l := strings.ToLower(msg)
+	switch {
+	case strings.HasPrefix(msg, "WARNING"):
+		return journalPriorityWarning
+	case strings.HasPrefix(l, "failed") || strings.HasPrefix(l, "unable") || strings.Contains(l, " failed"):
+		return journalPriorityErr
+	}
+	return journalPriorityInfo
+}
+
+// nodeLog tracks the nodes held by the reconcilers of the process, so that
+// log entries can be attributed to them.
+type nodeLog struct {
+	mu    sync.Mutex
+	nodes map[*Reconciler]Node
+}
+
+var loggedNodes = &nodeLog{nodes: map[*Reconciler]Node{}}
+
+// set records node n as held by reconciler r.
+func (l *nodeLog) set(r *Reconciler, n Node) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nodes[r] = n
+}
+
+// fields returns the NODE_ID, VOLUME_ID and ENI_ID fields of log message msg.
+// They are those of the node whose volume or network interface msg mentions,
+// or of the only node held if msg mentions neither, and otherwise the IDs
+// mentioned in msg.
+func (l *nodeLog) fields(msg string) [][2]string {
+	vol := journalVolumeID.FindString(msg)
+	eni := journalInterfaceID.FindString(msg)
+	l.mu.Lock()
+	var node *Node
+	for _, n := range l.nodes {
+		n := n
+		mentioned := (vol != "" && n.Volume != nil && n.Volume.ID == vol) ||
+			(eni != "" && n.NetworkInterface != nil && n.NetworkInterface.ID == eni)
+		if mentioned || (vol == "" && eni == "" && len(l.nodes) == 1) {
+			node = &n
+			break
+		}
+	}
+	l.mu.Unlock()
+	var fs [][2]string
+	if node != nil {
+		if node.ID != "" {
+			fs = append(fs, [2]string{"NODE_ID", node.ID})
+		}
+		if vol == "" && node.Volume != nil {
+			vol = node.Volume.ID
+		}
+		if eni == "" && node.NetworkInterface != nil {
+			eni = node.NetworkInterface.ID
+		}
+	}
+	if vol != "" {
+		fs = append(fs, [2]string{"VOLUME_ID", vol})
+	}
+	if eni != "" {
+		fs = append(fs, [2]string{"ENI_ID", eni})
+	}
+	return fs
+}
@@ -0,0 +1,129 @@
+package smilodon
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Kubernetes node label and annotations carrying the identity of the node
+// held by the instance.
+const (
+	kubeNodeIDLabel         = "smilodon/node-id"
+	kubeNodeIDAnnotation    = "smilodon/node-id"
+	kubeIPAddressAnnotation = "smilodon/ip-address"
+)
+
+// Default service account credential paths of pods.
+const (
+	kubeServiceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	kubeServiceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// kubeClient labels and annotates a Kubernetes Node object.
+type kubeClient struct {
+	node      string
+	server    string
+	tokenFile string
+	client    *http.Client
+}
+
+// newKubeClient returns a kubeClient for Node node, or nil if node is empty.
+// The API server defaults to the in-cluster service address.
+func newKubeClient(node, server, tokenFile, caFile string) (*kubeClient, error) {
+	if node == "" {
+		return nil, nil
+	}
+	if server == "" {
+		h, p := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if h == "" || p == "" {
+			return nil, fmt.Errorf("no Kubernetes API server given and not running in a cluster")
+		}
+		server = "https://" + net.JoinHostPort(h, p)
+	}
+	tr := &http.Transport{}
+	if caFile != "" {
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %q", caFile)
+		}
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &kubeClient{
+		node:      node,
+		server:    strings.TrimSuffix(server, "/"),
+		tokenFile: tokenFile,
+		client:    &http.Client{Transport: tr, Timeout: 30 * time.Second},
+	}, nil
+}
+
+// patchNode merge-patches the labels and annotations of the Node. Nil values
+// remove the keys.
+func (k *kubeClient) patchNode(ctx context.Context, labels, annotations map[string]interface{}) error {
+	// Projected service account tokens are rotated, so the token is read
+	// on every request.
+	t, err := ioutil.ReadFile(k.tokenFile)
+	if err != nil {
+		return err
+	}
+	h := bearer(strings.TrimSpace(string(t)))
+	h.Set("Content-Type", "application/merge-patch+json")
+	body := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      labels,
+			"annotations": annotations,
+		},
+	}
+	return doJSON(ctx, k.client, "PATCH", k.server+"/api/v1/nodes/"+k.node, h, body, nil)
+}
+
+// labelKubeNode labels and annotates the Kubernetes Node the instance runs as
+// with the identity of the node it holds, if enabled. Failures are logged and
+// otherwise ignored.
+func (r *Reconciler) labelKubeNode(ctx context.Context) {
+	if r.kube == nil {
+		return
+	}
+	var ip interface{}
+	if r.node.NetworkInterface != nil {
+		ip = r.node.NetworkInterface.IPAddress
+	}
+	labels := map[string]interface{}{kubeNodeIDLabel: r.node.ID}
+	annotations := map[string]interface{}{
+		kubeNodeIDAnnotation:    r.node.ID,
+		kubeIPAddressAnnotation: ip,
+	}
+	log.Printf("Labeling Kubernetes node %q with node ID %q.\n", r.kube.node, r.node.ID)
+	if err := r.kube.patchNode(ctx, labels, annotations); err != nil {
+		log.Printf("Failed to label Kubernetes node %q: %q.\n", r.kube.node, err)
+	}
+}
+
+// unlabelKubeNode removes the identity label and annotations from the
+// Kubernetes Node, if enabled.
+func (r *Reconciler) unlabelKubeNode(ctx context.Context) {
+	if r.kube == nil {
+		return
+	}
+	labels := map[string]interface{}{kubeNodeIDLabel: nil}
+	annotations := map[string]interface{}{
+		kubeNodeIDAnnotation:    nil,
+		kubeIPAddressAnnotation: nil,
+	}
+	log.Printf("Removing node ID label from Kubernetes node %q.\n", r.kube.node)
+	if err := r.kube.patchNode(ctx, labels, annotations); err != nil {
+		log.Printf("Failed to unlabel Kubernetes node %q: %q.\n", r.kube.node, err)
+	}
+}
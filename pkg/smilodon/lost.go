@@ -0,0 +1,28 @@
+package smilodon
+
+import (
+	"context"
+	"log"
+)
+
+// volumeLost cleans up after volume v held by the node was detached
+// externally, for example force-detached: the dead file system is unmounted
+// lazily so that nothing hangs on it, the environment file is removed and the
+// volume-lost hook is run so that dependent services can be stopped. The node
+// is incomplete afterwards and is completed again on a later pass.
+func (r *Reconciler) volumeLost(ctx context.Context, v Volume) {
+	log.Printf("Volume %q was detached externally.\n", v.ID)
+	r.unmountBinds(true)
+	if mp := r.mountPoint(); r.host.isMountPoint(mp) {
+		r.host.unmountLazy(mp)
+	}
+	r.runHook(ctx, "volume-lost", r.cfg.VolumeLostHook)
+	r.publishEvent(ctx, eventVolumeLost, "volume was detached externally")
+	if r.node.ID != "" && r.cfg.EnvFile != "" {
+		log.Printf("Removing %q.\n", r.cfg.EnvFile)
+		if err := r.files.remove(r.cfg.EnvFile); err != nil {
+			log.Printf("Failed to remove %q: %q.\n", r.cfg.EnvFile, err)
+		}
+	}
+	r.node.ID = ""
+}
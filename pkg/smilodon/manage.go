@@ -0,0 +1,199 @@
+package smilodon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// Status discovers the volume and network interface attached to the instance
+// and returns the node they make up. The node ID is only set if their node
+// IDs match.
+func (r *Reconciler) Status(ctx context.Context) (Node, error) {
+	if _, _, err := r.discover(ctx); err != nil {
+		return Node{}, err
+	}
+	n := r.node
+	if n.Volume != nil && n.NetworkInterface != nil && n.Volume.NodeID == n.NetworkInterface.NodeID {
+		n.ID = n.Volume.NodeID
+	}
+	return n, nil
+}
+
+// Mounted checks whether the block device is mounted.
+func (r *Reconciler) Mounted() bool {
+	return r.fsMounted()
+}
+
+// Nodes returns all nodes found by the provider, sorted by node ID. The
+// instances holding them are in the AttachedTo fields of their volume and
+// network interface.
+func (r *Reconciler) Nodes(ctx context.Context) ([]Node, error) {
+	volumes, networkInterfaces, err := r.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes := map[string]*Node{}
+	node := func(id string) *Node {
+		if _, ok := nodes[id]; !ok {
+			nodes[id] = &Node{ID: id}
+		}
+		return nodes[id]
+	}
+	for i := range volumes {
+		node(volumes[i].NodeID).Volume = &volumes[i]
+	}
+	for i := range networkInterfaces {
+		node(networkInterfaces[i].NodeID).NetworkInterface = &networkInterfaces[i]
+	}
+	var ns []Node
+	for _, n := range nodes {
+		ns = append(ns, *n)
+	}
+	sort.Slice(ns, func(i, j int) bool { return ns[i].ID < ns[j].ID })
+	return ns, nil
+}
+
+// Attach attaches the volume and network interface of node id to the instance
+// and completes the node. It fails if the instance already holds a different
+// node or the resources of node id are attached elsewhere.
+func (r *Reconciler) Attach(ctx context.Context, id string) error {
+	volumes, networkInterfaces, err := r.discover(ctx)
+	if err != nil {
+		return err
+	}
+	if v := r.node.Volume; v != nil && v.NodeID != id {
+		return fmt.Errorf("instance already holds volume %q of node %q", v.ID, v.NodeID)
+	}
+	if n := r.node.NetworkInterface; n != nil && n.NodeID != id {
+		return fmt.Errorf("instance already holds network interface %q of node %q", n.ID, n.NodeID)
+	}
+	if r.node.Volume == nil {
+		v, err := findVolume(volumes, id)
+		if err != nil {
+			return err
+		}
+		if !r.claimNode(ctx, id) {
+			return fmt.Errorf("node %q is held by another instance in etcd", id)
+		}
+		if err := r.attachVolume(ctx, v); err != nil {
+			r.releaseNode(ctx, id)
+			return err
+		}
+	}
+	if r.node.NetworkInterface == nil {
+		n, err := findNetworkInterface(networkInterfaces, id)
+		if err != nil {
+			return err
+		}
+		if err := r.attachNetworkInterface(ctx, n); err != nil {
+			return err
+		}
+		r.waitAndSetupIface(ctx, n)
+	}
+	r.completeNode(ctx)
+	return r.checkFs()
+}
+
+// checkFs checks that the file system was set up as configured after
+// completing the node.
+func (r *Reconciler) checkFs() error {
+	if !r.cfg.CreateFs && !r.cfg.MountFs {
+		return nil
+	}
+	if _, err := os.Stat(r.host.path(r.blockDevice())); err != nil {
+		return fmt.Errorf("%w: block device %q did not appear within %s", ErrAttachTimeout, r.blockDevice(), r.cfg.AttachTimeout)
+	}
+	if err := r.verifyDevice(); err != nil {
+		return fmt.Errorf("%w: %v", ErrFilesystem, err)
+	}
+	if r.cfg.MountFs && !r.fsMounted() {
+		return fmt.Errorf("%w: %q is not mounted to %q", ErrFilesystem, r.fsDevice(), r.mountPoint())
+	}
+	if r.cfg.CreateFs && !r.fsPresent() {
+		return fmt.Errorf("%w: %q has no %q file system", ErrFilesystem, r.fsDevice(), r.fileSystemType())
+	}
+	return nil
+}
+
+// findVolume returns the available volume of node id in vs.
+func findVolume(vs []Volume, id string) (Volume, error) {
+	for _, v := range vs {
+		if v.NodeID != id {
+			continue
+		}
+		if !v.Available {
+			return v, fmt.Errorf("%w: volume %q of node %q is attached to %q", ErrNoResources, v.ID, id, v.AttachedTo)
+		}
+		return v, nil
+	}
+	return Volume{}, fmt.Errorf("%w: no volume found for node %q", ErrNoResources, id)
+}
+
+// findNetworkInterface returns the available network interface of node id in
+// ns.
+func findNetworkInterface(ns []NetworkInterface, id string) (NetworkInterface, error) {
+	for _, n := range ns {
+		if n.NodeID != id {
+			continue
+		}
+		if !n.Available {
+			return n, fmt.Errorf("%w: network interface %q of node %q is attached to %q", ErrNoResources, n.ID, id, n.AttachedTo)
+		}
+		return n, nil
+	}
+	return NetworkInterface{}, fmt.Errorf("%w: no network interface found for node %q", ErrNoResources, id)
+}
+
+// Detach runs the pre-detach hook, then unmounts the file system and
+// detaches the network interface and the volume held by the instance.
+func (r *Reconciler) Detach(ctx context.Context) error {
+	if _, _, err := r.discover(ctx); err != nil {
+		return err
+	}
+	if r.node.Volume != nil || r.node.NetworkInterface != nil {
+		r.runHook(ctx, "pre-detach", r.cfg.PreDetachHook)
+	}
+	if r.node.Volume != nil && r.fsMounted() {
+		if err := r.unmountBinds(false); err != nil {
+			return fmt.Errorf("%w: %v", ErrFilesystem, err)
+		}
+		if err := r.unmountFs(); err != nil {
+			return fmt.Errorf("%w: %v", ErrFilesystem, err)
+		}
+	}
+	if r.node.NetworkInterface != nil {
+		if err := r.detachNetworkInterface(ctx); err != nil {
+			return err
+		}
+	}
+	if r.node.Volume != nil {
+		if err := r.detachVolume(ctx); err != nil {
+			return err
+		}
+	}
+	if r.node.ID != "" {
+		r.unlabelKubeNode(ctx)
+	}
+	r.node.ID = ""
+	return nil
+}
+
+// Decommission detaches the node held by the instance like Detach and removes
+// the environment file and rendered templates, so that the node is retired
+// without leaving its identity half-attached.
+func (r *Reconciler) Decommission(ctx context.Context) error {
+	if err := r.Detach(ctx); err != nil {
+		return err
+	}
+	for _, f := range r.outputs() {
+		log.Printf("Removing %q.\n", f)
+		if err := r.files.remove(f); err != nil {
+			log.Printf("Failed to remove %q: %q.\n", f, err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package smilodon
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// metricsPublisher pushes reconcile metrics to CloudWatch.
+type metricsPublisher struct {
+	namespace string
+	cwc       *cloudwatch.Client
+
+	// attachStart is when the first resource of the node being acquired was
+	// attached, zero if none.
+	attachStart time.Time
+	// attachLatency is the time it took to acquire the node, reported once.
+	attachLatency time.Duration
+	// attachFailures counts failed attachments since the last push.
+	attachFailures int
+	// ioErrors counts kernel I/O errors of the block device since the last
+	// push.
+	ioErrors int
+}
+
+// newMetricsPublisher returns a metricsPublisher of CloudWatch namespace ns
+// with a client of config cfg, or nil if ns is empty.
+func newMetricsPublisher(ns string, cfg aws.Config) *metricsPublisher {
+	if ns == "" {
+		return nil
+	}
+	return &metricsPublisher{
+		namespace: ns,
+		cwc:       cloudwatch.NewFromConfig(cfg),
+	}
+}
+
+// attached records the attachment of a resource of the node being acquired.
+func (m *metricsPublisher) attached() {
+	if m != nil && m.attachStart.IsZero() {
+		m.attachStart = time.Now()
+	}
+}
+
+// attachFailed records a failed attachment.
+func (m *metricsPublisher) attachFailed() {
+	if m != nil {
+		m.attachFailures++
+	}
+}
+
+// ioErrored records n I/O errors of the block device.
+func (m *metricsPublisher) ioErrored(n int) {
+	if m != nil {
+		m.ioErrors += n
+	}
+}
+
+// acquired records that the node ID was acquired.
+func (m *metricsPublisher) acquired() {
+	if m != nil && !m.attachStart.IsZero() {
+		m.attachLatency = time.Since(m.attachStart)
+		m.attachStart = time.Time{}
+	}
+}
+
+// pushMetrics pushes the metrics of the last reconcile pass to CloudWatch, if
+// enabled. Failures are logged and otherwise ignored.
+func (r *Reconciler) pushMetrics(ctx context.Context) {
+	m := r.metrics
+	if m == nil {
+		return
+	}
+	acquired := 0.0
+	if r.Stable() {
+		acquired = 1
+	}
+	params := &cloudwatch.PutMetricDataInput{Namespace: aws.String(m.namespace)}
+	add := func(name string, value float64, unit types.StandardUnit) {
+		params.MetricData = append(params.MetricData, types.MetricDatum{
+			MetricName: aws.String(name),
+			Value:      aws.Float64(value),
+			Unit:       unit,
+			Dimensions: []types.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(r.instance.ID)}},
+		})
+	}
+	add("IdentityAcquired", acquired, types.StandardUnitNone)
+	add("AttachFailures", float64(m.attachFailures), types.StandardUnitCount)
+	add("VolumeAttachTries", float64(r.volumeAttachTries), types.StandardUnitCount)
+	if r.ioWatchdog != nil {
+		add("VolumeIOErrors", float64(m.ioErrors), types.StandardUnitCount)
+	}
+	if r.volumeStatus != "" {
+		impaired, insufficient := 0.0, 0.0
+		switch r.volumeStatus {
+		case volumeStatusImpaired:
+			impaired = 1
+		case volumeStatusInsufficientData:
+			insufficient = 1
+		}
+		add("VolumeImpaired", impaired, types.StandardUnitNone)
+		add("VolumeStatusInsufficientData", insufficient, types.StandardUnitNone)
+	}
+	if r.requirementsChecked != "" {
+		under := 0.0
+		if r.underProvisioned {
+			under = 1
+		}
+		add("VolumeUnderProvisioned", under, types.StandardUnitNone)
+	}
+	if m.attachLatency > 0 {
+		add("AttachLatency", m.attachLatency.Seconds()*1000, types.StandardUnitMilliseconds)
+	}
+	if _, err := m.cwc.PutMetricData(ctx, params); err != nil {
+		log.Printf("Failed to push metrics to CloudWatch: %q.\n", err)
+		return
+	}
+	m.attachFailures = 0
+	m.attachLatency = 0
+	m.ioErrors = 0
+}
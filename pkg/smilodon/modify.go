@@ -0,0 +1,63 @@
+package smilodon
+
+import (
+	"context"
+	"log"
+)
+
+// volumeSpec is the desired type and performance of a volume. Zero values
+// are left as they are.
+type volumeSpec struct {
+	Type       string
+	Size       int64
+	IOPS       int64
+	Throughput int64
+}
+
+// volumeModifier is implemented by providers which can modify the type, size
+// and performance of volumes in place.
+type volumeModifier interface {
+	// ModifyVolume modifies volume v to match spec, overridden by the
+	// desired state declared on the volume itself. It returns whether a
+	// modification is still in progress.
+	ModifyVolume(ctx context.Context, v Volume, spec volumeSpec) (bool, error)
+}
+
+// modifyVolume brings the volume held by the node to its desired state and
+// grows the file system once a modification completes, if enabled.
+func (r *Reconciler) modifyVolume(ctx context.Context) {
+	if !r.cfg.ModifyVolume || !r.Stable() {
+		return
+	}
+	p, ok := r.provider.(volumeModifier)
+	if !ok {
+		log.Println("Volume modification is not supported by the provider.")
+		return
+	}
+	spec := volumeSpec{
+		Type:       r.cfg.VolumeType,
+		Size:       r.cfg.VolumeSize,
+		IOPS:       r.cfg.VolumeIOPS,
+		Throughput: r.cfg.VolumeThroughput,
+	}
+	modifying, err := p.ModifyVolume(ctx, *r.node.Volume, spec)
+	if err != nil {
+		log.Printf("Failed to modify volume %q: %q.\n", r.node.Volume.ID, err)
+		return
+	}
+	if modifying {
+		r.growFs = true
+		return
+	}
+	if !r.growFs || !r.cfg.MountFs || !r.fsMounted() {
+		return
+	}
+	if r.cfg.Partition {
+		if err := r.host.growPartition(r.blockDevice(), 1); err != nil {
+			return
+		}
+	}
+	if err := r.host.growFs(r.fsDevice(), r.mountPoint(), r.fileSystemType()); err == nil {
+		r.growFs = false
+	}
+}
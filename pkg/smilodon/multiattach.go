@@ -0,0 +1,41 @@
+package smilodon
+
+import (
+	"fmt"
+	"log"
+)
+
+// Policies for multi-attach volumes attached to other instances.
+const (
+	// multiAttachRefuse leaves them alone, as most file systems are corrupted
+	// when mounted by several instances.
+	multiAttachRefuse = "refuse"
+	// multiAttachShare attaches them alongside the other instances, for
+	// clustered file systems.
+	multiAttachShare = "share"
+)
+
+// parseMultiAttach checks multi-attach policy s.
+func parseMultiAttach(s string) error {
+	switch s {
+	case multiAttachRefuse, multiAttachShare, "":
+		return nil
+	}
+	return fmt.Errorf("unknown multi-attach policy %q", s)
+}
+
+// applyMultiAttach marks multi-attach volumes of vs which are attached to
+// other instances only as available if they may be co-attached.
+func (r *Reconciler) applyMultiAttach(vs []Volume) []Volume {
+	for i, v := range vs {
+		if !v.MultiAttach || v.Available || v.AttachedTo == r.instance.ID {
+			continue
+		}
+		if r.cfg.MultiAttach == multiAttachShare {
+			vs[i].Available = true
+			continue
+		}
+		log.Printf("Not co-attaching multi-attach volume %q attached to %q.\n", v.ID, v.AttachedTo)
+	}
+	return vs
+}
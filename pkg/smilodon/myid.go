@@ -0,0 +1,45 @@
+package smilodon
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"path"
+)
+
+// myIDFile returns the path of the ZooKeeper myid file.
+func (r *Reconciler) myIDFile() string {
+	if r.cfg.ZooKeeperMyIDFile != "" {
+		return r.cfg.ZooKeeperMyIDFile
+	}
+	return path.Join(r.mountPoint(), "myid")
+}
+
+// writeMyID writes the numeric node ID to the ZooKeeper myid file, if enabled
+// and the node ID is set. The file is replaced atomically, so that ZooKeeper
+// never reads a partial ID, and only if its content differs. The default file
+// beneath the mount point is only written once the file system is mounted,
+// so that it does not end up on the root disk, hidden by the mount.
+func (r *Reconciler) writeMyID() {
+	if !r.cfg.ZooKeeperMyID || r.node.ID == "" {
+		return
+	}
+	if r.cfg.ZooKeeperMyIDFile == "" && r.cfg.MountFs && !r.fsMounted() {
+		return
+	}
+	f := r.myIDFile()
+	id, err := parseNodeID(r.node.ID, nodeIDFormatNumeric)
+	if err != nil {
+		log.Printf("Failed to write ZooKeeper myid file %q: %q.\n", f, err)
+		return
+	}
+	data := []byte(id + "\n")
+	if b, err := ioutil.ReadFile(r.host.path(f)); err == nil && bytes.Equal(b, data) {
+		return
+	}
+	if err := r.files.writeFile(f, data, r.files.permsFor(f)); err != nil {
+		log.Printf("Failed to write ZooKeeper myid file %q: %q.\n", f, err)
+		return
+	}
+	log.Printf("Wrote ZooKeeper myid %s to %q.\n", id, f)
+}
@@ -0,0 +1,324 @@
+package smilodon
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Routing rule attributes and actions of linux/fib_rules.h, which package
+// syscall does not define.
+const (
+	fraSrc      = 2
+	fraTable    = 15
+	frActToTbl  = 1
+	sizeofRtMsg = 12
+)
+
+// rtAttr is a routing netlink attribute of type typ.
+type rtAttr struct {
+	typ  uint16
+	data []byte
+}
+
+// nlUint32 returns v in host byte order, as netlink expects it.
+func nlUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, v)
+	return b
+}
+
+// nlAlign rounds n up to the 4 byte alignment of netlink messages and
+// attributes.
+func nlAlign(n int) int {
+	return (n + 3) &^ 3
+}
+
+// ipFamily returns the address family of ip and ip in its 4 or 16 byte form.
+func ipFamily(ip net.IP) (uint8, []byte) {
+	if v4 := ip.To4(); v4 != nil {
+		return syscall.AF_INET, v4
+	}
+	return syscall.AF_INET6, ip.To16()
+}
+
+// nlMessage returns a routing netlink request of type typ with flags, fixed
+// header body and attributes attrs. Requests other than dumps ask the kernel
+// for an acknowledgement.
+func nlMessage(typ, flags uint16, body []byte, attrs ...rtAttr) []byte {
+	b := make([]byte, syscall.NLMSG_HDRLEN, 256)
+	b = append(b, body...)
+	for _, a := range attrs {
+		n := syscall.SizeofRtAttr + len(a.data)
+		h := make([]byte, syscall.SizeofRtAttr)
+		binary.NativeEndian.PutUint16(h[0:2], uint16(n))
+		binary.NativeEndian.PutUint16(h[2:4], a.typ)
+		b = append(append(b, h...), a.data...)
+		b = append(b, make([]byte, nlAlign(n)-n)...)
+	}
+	flags |= syscall.NLM_F_REQUEST
+	// NLM_F_REPLACE shares a bit with NLM_F_ROOT, half of NLM_F_DUMP.
+	if flags&syscall.NLM_F_DUMP != syscall.NLM_F_DUMP {
+		flags |= syscall.NLM_F_ACK
+	}
+	binary.NativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	binary.NativeEndian.PutUint16(b[4:6], typ)
+	binary.NativeEndian.PutUint16(b[6:8], flags)
+	binary.NativeEndian.PutUint32(b[8:12], 1)
+	return b
+}
+
+// netlinkRequest sends a routing netlink request of type typ with flags,
+// fixed header body and attributes attrs, and returns the payloads of the
+// replies. Requests other than dumps are acknowledged by the kernel, and
+// fail with the error it reports.
+func netlinkRequest(typ, flags uint16, body []byte, attrs ...rtAttr) ([][]byte, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+	b := nlMessage(typ, flags, body, attrs...)
+	if err := syscall.Sendto(fd, b, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	var out [][]byte
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return out, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, syscall.EINVAL
+				}
+				// An error code of zero acknowledges the request.
+				if e := int32(binary.NativeEndian.Uint32(m.Data[0:4])); e != 0 {
+					return nil, syscall.Errno(-e)
+				}
+				return out, nil
+			}
+			out = append(out, append([]byte(nil), m.Data...))
+		}
+	}
+}
+
+// parseRtAttrs returns the routing netlink attributes in b by type.
+func parseRtAttrs(b []byte) map[uint16][]byte {
+	attrs := map[uint16][]byte{}
+	for len(b) >= syscall.SizeofRtAttr {
+		n := int(binary.NativeEndian.Uint16(b[0:2]))
+		if n < syscall.SizeofRtAttr || n > len(b) {
+			break
+		}
+		attrs[binary.NativeEndian.Uint16(b[2:4])] = b[syscall.SizeofRtAttr:n]
+		if nlAlign(n) > len(b) {
+			break
+		}
+		b = b[nlAlign(n):]
+	}
+	return attrs
+}
+
+// ifaceIndex returns the index of interface iface.
+func ifaceIndex(iface string) (int, error) {
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		return 0, err
+	}
+	return i.Index, nil
+}
+
+// linkMsg returns an ifinfomsg for the interface with index i, changing the
+// flags in change to flags.
+func linkMsg(i int, flags, change uint32) []byte {
+	b := make([]byte, syscall.SizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(b[4:8], uint32(i))
+	binary.NativeEndian.PutUint32(b[8:12], flags)
+	binary.NativeEndian.PutUint32(b[12:16], change)
+	return b
+}
+
+// linkSetUp brings interface iface up.
+func linkSetUp(iface string) error {
+	i, err := ifaceIndex(iface)
+	if err != nil {
+		return err
+	}
+	if _, err := netlinkRequest(syscall.RTM_NEWLINK, 0, linkMsg(i, syscall.IFF_UP, syscall.IFF_UP)); err != nil {
+		return fmt.Errorf("failed to bring up %q: %v", iface, err)
+	}
+	return nil
+}
+
+// linkSetMTU sets the MTU of interface iface to mtu.
+func linkSetMTU(iface string, mtu int) error {
+	i, err := ifaceIndex(iface)
+	if err != nil {
+		return err
+	}
+	if _, err := netlinkRequest(syscall.RTM_NEWLINK, 0, linkMsg(i, 0, 0), rtAttr{syscall.IFLA_MTU, nlUint32(uint32(mtu))}); err != nil {
+		return fmt.Errorf("failed to set the MTU of %q to %d: %v", iface, mtu, err)
+	}
+	return nil
+}
+
+// addrRequest sends a request of type typ for address addr, in CIDR
+// notation, of interface iface.
+func addrRequest(typ, flags uint16, iface, addr string) error {
+	i, err := ifaceIndex(iface)
+	if err != nil {
+		return err
+	}
+	ip, n, err := net.ParseCIDR(addr)
+	if err != nil {
+		return err
+	}
+	family, a := ipFamily(ip)
+	ones, _ := n.Mask.Size()
+	b := make([]byte, syscall.SizeofIfAddrmsg)
+	b[0], b[1] = family, uint8(ones)
+	binary.NativeEndian.PutUint32(b[4:8], uint32(i))
+	_, err = netlinkRequest(typ, flags, b, rtAttr{syscall.IFA_LOCAL, a}, rtAttr{syscall.IFA_ADDRESS, a})
+	return err
+}
+
+// addrReplace assigns address addr, in CIDR notation, to interface iface,
+// replacing it if it is already assigned.
+func addrReplace(iface, addr string) error {
+	if err := addrRequest(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, iface, addr); err != nil {
+		return fmt.Errorf("failed to assign %q to %q: %v", addr, iface, err)
+	}
+	return nil
+}
+
+// addrDel removes address addr, in CIDR notation, from interface iface.
+func addrDel(iface, addr string) error {
+	if err := addrRequest(syscall.RTM_DELADDR, 0, iface, addr); err != nil {
+		return fmt.Errorf("failed to remove %q from %q: %v", addr, iface, err)
+	}
+	return nil
+}
+
+// routeReplace adds or replaces the route to dst through interface iface in
+// routing table t, with preferred source address src if set. Routes with
+// gateway gw are routed through it, others are directly connected. A nil dst
+// is the default route.
+func routeReplace(t int, dst *net.IPNet, gw, src net.IP, iface string) error {
+	i, err := ifaceIndex(iface)
+	if err != nil {
+		return err
+	}
+	b, attrs := routeMsg(t, dst, gw, src, i)
+	if _, err := netlinkRequest(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, b, attrs...); err != nil {
+		to := "default"
+		if dst != nil {
+			to = dst.String()
+		}
+		return fmt.Errorf("failed to set the route to %s via %q in table %d: %v", to, iface, t, err)
+	}
+	return nil
+}
+
+// routeMsg returns the rtmsg and attributes of the route of routeReplace
+// through the interface with index i.
+func routeMsg(t int, dst *net.IPNet, gw, src net.IP, i int) ([]byte, []rtAttr) {
+	family, _ := ipFamily(gw)
+	if dst != nil {
+		family, _ = ipFamily(dst.IP)
+	}
+	b := make([]byte, sizeofRtMsg)
+	b[0] = family
+	if t < 256 {
+		b[4] = uint8(t)
+	}
+	b[5], b[6], b[7] = syscall.RTPROT_BOOT, syscall.RT_SCOPE_UNIVERSE, syscall.RTN_UNICAST
+	attrs := []rtAttr{{syscall.RTA_TABLE, nlUint32(uint32(t))}, {syscall.RTA_OIF, nlUint32(uint32(i))}}
+	if dst != nil {
+		ones, _ := dst.Mask.Size()
+		_, a := ipFamily(dst.IP)
+		b[1] = uint8(ones)
+		attrs = append(attrs, rtAttr{syscall.RTA_DST, a})
+	}
+	if gw != nil {
+		_, a := ipFamily(gw)
+		attrs = append(attrs, rtAttr{syscall.RTA_GATEWAY, a})
+	} else {
+		b[6] = syscall.RT_SCOPE_LINK
+	}
+	if src != nil {
+		_, a := ipFamily(src)
+		attrs = append(attrs, rtAttr{syscall.RTA_PREFSRC, a})
+	}
+	return b, attrs
+}
+
+// ruleMsg returns a fib_rule_hdr of the family of ip looking up table t.
+func ruleMsg(ip net.IP, t int) []byte {
+	b := make([]byte, sizeofRtMsg)
+	b[0], _ = ipFamily(ip)
+	if t < 256 {
+		b[4] = uint8(t)
+	}
+	b[7] = frActToTbl
+	return b
+}
+
+// hasRule returns whether there is a rule sending traffic from address ip to
+// routing table t.
+func hasRule(ip net.IP, t int) (bool, error) {
+	_, a := ipFamily(ip)
+	rules, err := netlinkRequest(syscall.RTM_GETRULE, syscall.NLM_F_DUMP, ruleMsg(ip, 0))
+	if err != nil {
+		return false, fmt.Errorf("failed to list rules: %v", err)
+	}
+	for _, r := range rules {
+		if len(r) < sizeofRtMsg || int(r[2]) != len(a)*8 {
+			continue
+		}
+		attrs := parseRtAttrs(r[sizeofRtMsg:])
+		table := int(r[4])
+		if v, ok := attrs[fraTable]; ok && len(v) == 4 {
+			table = int(binary.NativeEndian.Uint32(v))
+		}
+		if table == t && net.IP(attrs[fraSrc]).Equal(ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// srcRuleMsg returns the fib_rule_hdr and attributes of a rule sending
+// traffic from address ip to routing table t.
+func srcRuleMsg(ip net.IP, t int) ([]byte, []rtAttr) {
+	_, a := ipFamily(ip)
+	b := ruleMsg(ip, t)
+	b[2] = uint8(len(a) * 8)
+	return b, []rtAttr{{fraSrc, a}, {fraTable, nlUint32(uint32(t))}}
+}
+
+// addRule adds a rule sending traffic from address ip to routing table t.
+func addRule(ip net.IP, t int) error {
+	b, attrs := srcRuleMsg(ip, t)
+	if _, err := netlinkRequest(syscall.RTM_NEWRULE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, b, attrs...); err != nil {
+		return fmt.Errorf("failed to add a rule from %q to table %d: %v", ip, t, err)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package smilodon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// u16 and u32 return v in host byte order.
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.NativeEndian.PutUint16(b, v)
+	return b
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, v)
+	return b
+}
+
+// join concatenates bs.
+func join(bs ...[]byte) []byte {
+	return bytes.Join(bs, nil)
+}
+
+func TestNlAlign(t *testing.T) {
+	for _, tc := range []struct{ n, want int }{
+		{0, 0},
+		{1, 4},
+		{3, 4},
+		{4, 4},
+		{5, 8},
+		{8, 8},
+		{9, 12},
+	} {
+		if got := nlAlign(tc.n); got != tc.want {
+			t.Errorf("nlAlign(%d) = %d, want %d", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestParseRtAttrs(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		b    []byte
+		want map[uint16][]byte
+	}{
+		{
+			name: "empty",
+			want: map[uint16][]byte{},
+		},
+		{
+			name: "aligned",
+			b:    join(u16(8), u16(1), []byte{10, 0, 0, 1}, u16(8), u16(2), u32(100)),
+			want: map[uint16][]byte{1: {10, 0, 0, 1}, 2: u32(100)},
+		},
+		{
+			name: "padded",
+			b:    join(u16(5), u16(3), []byte{'a', 0, 0, 0}, u16(6), u16(4), []byte{'b', 'c', 0, 0}),
+			want: map[uint16][]byte{3: {'a'}, 4: {'b', 'c'}},
+		},
+		{
+			name: "unpadded last",
+			b:    join(u16(8), u16(1), u32(7), u16(5), u16(2), []byte{'x'}),
+			want: map[uint16][]byte{1: u32(7), 2: {'x'}},
+		},
+		{
+			name: "truncated",
+			b:    join(u16(8), u16(1), u32(7), u16(12), u16(2), u32(9)),
+			want: map[uint16][]byte{1: u32(7)},
+		},
+		{
+			name: "short length",
+			b:    join(u16(2), u16(1), u32(7)),
+			want: map[uint16][]byte{},
+		},
+	} {
+		if got := parseRtAttrs(tc.b); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: parseRtAttrs() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLinkMsg(t *testing.T) {
+	for _, tc := range []struct {
+		i             int
+		flags, change uint32
+		want          []byte
+	}{
+		{3, syscall.IFF_UP, syscall.IFF_UP, join(make([]byte, 4), u32(3), u32(syscall.IFF_UP), u32(syscall.IFF_UP))},
+		{7, 0, 0, join(make([]byte, 4), u32(7), u32(0), u32(0))},
+	} {
+		got := linkMsg(tc.i, tc.flags, tc.change)
+		if len(got) != syscall.SizeofIfInfomsg || !bytes.Equal(got, tc.want) {
+			t.Errorf("linkMsg(%d, %#x, %#x) = %v, want %v", tc.i, tc.flags, tc.change, got, tc.want)
+		}
+	}
+}
+
+func TestNlMessage(t *testing.T) {
+	body := []byte{1, 2, 3, 4}
+	for _, tc := range []struct {
+		name  string
+		flags uint16
+		attrs []rtAttr
+		want  []byte
+	}{
+		{
+			name:  "acknowledged",
+			flags: syscall.NLM_F_CREATE,
+			want:  join(u32(20), u16(syscall.RTM_NEWADDR), u16(syscall.NLM_F_REQUEST|syscall.NLM_F_ACK|syscall.NLM_F_CREATE), u32(1), u32(0), body),
+		},
+		{
+			name:  "replace",
+			flags: syscall.NLM_F_CREATE | syscall.NLM_F_REPLACE,
+			want:  join(u32(20), u16(syscall.RTM_NEWADDR), u16(syscall.NLM_F_REQUEST|syscall.NLM_F_ACK|syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE), u32(1), u32(0), body),
+		},
+		{
+			name:  "dump",
+			flags: syscall.NLM_F_DUMP,
+			want:  join(u32(20), u16(syscall.RTM_NEWADDR), u16(syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP), u32(1), u32(0), body),
+		},
+		{
+			name:  "padded attributes",
+			attrs: []rtAttr{{1, []byte{'a'}}, {2, u32(5)}, {3, []byte{'b', 'c'}}},
+			want: join(u32(44), u16(syscall.RTM_NEWADDR), u16(syscall.NLM_F_REQUEST|syscall.NLM_F_ACK), u32(1), u32(0), body,
+				u16(5), u16(1), []byte{'a', 0, 0, 0},
+				u16(8), u16(2), u32(5),
+				u16(6), u16(3), []byte{'b', 'c', 0, 0}),
+		},
+	} {
+		if got := nlMessage(syscall.RTM_NEWADDR, tc.flags, body, tc.attrs...); !bytes.Equal(got, tc.want) {
+			t.Errorf("%s: nlMessage() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRouteMsg(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.1.0/24")
+	_, subnet6, _ := net.ParseCIDR("2001:db8::/64")
+	for _, tc := range []struct {
+		name      string
+		table     int
+		dst       *net.IPNet
+		gw, src   net.IP
+		want      []byte
+		wantAttrs []rtAttr
+	}{
+		{
+			name:  "default via gateway",
+			table: 100,
+			gw:    net.ParseIP("10.0.1.1"),
+			want:  []byte{syscall.AF_INET, 0, 0, 0, 100, syscall.RTPROT_BOOT, syscall.RT_SCOPE_UNIVERSE, syscall.RTN_UNICAST, 0, 0, 0, 0},
+			wantAttrs: []rtAttr{
+				{syscall.RTA_TABLE, u32(100)},
+				{syscall.RTA_OIF, u32(4)},
+				{syscall.RTA_GATEWAY, []byte{10, 0, 1, 1}},
+			},
+		},
+		{
+			name:  "connected subnet with source",
+			table: 100,
+			dst:   subnet,
+			src:   net.ParseIP("10.0.1.20"),
+			want:  []byte{syscall.AF_INET, 24, 0, 0, 100, syscall.RTPROT_BOOT, syscall.RT_SCOPE_LINK, syscall.RTN_UNICAST, 0, 0, 0, 0},
+			wantAttrs: []rtAttr{
+				{syscall.RTA_TABLE, u32(100)},
+				{syscall.RTA_OIF, u32(4)},
+				{syscall.RTA_DST, []byte{10, 0, 1, 0}},
+				{syscall.RTA_PREFSRC, []byte{10, 0, 1, 20}},
+			},
+		},
+		{
+			name:  "large table",
+			table: 1000,
+			dst:   subnet6,
+			want:  []byte{syscall.AF_INET6, 64, 0, 0, 0, syscall.RTPROT_BOOT, syscall.RT_SCOPE_LINK, syscall.RTN_UNICAST, 0, 0, 0, 0},
+			wantAttrs: []rtAttr{
+				{syscall.RTA_TABLE, u32(1000)},
+				{syscall.RTA_OIF, u32(4)},
+				{syscall.RTA_DST, []byte(subnet6.IP)},
+			},
+		},
+	} {
+		got, attrs := routeMsg(tc.table, tc.dst, tc.gw, tc.src, 4)
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("%s: routeMsg() = %v, want %v", tc.name, got, tc.want)
+		}
+		if !reflect.DeepEqual(attrs, tc.wantAttrs) {
+			t.Errorf("%s: routeMsg() attributes = %v, want %v", tc.name, attrs, tc.wantAttrs)
+		}
+	}
+}
+
+func TestRuleMsg(t *testing.T) {
+	for _, tc := range []struct {
+		ip    string
+		table int
+		want  []byte
+	}{
+		{"10.0.1.20", 100, []byte{syscall.AF_INET, 0, 0, 0, 100, 0, 0, frActToTbl, 0, 0, 0, 0}},
+		{"2001:db8::20", 100, []byte{syscall.AF_INET6, 0, 0, 0, 100, 0, 0, frActToTbl, 0, 0, 0, 0}},
+		{"10.0.1.20", 1000, []byte{syscall.AF_INET, 0, 0, 0, 0, 0, 0, frActToTbl, 0, 0, 0, 0}},
+	} {
+		if got := ruleMsg(net.ParseIP(tc.ip), tc.table); !bytes.Equal(got, tc.want) {
+			t.Errorf("ruleMsg(%s, %d) = %v, want %v", tc.ip, tc.table, got, tc.want)
+		}
+	}
+}
+
+func TestSrcRuleMsg(t *testing.T) {
+	for _, tc := range []struct {
+		ip        string
+		table     int
+		want      []byte
+		wantAttrs []rtAttr
+	}{
+		{
+			ip:        "10.0.1.20",
+			table:     100,
+			want:      []byte{syscall.AF_INET, 0, 32, 0, 100, 0, 0, frActToTbl, 0, 0, 0, 0},
+			wantAttrs: []rtAttr{{fraSrc, []byte{10, 0, 1, 20}}, {fraTable, u32(100)}},
+		},
+		{
+			ip:        "2001:db8::20",
+			table:     1000,
+			want:      []byte{syscall.AF_INET6, 0, 128, 0, 0, 0, 0, frActToTbl, 0, 0, 0, 0},
+			wantAttrs: []rtAttr{{fraSrc, []byte(net.ParseIP("2001:db8::20"))}, {fraTable, u32(1000)}},
+		},
+	} {
+		got, attrs := srcRuleMsg(net.ParseIP(tc.ip), tc.table)
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("srcRuleMsg(%s, %d) = %v, want %v", tc.ip, tc.table, got, tc.want)
+		}
+		if !reflect.DeepEqual(attrs, tc.wantAttrs) {
+			t.Errorf("srcRuleMsg(%s, %d) attributes = %v, want %v", tc.ip, tc.table, attrs, tc.wantAttrs)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package smilodon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// sysctl is a per-interface IPv4 sysctl setting.
+type sysctl struct {
+	key   string
+	value string
+}
+
+// parseSysctls parses per-interface sysctls ss of the form key=value, for
+// example 'arp_ignore=1'. rp_filter is set to rpFilter first, unless it is
+// empty.
+func parseSysctls(rpFilter string, ss []string) ([]sysctl, error) {
+	var out []sysctl
+	if rpFilter != "" {
+		out = append(out, sysctl{"rp_filter", rpFilter})
+	}
+	for _, s := range ss {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || strings.ContainsAny(parts[0], "/.") {
+			return nil, fmt.Errorf("invalid sysctl %q, expected key=value", s)
+		}
+		out = append(out, sysctl{parts[0], parts[1]})
+	}
+	return out, nil
+}
+
+// Network interface configuration modes.
+const (
+	// ifaceModeWait waits for the IP address to be configured by DHCP.
+	ifaceModeWait = "wait"
+	// ifaceModeStatic assigns the IP address directly.
+	ifaceModeStatic = "static"
+)
+
+// waitAndSetupIface blocks until network interface becomes ready and gets an
+// IP, then set needed sysctl settings. In static mode, the IP is assigned
+// right away instead of waiting for DHCP.
+func (r *Reconciler) waitAndSetupIface(ctx context.Context, n NetworkInterface) {
+	_, s := startSpan(ctx, "wait for network interface", "network_interface_id", n.ID)
+	defer s.end(nil)
+	if r.cfg.IfaceMode == ifaceModeStatic {
+		iface, err := configureIface(n, r.cfg.AttachTimeout)
+		if err != nil {
+			log.Printf("failed to configure interface: %v", err)
+			return
+		}
+		r.setupIface(iface, n)
+		return
+	}
+	for deadline := time.Now().Add(r.cfg.IfaceWaitTimeout); ; {
+		if time.Now().After(deadline) {
+			log.Printf("Network interface %q did not get %q within %s.\n", n.ID, n.IPAddress, r.cfg.IfaceWaitTimeout)
+			return
+		}
+		time.Sleep(ifaceWaitInterval)
+
+		iface, err := findIface(n)
+		if err != nil {
+			log.Printf("failed to get interface name: %v", err)
+		}
+		if iface == "" {
+			continue
+		}
+		if ok, err := ifaceHasIP(iface, n.IPAddress); !ok {
+			if err != nil {
+				log.Printf("failed to get interface addresses: %v", err)
+			}
+			log.Printf("Waiting for %q to be assigned to %q.\n", n.IPAddress, iface)
+			continue
+		}
+		if err := r.setupIface(iface, n); err == nil {
+			return
+		}
+	}
+}
+
+// ifaceWaitInterval is the interval at which the attached network interface
+// is checked for its IP address in wait mode.
+const ifaceWaitInterval = time.Second
+
+// setupIface sets sysctls and, if enabled, the MTU and policy routing of
+// interface iface of network interface n, then assigns alias addresses and
+// announces the addresses to peers.
+func (r *Reconciler) setupIface(iface string, n NetworkInterface) error {
+	ip := n.IPAddress
+	if err := setIfaceSysctls(iface, r.sysctls); err != nil {
+		log.Printf("failed to set sysctls: %v", err)
+		return err
+	}
+	if r.cfg.IfaceMTU > 0 {
+		if err := linkSetMTU(iface, r.cfg.IfaceMTU); err != nil {
+			log.Printf("failed to set MTU: %v", err)
+		} else {
+			log.Printf("Set MTU of %q to %d.\n", iface, r.cfg.IfaceMTU)
+		}
+	}
+	if r.cfg.PolicyRoutingTable > 0 {
+		if err := setupPolicyRouting(iface, ip, r.cfg.PolicyRoutingTable); err != nil {
+			log.Printf("failed to set up policy routing: %v", err)
+		}
+	}
+	if r.cfg.GratuitousARP {
+		announce(iface, ip)
+	}
+	r.addAliases(iface, n)
+	return nil
+}
+
+// configureIface brings up the interface with the MAC address of n, waiting
+// up to timeout t for it to appear, and assigns the IP address of n to it. It
+// returns the interface name.
+func configureIface(n NetworkInterface, t time.Duration) (string, error) {
+	if n.MACAddress == "" || n.SubnetCIDR == "" {
+		return "", fmt.Errorf("MAC address or subnet of network interface %q is unknown", n.ID)
+	}
+	_, subnet, err := net.ParseCIDR(n.SubnetCIDR)
+	if err != nil {
+		return "", err
+	}
+	ones, _ := subnet.Mask.Size()
+
+	// The interface shows up shortly after the attachment.
+	var iface string
+	for deadline := time.Now().Add(t); iface == "" && time.Now().Before(deadline); {
+		if iface, err = getIfaceNameByMAC(n.MACAddress); err != nil {
+			return "", err
+		}
+		if iface == "" {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+	if iface == "" {
+		return "", fmt.Errorf("no interface with MAC address %q found", n.MACAddress)
+	}
+	if err := linkSetUp(iface); err != nil {
+		return "", err
+	}
+	addr := fmt.Sprintf("%s/%d", n.IPAddress, ones)
+	if err := addrReplace(iface, addr); err != nil {
+		return "", err
+	}
+	log.Printf("Assigned %q to %q.\n", addr, iface)
+	return iface, nil
+}
+
+// findIface returns the name of the interface of network interface n. It is
+// matched by MAC address, which is known right after the attachment, and by
+// IP address only if the provider does not report MAC addresses.
+func findIface(n NetworkInterface) (string, error) {
+	if n.MACAddress == "" {
+		return getIfaceNameByIP(n.IPAddress)
+	}
+	return getIfaceNameByMAC(n.MACAddress)
+}
+
+// ifaceHasIP checks whether interface iface has IP address ip assigned.
+func ifaceHasIP(iface, ip string) (bool, error) {
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		return false, err
+	}
+	addrs, err := i.Addrs()
+	if err != nil {
+		return false, err
+	}
+	for _, a := range addrs {
+		if netIP, _, err := net.ParseCIDR(a.String()); err == nil && netIP.Equal(net.ParseIP(ip)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getIfaceNameByMAC returns network interface name by MAC address.
+func getIfaceNameByMAC(mac string) (string, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return "", err
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		if bytes.Equal(iface.HardwareAddr, hw) {
+			return iface.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// getIfaceNameByIP returns network interface name by IP address.
+func getIfaceNameByIP(ip string) (string, error) {
+	var name string
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return name, err
+	}
+	for _, iface := range ifaces {
+		addr, err := iface.Addrs()
+		if err != nil {
+			return name, err
+		}
+		for _, a := range addr {
+			netIP, _, err := net.ParseCIDR(a.String())
+			if err != nil {
+				return name, err
+			}
+			if netIP.Equal(net.ParseIP(ip)) {
+				name = iface.Name
+			}
+		}
+	}
+	return name, nil
+}
+
+// setIfaceSysctls sets sysctls ss of interface iface, for example
+// /proc/sys/net/ipv4/conf/<iface>/rp_filter. An rp_filter value of 2 is
+// needed to accept asymmetrically routed (outgoing routes and incoming
+// routes are different) packets on iface interface.
+func setIfaceSysctls(iface string, ss []sysctl) error {
+	for _, s := range ss {
+		key := fmt.Sprintf("/proc/sys/net/ipv4/conf/%s/%s", iface, s.key)
+		if err := writeSysctl(key, s.value); err != nil {
+			return err
+		}
+		log.Printf("Set %s to %q.\n", key, s.value)
+	}
+	return nil
+}
+
+// writeSysctl writes value v to sysctl file key. Network sysctls are those
+// of the network namespace of smilodon, which must be that of the host.
+func writeSysctl(key, v string) error {
+	f, err := os.OpenFile(key, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(v + "\n"); err != nil {
+		return err
+	}
+	return nil
+}
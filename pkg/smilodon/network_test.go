@@ -0,0 +1,65 @@
+package smilodon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSysctls(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		rpFilter string
+		ss       []string
+		want     []sysctl
+		wantErr  bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:     "rp_filter first",
+			rpFilter: "2",
+			ss:       []string{"arp_ignore=1", "arp_announce=2"},
+			want:     []sysctl{{"rp_filter", "2"}, {"arp_ignore", "1"}, {"arp_announce", "2"}},
+		},
+		{
+			name: "value with equals sign",
+			ss:   []string{"key=a=b"},
+			want: []sysctl{{"key", "a=b"}},
+		},
+		{
+			name: "empty value",
+			ss:   []string{"arp_ignore="},
+			want: []sysctl{{"arp_ignore", ""}},
+		},
+		{
+			name:    "missing value",
+			ss:      []string{"arp_ignore"},
+			wantErr: true,
+		},
+		{
+			name:    "empty key",
+			ss:      []string{"=1"},
+			wantErr: true,
+		},
+		{
+			name:    "path",
+			ss:      []string{"../all/forwarding=1"},
+			wantErr: true,
+		},
+		{
+			name:    "dotted key",
+			ss:      []string{"net.ipv4.ip_forward=1"},
+			wantErr: true,
+		},
+	} {
+		got, err := parseSysctls(tc.rpFilter, tc.ss)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: parseSysctls() error = %v, want error %t", tc.name, err, tc.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: parseSysctls() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package smilodon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Settings which can be overridden per node ID.
+const (
+	settingBlockDevice = "block-device"
+	settingMountPoint  = "mount-point"
+	settingFsType      = "file-system-type"
+	settingMkfsOptions = "mkfs-options"
+	// Performance requirements of the volume, see Config.MinVolumeIOPS.
+	settingMinIOPS       = "min-iops"
+	settingMinThroughput = "min-throughput"
+	settingVolumeTypes   = "volume-types"
+)
+
+// nodeOverride overrides a setting for a node ID or a range of numeric node
+// IDs.
+type nodeOverride struct {
+	id       string
+	from, to uint64
+	key      string
+	value    string
+}
+
+// parseNodeOverrides parses node overrides ss of the form
+// <nodes>:<setting>=<value>, where nodes is a node ID or an inclusive range of
+// numeric node IDs, for example '1-3:block-device=/dev/xvdf'.
+func parseNodeOverrides(ss []string) ([]nodeOverride, error) {
+	var out []nodeOverride
+	for _, s := range ss {
+		i := strings.Index(s, ":")
+		j := strings.Index(s, "=")
+		if i <= 0 || j < i {
+			return nil, fmt.Errorf("invalid node override %q, expected <nodes>:<setting>=<value>", s)
+		}
+		o := nodeOverride{key: s[i+1 : j], value: s[j+1:]}
+		switch o.key {
+		case settingBlockDevice, settingMountPoint, settingFsType, settingMkfsOptions, settingVolumeTypes:
+		case settingMinIOPS, settingMinThroughput:
+			if _, err := strconv.ParseInt(o.value, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid %s in node override %q", o.key, s)
+			}
+		default:
+			return nil, fmt.Errorf("unknown setting %q in node override %q", o.key, s)
+		}
+		nodes := s[:i]
+		if k := strings.Index(nodes, "-"); k > 0 {
+			from, ferr := strconv.ParseUint(nodes[:k], 10, 64)
+			to, terr := strconv.ParseUint(nodes[k+1:], 10, 64)
+			if ferr == nil && terr == nil {
+				if from > to {
+					return nil, fmt.Errorf("invalid node range %q in node override %q", nodes, s)
+				}
+				o.from, o.to = from, to
+				out = append(out, o)
+				continue
+			}
+		}
+		o.id = nodes
+		out = append(out, o)
+	}
+	return out, nil
+}
+
+// matches checks whether override o applies to node ID id.
+func (o nodeOverride) matches(id string) bool {
+	if o.id != "" {
+		return o.id == id
+	}
+	n, err := strconv.ParseUint(id, 10, 64)
+	return err == nil && o.from <= n && n <= o.to
+}
+
+// setting returns setting key of node ID id: the value of the last matching
+// override, or def.
+func (r *Reconciler) setting(id, key, def string) string {
+	v := def
+	for _, o := range r.overrides {
+		if o.key == key && o.matches(id) {
+			v = o.value
+		}
+	}
+	return v
+}
+
+// volumeNodeID returns the node ID of the attached volume, if any.
+func (r *Reconciler) volumeNodeID() string {
+	if r.node.Volume == nil {
+		return ""
+	}
+	return r.node.Volume.NodeID
+}
+
+// blockDevice returns the block device of the attached volume, which is the
+// device name it is actually attached as if known.
+func (r *Reconciler) blockDevice() string {
+	if v := r.node.Volume; v != nil && v.Device != "" {
+		return v.Device
+	}
+	return r.setting(r.volumeNodeID(), settingBlockDevice, r.cfg.BlockDevice)
+}
+
+// mountPoint returns the mount point of the attached volume.
+func (r *Reconciler) mountPoint() string {
+	return r.setting(r.volumeNodeID(), settingMountPoint, r.cfg.MountPoint)
+}
+
+// fileSystemType returns the file system type of the attached volume.
+func (r *Reconciler) fileSystemType() string {
+	return r.setting(r.volumeNodeID(), settingFsType, r.cfg.FsType)
+}
+
+// mkfsOptions returns the mkfs options of the attached volume.
+func (r *Reconciler) mkfsOptions() []string {
+	return strings.Fields(r.setting(r.volumeNodeID(), settingMkfsOptions, r.cfg.MkfsOptions))
+}
+
+// BlockDevice returns the block device the attached volume is attached as.
+func (r *Reconciler) BlockDevice() string {
+	return r.blockDevice()
+}
+
+// MountPoint returns the mount point of the attached volume.
+func (r *Reconciler) MountPoint() string {
+	return r.mountPoint()
+}
@@ -0,0 +1,69 @@
+package smilodon
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+)
+
+// Supported node ID formats.
+const (
+	nodeIDFormatString  = "string"
+	nodeIDFormatNumeric = "numeric"
+)
+
+var nodeIDNumberRe = regexp.MustCompile(`[0-9]+`)
+
+// parseNodeID parses raw node ID s in format f. Numeric node IDs are the last
+// number found in s without leading zeros, so that for example 'etcd-03' and
+// '3' are the same node.
+func parseNodeID(s, f string) (string, error) {
+	switch f {
+	case nodeIDFormatString, "":
+		return s, nil
+	case nodeIDFormatNumeric:
+		ns := nodeIDNumberRe.FindAllString(s, -1)
+		if len(ns) == 0 {
+			return "", fmt.Errorf("node ID %q is not numeric", s)
+		}
+		n, err := strconv.ParseUint(ns[len(ns)-1], 10, 64)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatUint(n, 10), nil
+	}
+	return "", fmt.Errorf("unknown node ID format %q", f)
+}
+
+// normalizeVolumes parses the node IDs of volumes vs. Volumes without a
+// valid node ID are dropped.
+func (r *Reconciler) normalizeVolumes(vs []Volume) []Volume {
+	var out []Volume
+	for _, v := range vs {
+		id, err := parseNodeID(v.NodeID, r.cfg.NodeIDFormat)
+		if err != nil || id == "" {
+			log.Printf("Ignoring volume %q without a valid node ID: %q.\n", v.ID, v.NodeID)
+			continue
+		}
+		v.NodeID = id
+		out = append(out, v)
+	}
+	return out
+}
+
+// normalizeInterfaces parses the node IDs of network interfaces ns. Network
+// interfaces without a valid node ID are dropped.
+func (r *Reconciler) normalizeInterfaces(ns []NetworkInterface) []NetworkInterface {
+	var out []NetworkInterface
+	for _, n := range ns {
+		id, err := parseNodeID(n.NodeID, r.cfg.NodeIDFormat)
+		if err != nil || id == "" {
+			log.Printf("Ignoring network interface %q without a valid node ID: %q.\n", n.ID, n.NodeID)
+			continue
+		}
+		n.NodeID = id
+		out = append(out, n)
+	}
+	return out
+}
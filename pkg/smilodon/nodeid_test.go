@@ -0,0 +1,30 @@
+package smilodon
+
+import "testing"
+
+func TestParseNodeID(t *testing.T) {
+	for _, tc := range []struct {
+		s, f    string
+		want    string
+		wantErr bool
+	}{
+		{s: "etcd-03", want: "etcd-03"},
+		{s: "etcd-03", f: nodeIDFormatString, want: "etcd-03"},
+		{s: "etcd-03", f: nodeIDFormatNumeric, want: "3"},
+		{s: "3", f: nodeIDFormatNumeric, want: "3"},
+		{s: "000", f: nodeIDFormatNumeric, want: "0"},
+		{s: "az1-etcd-12", f: nodeIDFormatNumeric, want: "12"},
+		{s: "etcd", f: nodeIDFormatNumeric, wantErr: true},
+		{s: "99999999999999999999", f: nodeIDFormatNumeric, wantErr: true},
+		{s: "etcd-03", f: "hex", wantErr: true},
+	} {
+		got, err := parseNodeID(tc.s, tc.f)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseNodeID(%q, %q) error = %v, want error %t", tc.s, tc.f, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseNodeID(%q, %q) = %q, want %q", tc.s, tc.f, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package smilodon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const openstackMetadataURL = "http://169.254.169.254/openstack/latest/meta_data.json"
+
+// OpenStackProvider is a Provider backed by Cinder volumes and Neutron ports.
+// Cinder volumes carry the node ID in their NodeID metadata key, Neutron ports
+// in a NodeID=<id> tag.
+type OpenStackProvider struct {
+	instance Instance
+	filters  map[string]string
+	client   *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	endpoints map[string]string
+}
+
+// NewOpenStackProvider returns an OpenStackProvider for the server it runs on.
+// Credentials are read from the usual OS_* environment variables. Filters is a
+// comma-delimited list of key=value pairs, which volumes must have as metadata
+// and ports as tags.
+func NewOpenStackProvider(filters string) (*OpenStackProvider, error) {
+	p := &OpenStackProvider{
+		client:  &http.Client{Timeout: 30 * time.Second},
+		filters: parseTagFilters(filters),
+	}
+	ctx := context.Background()
+	if err := p.getMetadata(ctx); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMetadata, err)
+	}
+	if err := p.getNetwork(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *OpenStackProvider) getMetadata(ctx context.Context) error {
+	var m struct {
+		UUID             string `json:"uuid"`
+		AvailabilityZone string `json:"availability_zone"`
+	}
+	if err := doJSON(ctx, p.client, "GET", openstackMetadataURL, nil, nil, &m); err != nil {
+		log.Printf("Failed to get server metadata from the metadata service: %q.\n", err)
+		return err
+	}
+	p.instance.ID = m.UUID
+	p.instance.AZ = m.AvailabilityZone
+	p.instance.Region = os.Getenv("OS_REGION_NAME")
+	return nil
+}
+
+// getNetwork sets the instance VPC to the network of its first port.
+func (p *OpenStackProvider) getNetwork(ctx context.Context) error {
+	var r struct {
+		Ports []struct {
+			NetworkID string `json:"network_id"`
+		} `json:"ports"`
+	}
+	if err := p.do(ctx, "network", "GET", "/v2.0/ports?device_id="+p.instance.ID, nil, &r); err != nil {
+		log.Printf("Failed to get server ports: %q.\n", err)
+		return err
+	}
+	if len(r.Ports) > 0 {
+		p.instance.VPC = r.Ports[0].NetworkID
+	}
+	return nil
+}
+
+// authenticate gets a Keystone v3 token using password credentials from the
+// environment and reads service endpoints from the token catalog.
+func (p *OpenStackProvider) authenticate(ctx context.Context) error {
+	project := map[string]interface{}{}
+	if id := os.Getenv("OS_PROJECT_ID"); id != "" {
+		project["id"] = id
+	} else {
+		project["name"] = os.Getenv("OS_PROJECT_NAME")
+		project["domain"] = map[string]string{"name": envOr("OS_PROJECT_DOMAIN_NAME", "Default")}
+	}
+	body := map[string]interface{}{
+		"auth": map[string]interface{}{
+			"identity": map[string]interface{}{
+				"methods": []string{"password"},
+				"password": map[string]interface{}{
+					"user": map[string]interface{}{
+						"name":     os.Getenv("OS_USERNAME"),
+						"password": os.Getenv("OS_PASSWORD"),
+						"domain":   map[string]string{"name": envOr("OS_USER_DOMAIN_NAME", "Default")},
+					},
+				},
+			},
+			"scope": map[string]interface{}{"project": project},
+		},
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(os.Getenv("OS_AUTH_URL"), "/") + "/auth/tokens"
+	req, err := http.NewRequest("POST", u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("keystone authentication failed: %s: %s", resp.Status, bytes.TrimSpace(rb))
+	}
+	var t struct {
+		Token struct {
+			ExpiresAt time.Time `json:"expires_at"`
+			Catalog   []struct {
+				Type      string `json:"type"`
+				Endpoints []struct {
+					Interface string `json:"interface"`
+					Region    string `json:"region"`
+					URL       string `json:"url"`
+				} `json:"endpoints"`
+			} `json:"catalog"`
+		} `json:"token"`
+	}
+	if err := json.Unmarshal(rb, &t); err != nil {
+		return err
+	}
+	iface := envOr("OS_INTERFACE", "public")
+	endpoints := map[string]string{}
+	for _, s := range t.Token.Catalog {
+		for _, e := range s.Endpoints {
+			if e.Interface == iface && (p.instance.Region == "" || e.Region == p.instance.Region) {
+				endpoints[s.Type] = strings.TrimSuffix(e.URL, "/")
+			}
+		}
+	}
+	// Block storage is registered under different types in different clouds.
+	for _, t := range []string{"volumev3", "block-storage", "volumev2"} {
+		if u, ok := endpoints[t]; ok {
+			endpoints["volume"] = u
+			break
+		}
+	}
+	p.token = resp.Header.Get("X-Subject-Token")
+	p.expiresAt = t.Token.ExpiresAt
+	p.endpoints = endpoints
+	return nil
+}
+
+// envOr returns the value of environment variable k or d if it is empty.
+func envOr(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
+
+// do calls method m on path r of service s, for example "compute".
+func (p *OpenStackProvider) do(ctx context.Context, s, m, r string, in, out interface{}) error {
+	p.mu.Lock()
+	if p.token == "" || time.Now().Add(time.Minute).After(p.expiresAt) {
+		if err := p.authenticate(ctx); err != nil {
+			p.mu.Unlock()
+			return err
+		}
+	}
+	token, endpoint := p.token, p.endpoints[s]
+	p.mu.Unlock()
+	if endpoint == "" {
+		return fmt.Errorf("no %q service endpoint found in the catalog", s)
+	}
+	return doJSON(ctx, p.client, m, endpoint+r, http.Header{"X-Auth-Token": {token}}, in, out)
+}
+
+// Metadata returns the server smilodon runs on.
+func (p *OpenStackProvider) Metadata(ctx context.Context) (Instance, error) {
+	return p.instance, nil
+}
+
+// DiscoverVolumes returns Cinder volumes in the server availability zone
+// matching the filters.
+func (p *OpenStackProvider) DiscoverVolumes(ctx context.Context) ([]Volume, error) {
+	var r struct {
+		Volumes []struct {
+			ID               string            `json:"id"`
+			Status           string            `json:"status"`
+			AvailabilityZone string            `json:"availability_zone"`
+			Metadata         map[string]string `json:"metadata"`
+			Attachments      []struct {
+				ServerID string `json:"server_id"`
+			} `json:"attachments"`
+		} `json:"volumes"`
+	}
+	var vs []Volume
+	if err := p.do(ctx, "volume", "GET", "/volumes/detail", nil, &r); err != nil {
+		log.Printf("Failed to find volumes: %q.\n", err)
+		return vs, err
+	}
+	for _, i := range r.Volumes {
+		id, ok := i.Metadata["NodeID"]
+		if !ok || i.AvailabilityZone != p.instance.AZ {
+			continue
+		}
+		match := true
+		for k, v := range p.filters {
+			if i.Metadata[k] != v {
+				match = false
+			}
+		}
+		if !match {
+			continue
+		}
+		v := Volume{
+			ID:        i.ID,
+			NodeID:    id,
+			Available: i.Status == "available",
+		}
+		for _, a := range i.Attachments {
+			v.AttachedTo = a.ServerID
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+// DiscoverInterfaces returns Neutron ports in the server network matching the
+// filters.
+func (p *OpenStackProvider) DiscoverInterfaces(ctx context.Context) ([]NetworkInterface, error) {
+	var r struct {
+		Ports []struct {
+			ID         string   `json:"id"`
+			DeviceID   string   `json:"device_id"`
+			MACAddress string   `json:"mac_address"`
+			Tags       []string `json:"tags"`
+			FixedIPs   []struct {
+				IPAddress string `json:"ip_address"`
+			} `json:"fixed_ips"`
+		} `json:"ports"`
+	}
+	var ns []NetworkInterface
+	q := "/v2.0/ports?network_id=" + p.instance.VPC
+	if len(p.filters) > 0 {
+		var tags []string
+		for k, v := range p.filters {
+			tags = append(tags, k+"="+v)
+		}
+		q += "&tags=" + url.QueryEscape(strings.Join(tags, ","))
+	}
+	if err := p.do(ctx, "network", "GET", q, nil, &r); err != nil {
+		log.Printf("Failed to find network interfaces: %q.\n", err)
+		return ns, err
+	}
+	for _, i := range r.Ports {
+		var nodeID string
+		for _, t := range i.Tags {
+			if strings.HasPrefix(t, "NodeID=") {
+				nodeID = strings.TrimPrefix(t, "NodeID=")
+			}
+		}
+		if nodeID == "" {
+			continue
+		}
+		n := NetworkInterface{
+			ID:         i.ID,
+			NodeID:     nodeID,
+			Available:  i.DeviceID == "",
+			AttachedTo: i.DeviceID,
+			MACAddress: i.MACAddress,
+		}
+		if len(i.FixedIPs) > 0 {
+			n.IPAddress = i.FixedIPs[0].IPAddress
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+// AttachVolume attaches Cinder volume v to the server as block device d.
+func (p *OpenStackProvider) AttachVolume(ctx context.Context, v Volume, d string) error {
+	body := map[string]interface{}{
+		"volumeAttachment": map[string]string{
+			"volumeId": v.ID,
+			"device":   d,
+		},
+	}
+	return p.do(ctx, "compute", "POST", "/servers/"+p.instance.ID+"/os-volume_attachments", body, nil)
+}
+
+// DetachVolume detaches Cinder volume v from the server.
+func (p *OpenStackProvider) DetachVolume(ctx context.Context, v Volume) error {
+	return p.do(ctx, "compute", "DELETE", "/servers/"+p.instance.ID+"/os-volume_attachments/"+v.ID, nil, nil)
+}
+
+// AttachInterface attaches Neutron port n to the server.
+func (p *OpenStackProvider) AttachInterface(ctx context.Context, n NetworkInterface) error {
+	body := map[string]interface{}{
+		"interfaceAttachment": map[string]string{"port_id": n.ID},
+	}
+	return p.do(ctx, "compute", "POST", "/servers/"+p.instance.ID+"/os-interface", body, nil)
+}
+
+// DetachInterface detaches Neutron port n from the server.
+func (p *OpenStackProvider) DetachInterface(ctx context.Context, n NetworkInterface) error {
+	return p.do(ctx, "compute", "DELETE", "/servers/"+p.instance.ID+"/os-interface/"+n.ID, nil, nil)
+}
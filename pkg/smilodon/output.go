@@ -0,0 +1,289 @@
+package smilodon
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// filePerms defines permissions and ownership of an output file. Negative uid
+// or gid means it is left unchanged.
+type filePerms struct {
+	mode os.FileMode
+	uid  int
+	gid  int
+}
+
+var defaultFilePerms = filePerms{mode: 0644, uid: -1, gid: -1}
+
+// outputFiles keeps track of files written by smilodon, so that they can be
+// restored if modified externally.
+type outputFiles struct {
+	mu    sync.Mutex
+	host  host
+	perms map[string]filePerms
+	// content holds the content of every written file, keyed by path.
+	content map[string][]byte
+	watcher *watcher
+}
+
+// newOutputFiles returns outputFiles of host h with file permissions perms.
+func newOutputFiles(h host, perms map[string]filePerms) *outputFiles {
+	return &outputFiles{
+		host:    h,
+		perms:   perms,
+		content: map[string][]byte{},
+	}
+}
+
+// setPerms replaces the file permissions.
+func (o *outputFiles) setPerms(perms map[string]filePerms) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.perms = perms
+}
+
+// parseFilePerms parses a comma-delimited list of path=mode[:owner:group]
+// entries, for example '/run/smilodon/environment=0600:root:root'.
+func parseFilePerms(s string) (map[string]filePerms, error) {
+	perms := map[string]filePerms{}
+	if s == "" {
+		return perms, nil
+	}
+	for _, e := range strings.Split(s, ",") {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid file permissions %q", e)
+		}
+		parts := strings.Split(kv[1], ":")
+		if len(parts) != 1 && len(parts) != 3 {
+			return nil, fmt.Errorf("invalid file permissions %q", e)
+		}
+		p := defaultFilePerms
+		m, err := strconv.ParseUint(parts[0], 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file mode %q: %v", parts[0], err)
+		}
+		p.mode = os.FileMode(m)
+		if len(parts) == 3 {
+			if p.uid, err = lookupUID(parts[1]); err != nil {
+				return nil, err
+			}
+			if p.gid, err = lookupGID(parts[2]); err != nil {
+				return nil, err
+			}
+		}
+		perms[kv[0]] = p
+	}
+	return perms, nil
+}
+
+// parseEnvFilePerms overrides permissions p with octal mode m and owner o of
+// the form user:group, if not empty.
+func parseEnvFilePerms(p filePerms, m, o string) (filePerms, error) {
+	if m != "" {
+		mode, err := strconv.ParseUint(m, 8, 32)
+		if err != nil {
+			return p, fmt.Errorf("invalid environment file mode %q: %v", m, err)
+		}
+		p.mode = os.FileMode(mode)
+	}
+	if o != "" {
+		parts := strings.Split(o, ":")
+		if len(parts) != 2 {
+			return p, fmt.Errorf("invalid environment file owner %q, expected user:group", o)
+		}
+		var err error
+		if p.uid, err = lookupUID(parts[0]); err != nil {
+			return p, err
+		}
+		if p.gid, err = lookupGID(parts[1]); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// lookupUID returns the numeric user ID of a user name or ID u.
+func lookupUID(u string) (int, error) {
+	if id, err := strconv.Atoi(u); err == nil {
+		return id, nil
+	}
+	usr, err := user.Lookup(u)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(usr.Uid)
+}
+
+// lookupGID returns the numeric group ID of a group name or ID g.
+func lookupGID(g string) (int, error) {
+	if id, err := strconv.Atoi(g); err == nil {
+		return id, nil
+	}
+	grp, err := user.LookupGroup(g)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(grp.Gid)
+}
+
+// permsFor returns permissions configured for file f.
+func (o *outputFiles) permsFor(f string) filePerms {
+	if p, ok := o.perms[f]; ok {
+		return p
+	}
+	return defaultFilePerms
+}
+
+// write writes data to file f, creating its parent directory if needed,
+// applies configured permissions and remembers the content so that the file
+// can be restored by the watcher.
+func (o *outputFiles) write(f string, data []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.writeFile(f, data, o.permsFor(f)); err != nil {
+		return err
+	}
+	o.content[f] = data
+	if o.watcher != nil {
+		o.watcher.add(path.Dir(f))
+	}
+	return nil
+}
+
+// writeFile writes data to file f and applies permissions p. The data is
+// written and synced to a temporary file next to f with the permissions
+// applied, which is then renamed to f, so that readers never see a partially
+// written file or one with the wrong permissions.
+func (o *outputFiles) writeFile(f string, data []byte, p filePerms) error {
+	hf := o.host.path(f)
+	baseDir := path.Dir(hf)
+	if _, err := os.Stat(baseDir); os.IsNotExist(err) {
+		err := os.MkdirAll(baseDir, 0755)
+		if err != nil {
+			log.Printf("Unable to create output file path %q: %q.\n", baseDir, err)
+		}
+	}
+	t, err := ioutil.TempFile(baseDir, "."+path.Base(hf))
+	if err != nil {
+		log.Printf("Failed to write file %q: %q.\n", f, err)
+		return err
+	}
+	defer os.Remove(t.Name())
+	if err := writeSynced(t, data, p); err != nil {
+		log.Printf("Failed to write file %q: %q.\n", f, err)
+		return err
+	}
+	if err := os.Rename(t.Name(), hf); err != nil {
+		log.Printf("Failed to write file %q: %q.\n", f, err)
+		return err
+	}
+	// The rename only survives a crash once the directory is synced.
+	if d, err := os.Open(baseDir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// writeSynced writes data to file t, applies permissions p, syncs and
+// closes it.
+func writeSynced(t *os.File, data []byte, p filePerms) error {
+	if _, err := t.Write(data); err != nil {
+		t.Close()
+		return err
+	}
+	if err := t.Chmod(p.mode); err != nil {
+		t.Close()
+		return err
+	}
+	if p.uid >= 0 || p.gid >= 0 {
+		if err := t.Chown(p.uid, p.gid); err != nil {
+			t.Close()
+			return err
+		}
+	}
+	if err := t.Sync(); err != nil {
+		t.Close()
+		return err
+	}
+	return t.Close()
+}
+
+// restore rewrites file f if its content or permissions no longer match what
+// smilodon has written.
+func (o *outputFiles) restore(f string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	data, ok := o.content[f]
+	if !ok {
+		return
+	}
+	if fi, err := os.Stat(o.host.path(f)); err == nil && hasPerms(fi, o.permsFor(f)) {
+		if cur, err := ioutil.ReadFile(o.host.path(f)); err == nil && bytes.Equal(cur, data) {
+			return
+		}
+	}
+	log.Printf("File %q was modified or removed externally. Restoring it.\n", f)
+	o.writeFile(f, data, o.permsFor(f))
+}
+
+// hasPerms checks whether file info fi matches permissions p.
+func hasPerms(fi os.FileInfo, p filePerms) bool {
+	if fi.Mode().Perm() != p.mode {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	if p.uid >= 0 && int(st.Uid) != p.uid {
+		return false
+	}
+	if p.gid >= 0 && int(st.Gid) != p.gid {
+		return false
+	}
+	return true
+}
+
+// prune removes the files written before which are not among outputs, so
+// that files which are no longer configured are neither restored nor left
+// behind.
+func (o *outputFiles) prune(outputs []string) {
+	keep := map[string]bool{}
+	for _, f := range outputs {
+		keep[f] = true
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for f := range o.content {
+		if keep[f] {
+			continue
+		}
+		log.Printf("Removing %q, which is no longer an output file.\n", f)
+		delete(o.content, f)
+		if err := os.Remove(o.host.path(f)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %q: %q.\n", f, err)
+		}
+	}
+}
+
+// remove removes output file f, so that it is no longer restored.
+func (o *outputFiles) remove(f string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.content, f)
+	if err := os.Remove(o.host.path(f)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package smilodon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilePerms(t *testing.T) {
+	for _, tc := range []struct {
+		s       string
+		want    map[string]filePerms
+		wantErr bool
+	}{
+		{
+			s:    "",
+			want: map[string]filePerms{},
+		},
+		{
+			s:    "/run/smilodon/environment=0600",
+			want: map[string]filePerms{"/run/smilodon/environment": {mode: 0600, uid: -1, gid: -1}},
+		},
+		{
+			s: "/run/smilodon/environment=0600:0:0,/etc/kafka/server.properties=640:1000:1001",
+			want: map[string]filePerms{
+				"/run/smilodon/environment":    {mode: 0600, uid: 0, gid: 0},
+				"/etc/kafka/server.properties": {mode: 0640, uid: 1000, gid: 1001},
+			},
+		},
+		{s: "/run/smilodon/environment", wantErr: true},
+		{s: "/run/smilodon/environment=0600:0", wantErr: true},
+		{s: "/run/smilodon/environment=rw", wantErr: true},
+		{s: "/run/smilodon/environment=0800", wantErr: true},
+	} {
+		got, err := parseFilePerms(tc.s)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseFilePerms(%q) error = %v, want error %t", tc.s, err, tc.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseFilePerms(%q) = %v, want %v", tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestParseEnvFilePerms(t *testing.T) {
+	p := filePerms{mode: 0640, uid: 1000, gid: 1000}
+	for _, tc := range []struct {
+		m, o    string
+		want    filePerms
+		wantErr bool
+	}{
+		{want: p},
+		{m: "0600", want: filePerms{mode: 0600, uid: 1000, gid: 1000}},
+		{o: "0:0", want: filePerms{mode: 0640, uid: 0, gid: 0}},
+		{m: "0644", o: "1001:1002", want: filePerms{mode: 0644, uid: 1001, gid: 1002}},
+		{m: "rw", wantErr: true},
+		{o: "0", wantErr: true},
+		{o: "0:0:0", wantErr: true},
+	} {
+		got, err := parseEnvFilePerms(p, tc.m, tc.o)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseEnvFilePerms(%q, %q) error = %v, want error %t", tc.m, tc.o, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && got != tc.want {
+			t.Errorf("parseEnvFilePerms(%q, %q) = %v, want %v", tc.m, tc.o, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package smilodon
+
+import (
+	"fmt"
+	"log"
+	"net"
+)
+
+// setupPolicyRouting routes traffic from ip through interface iface using
+// routing table t, so that replies to connections arriving on iface leave
+// through it too. The default gateway is the first address of the subnet of
+// ip, as in AWS VPCs.
+func setupPolicyRouting(iface, ip string, t int) error {
+	subnet, err := ifaceSubnet(iface, ip)
+	if err != nil {
+		return err
+	}
+	gw := make(net.IP, len(subnet.IP))
+	copy(gw, subnet.IP)
+	gw[len(gw)-1]++
+	src := net.ParseIP(ip)
+
+	if err := routeReplace(t, subnet, nil, src, iface); err != nil {
+		return err
+	}
+	if err := routeReplace(t, nil, gw, nil, iface); err != nil {
+		return err
+	}
+	if ok, err := hasRule(src, t); err != nil || ok {
+		return err
+	}
+	if err := addRule(src, t); err != nil {
+		return err
+	}
+	log.Printf("Routing traffic from %q through %q using table %d.\n", ip, iface, t)
+	return nil
+}
+
+// ifaceSubnet returns the subnet of address ip of interface iface.
+func ifaceSubnet(iface, ip string) (*net.IPNet, error) {
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := i.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		addr, subnet, err := net.ParseCIDR(a.String())
+		if err != nil {
+			return nil, err
+		}
+		if addr.Equal(net.ParseIP(ip)) {
+			if v4 := subnet.IP.To4(); v4 != nil {
+				subnet.IP = v4
+			}
+			return subnet, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %q has no address %q", iface, ip)
+}
@@ -0,0 +1,66 @@
+package smilodon
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+// poller computes reconcile intervals. Randomness is seeded from the instance
+// ID, so that a fleet of instances started at the same time spreads its API
+// calls instead of polling in lockstep.
+type poller struct {
+	rnd            *rand.Rand
+	interval       time.Duration
+	stableInterval time.Duration
+	jitter         float64
+}
+
+// newPoller returns a poller of config cfg seeded from instance ID id.
+func newPoller(id string, cfg Config) *poller {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return &poller{
+		rnd:            rand.New(rand.NewSource(int64(h.Sum64()))),
+		interval:       cfg.PollInterval,
+		stableInterval: cfg.StablePollInterval,
+		jitter:         cfg.PollJitter,
+	}
+}
+
+// initialDelay returns a delay in [0, interval) used to offset the reconcile
+// schedule of an instance.
+func (p *poller) initialDelay() time.Duration {
+	if p.jitter <= 0 || p.interval <= 0 {
+		return 0
+	}
+	return time.Duration(p.rnd.Int63n(int64(p.interval)))
+}
+
+// next returns the time to wait before the next reconcile pass. Instances in a
+// stable state wait for the stable interval instead.
+func (p *poller) next(stable bool) time.Duration {
+	d := p.interval
+	if stable && p.stableInterval > 0 {
+		d = p.stableInterval
+	}
+	if p.jitter <= 0 {
+		return d
+	}
+	j := time.Duration(float64(d) * p.jitter)
+	if j <= 0 {
+		return d
+	}
+	return d - j + time.Duration(p.rnd.Int63n(int64(2*j)))
+}
+
+// livenessTTL returns the TTL of health checks and leases kept alive on every
+// reconcile pass of config cfg. It spans a few passes, so that they only
+// expire when smilodon stops.
+func livenessTTL(cfg Config) time.Duration {
+	d := cfg.PollInterval
+	if cfg.StablePollInterval > d {
+		d = cfg.StablePollInterval
+	}
+	return 3 * d
+}
@@ -0,0 +1,56 @@
+package smilodon
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// appPresets are built-in templates of config fragments for common stateful
+// systems, keyed by name.
+var appPresets = map[string]struct {
+	output string
+	text   string
+}{
+	"kafka": {
+		output: "/run/smilodon/kafka.properties",
+		text: `broker.id={{.NumericNodeID}}
+broker.rack={{.AvailabilityZone}}
+`,
+	},
+	"cassandra": {
+		output: "/run/smilodon/cassandra-rackdc.properties",
+		text: `dc={{.Region}}
+rack={{.AvailabilityZone}}
+`,
+	},
+	"elasticsearch": {
+		output: "/run/smilodon/elasticsearch.yml",
+		text: `node.name: "{{.NodeID}}"
+network.publish_host: {{.IPAddress}}
+node.attr.zone: {{.AvailabilityZone}}
+`,
+	},
+}
+
+// parsePresets parses app presets ps of the form name[=output] into output
+// templates. Presets without an output are rendered to their default one.
+func parsePresets(ps []string) ([]outputTemplate, error) {
+	var out []outputTemplate
+	for _, p := range ps {
+		name, output := p, ""
+		if i := strings.Index(p, "="); i >= 0 {
+			name, output = p[:i], p[i+1:]
+		}
+		preset, ok := appPresets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown app preset %q", name)
+		}
+		tmpl, err := template.New(name).Parse(preset.text)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, outputTemplate{tmpl: tmpl, output: firstNonEmpty(output, preset.output)})
+	}
+	return out, nil
+}
@@ -0,0 +1,730 @@
+package smilodon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Reconciler makes sure the instance it runs on holds a complete node.
+type Reconciler struct {
+	cfg        Config
+	provider   Provider
+	instance   Instance
+	node       Node
+	host       host
+	files      *outputFiles
+	templates  []outputTemplate
+	sysctls    []sysctl
+	mountPerms mountPerms
+	mountDirs  []mountDir
+	bindMounts []bindMount
+	events     *eventPublisher
+	kube       *kubeClient
+	cluster    *clusterRecord
+	consul     *consulClient
+	registry   *etcdRegistry
+	metrics    *metricsPublisher
+	tracer     *tracer
+	rnd        *rand.Rand
+	overrides  []nodeOverride
+	ioWatchdog *ioWatchdog
+	recorder   stateRecorder
+
+	volumeAttachTries    int
+	interfaceAttachTries int
+	// relabel is set once a file system is created, which is relabeled for
+	// SELinux after it is mounted.
+	relabel bool
+	// lastSnapshot is the start time of the latest snapshot of the volume.
+	lastSnapshot time.Time
+	// lastTrim is the start time of the latest trim of the file system,
+	// trimming is set while it runs.
+	lastTrim time.Time
+	trimming int32
+	// warmedUp is the ID of the volume a warm-up was last started for,
+	// warming is set while it runs, which has read warmUpDone of
+	// warmUpSize bytes.
+	warmedUp   string
+	warming    int32
+	warmUpDone int64
+	warmUpSize int64
+	// lastVolumeStatus is the time of the latest status check of the
+	// volume, which reported volumeStatus, impaired in impairedChecks
+	// checks in a row.
+	lastVolumeStatus time.Time
+	volumeStatus     string
+	impairedChecks   int
+	// lastFSRCheck is the time of the latest fast snapshot restore check,
+	// which found fsrState for the latest snapshot.
+	lastFSRCheck time.Time
+	fsrState     map[string]string
+	// requirementsChecked is the ID of the volume last found to meet its
+	// performance requirements, or reported as underProvisioned.
+	requirementsChecked string
+	underProvisioned    bool
+	// growFs is set while the file system may be smaller than the volume.
+	growFs bool
+	// reloads passes reloaded configs to the reconcile loop.
+	reloads chan Config
+	// controls passes control API requests to the reconcile loop, which
+	// skips periodic passes while paused.
+	controls chan controlRequest
+	paused   bool
+	// acquired is set once a node was first acquired. lastErr is the last
+	// discovery or attachment error.
+	acquired bool
+	lastErr  error
+	// stickyNodeID is the node ID held before a restart, which is preferred
+	// until stickyUntil.
+	stickyNodeID string
+	stickyUntil  time.Time
+	// scratchReady is set once the instance-store disks are mounted.
+	scratchReady bool
+	// healthFailures counts consecutive failed health probes. No node is
+	// claimed until holdOffUntil after releasing one.
+	healthFailures int
+	holdOffUntil   time.Time
+	// ioErrorPasses counts consecutive passes with I/O errors.
+	ioErrorPasses int
+}
+
+// NewReconciler returns a Reconciler of config cfg managing resources of
+// provider p.
+func NewReconciler(ctx context.Context, cfg Config, p Provider) (*Reconciler, error) {
+	perms, templates, err := parseOutputs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseNodeID("", cfg.NodeIDFormat); err != nil {
+		return nil, err
+	}
+	if err := parseNodeSelection(cfg.NodeSelection); err != nil {
+		return nil, err
+	}
+	if err := parseMultiAttach(cfg.MultiAttach); err != nil {
+		return nil, err
+	}
+	overrides, err := parseNodeOverrides(cfg.NodeOverrides)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Scratch && cfg.ScratchMountPoint == cfg.MountPoint {
+		return nil, fmt.Errorf("scratch mount point %q is the volume mount point", cfg.ScratchMountPoint)
+	}
+	if cfg.IfaceMode != ifaceModeWait && cfg.IfaceMode != ifaceModeStatic {
+		return nil, fmt.Errorf("unknown interface mode %q", cfg.IfaceMode)
+	}
+	sysctls, err := parseSysctls(cfg.RPFilter, cfg.Sysctls)
+	if err != nil {
+		return nil, err
+	}
+	mp, err := parseMountPerms(cfg.MountOwner, cfg.MountMode)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := parseMountDirs(cfg.MountDirs)
+	if err != nil {
+		return nil, err
+	}
+	binds, err := parseBindMounts(cfg.BindMounts)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseMountPropagation(cfg.MountPropagation); err != nil {
+		return nil, err
+	}
+	if err := parseAliasIPs(cfg.AliasIPs); err != nil {
+		return nil, err
+	}
+	if err := parseVolumeRecovery(cfg.VolumeStatusRecovery); err != nil {
+		return nil, err
+	}
+	if err := parseVolumeRequirementAction(cfg.VolumeRequirementAction); err != nil {
+		return nil, err
+	}
+	if cfg.HostRoot != "" && !filepath.IsAbs(cfg.HostRoot) {
+		return nil, fmt.Errorf("host root %q is not an absolute path", cfg.HostRoot)
+	}
+	h := host{cfg.HostRoot}
+	if _, err := template.New("label").Parse(cfg.FsLabel); err != nil {
+		return nil, fmt.Errorf("invalid file system label %q: %v", cfg.FsLabel, err)
+	}
+	i, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	kube, err := newKubeClient(cfg.KubeNodeName, cfg.KubeAPIServer, cfg.KubeTokenFile, cfg.KubeCAFile)
+	if err != nil {
+		return nil, err
+	}
+	watchdog, err := newIOWatchdog(h, cfg.IOErrorWatchdog)
+	if err != nil {
+		return nil, err
+	}
+	awsCfg := defaultAWSConfig(p, i.Region)
+	cluster, err := newClusterRecord(cfg.ClusterRecordZone, cfg.ClusterRecordName, cfg.ClusterRecordTTL, awsCfg)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reconciler{
+		cfg:        cfg,
+		provider:   p,
+		instance:   i,
+		host:       h,
+		files:      newOutputFiles(h, perms),
+		templates:  templates,
+		sysctls:    sysctls,
+		mountPerms: mp,
+		mountDirs:  dirs,
+		bindMounts: binds,
+		events:     newEventPublisher(cfg.EventsTopic, cfg.EventsQueue, awsCfg),
+		kube:       kube,
+		cluster:    cluster,
+		consul:     newConsulClient(cfg.ConsulAddr, cfg.ConsulService, cfg.ConsulToken, livenessTTL(cfg)),
+		registry:   newEtcdRegistry(cfg.EtcdEndpoints, cfg.EtcdPrefix, livenessTTL(cfg)),
+		metrics:    newMetricsPublisher(cfg.MetricsNamespace, awsCfg),
+		tracer:     newTracer(cfg.OTLPEndpoint, i),
+		rnd:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		overrides:  overrides,
+		ioWatchdog: watchdog,
+		growFs:     cfg.ModifyVolume,
+		reloads:    make(chan Config, 1),
+		controls:   make(chan controlRequest),
+	}
+	r.loadStickyNodeID()
+	if cfg.WatchFiles {
+		if err := r.files.watch(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Instance returns the instance the reconciler runs on.
+func (r *Reconciler) Instance() Instance {
+	return r.instance
+}
+
+// Provider returns the provider of the reconciler.
+func (r *Reconciler) Provider() Provider {
+	return r.provider
+}
+
+// Node returns the node currently held by the instance.
+func (r *Reconciler) Node() Node {
+	return r.node
+}
+
+// Stable checks whether the instance holds a complete node, that is a volume
+// and a network interface with matching node IDs are attached and the node ID
+// is set.
+func (r *Reconciler) Stable() bool {
+	n := r.node
+	return n.Volume != nil && n.NetworkInterface != nil &&
+		n.Volume.NodeID == n.NetworkInterface.NodeID && n.ID == n.Volume.NodeID
+}
+
+// Run reconciles periodically until ctx is done. It fails if no node is
+// acquired within ReadyDeadline.
+func (r *Reconciler) Run(ctx context.Context) error {
+	// Run the first pass right away, then shift the schedule by a per-instance
+	// offset.
+	p := newPoller(r.instance.ID, r.cfg)
+	// Reconcile right away on EventBridge events, if enabled. Polling carries
+	// on as a fallback.
+	var trigger chan struct{}
+	if r.cfg.TriggerQueue != "" {
+		trigger = make(chan struct{}, 1)
+		go newTriggerListener(r.cfg.TriggerQueue, defaultAWSConfig(r.provider, r.instance.Region)).listen(ctx, trigger)
+	}
+	start := time.Now()
+	r.Reconcile(ctx)
+	if err := r.checkReadyDeadline(start); err != nil {
+		return err
+	}
+	d := p.initialDelay()
+	for {
+		select {
+		case <-ctx.Done():
+			// The service would otherwise turn critical once its
+			// check TTL expires.
+			dctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			r.deregisterConsul(dctx)
+			cancel()
+			return nil
+		case <-time.After(d + p.next(r.Stable())):
+		case <-trigger:
+			log.Println("Reconciling on event.")
+		case cfg := <-r.reloads:
+			r.applyConfig(cfg)
+			p = newPoller(r.instance.ID, r.cfg)
+			continue
+		case c := <-r.controls:
+			r.control(ctx, c)
+			continue
+		}
+		d = 0
+		if r.paused {
+			log.Println("Reconcile passes are paused.")
+			continue
+		}
+		r.Reconcile(ctx)
+		if err := r.checkReadyDeadline(start); err != nil {
+			return err
+		}
+	}
+}
+
+// discover returns candidate volumes and network interfaces and updates the
+// node with the ones attached to the instance. It returns the last discovery
+// error, if any.
+func (r *Reconciler) discover(ctx context.Context) ([]Volume, []NetworkInterface, error) {
+	// Iterate over found volumes and check if one of them is attached to the
+	// instance, then update r.node.Volume accordingly.
+	volumes, verr := r.provider.DiscoverVolumes(ctx)
+	if verr != nil {
+		log.Println(verr)
+	} else {
+		volumes = r.applyMultiAttach(r.normalizeVolumes(volumes))
+		for _, v := range volumes {
+			if r.node.Volume == nil && v.AttachedTo == r.instance.ID && !v.Available {
+				log.Printf("Found attached volume: %q.\n", v.ID)
+				r.node.Volume = &v
+				break
+			}
+			if r.node.Volume != nil && r.node.Volume.ID == v.ID && v.AttachedTo != r.instance.ID {
+				r.volumeLost(ctx, *r.node.Volume)
+				r.node.Volume = nil
+				break
+			}
+		}
+	}
+
+	// Iterate over found network interfaces and see if one of them is attached
+	// to the instance, then update r.node.NetworkInterface accordingly.
+	networkInterfaces, err := r.provider.DiscoverInterfaces(ctx)
+	if err != nil {
+		log.Println(err)
+	} else {
+		networkInterfaces = r.normalizeInterfaces(networkInterfaces)
+		for _, n := range networkInterfaces {
+			if r.node.NetworkInterface == nil && n.AttachedTo == r.instance.ID && !n.Available {
+				log.Printf("Found attached network interface: %q.\n", n.ID)
+				r.node.NetworkInterface = &n
+				break
+			}
+			if r.node.NetworkInterface != nil && r.node.NetworkInterface.ID == n.ID && n.Available {
+				r.node.NetworkInterface = nil
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = verr
+	}
+	return volumes, networkInterfaces, categorize(err)
+}
+
+// Reconcile runs a single reconcile pass.
+func (r *Reconciler) Reconcile(ctx context.Context) {
+	r.startPass()
+	defer r.finishPass()
+	ctx, s := r.tracer.start(ctx, "reconcile")
+	defer func() {
+		s.set("node_id", r.node.ID)
+		s.end(nil)
+		r.tracer.flush()
+	}()
+	r.setupScratch()
+	dctx, ds := startSpan(ctx, "discover")
+	volumes, networkInterfaces, err := r.discover(dctx)
+	ds.end(err)
+	r.recordError(err)
+	r.recordDiscovery(volumes, networkInterfaces)
+	r.refreshRegistry(ctx)
+
+	// If nothing is attached, then pick an available volume. We never want to
+	// attach a network interface if there is no volume attached first.
+	if r.node.Volume == nil && r.node.NetworkInterface == nil && !r.heldOff() {
+		log.Println("Neither a volume, nor a network interface are attached.")
+		candidates, sticky := r.stickyVolumes(r.selectVolumes(ctx, volumes))
+		for _, v := range candidates {
+			if r.claimNode(ctx, v.NodeID) {
+				// Release the claim if the volume could not be attached, so
+				// that other instances can pick up the node.
+				if err := r.attachVolume(ctx, v); err != nil {
+					r.releaseClaim(ctx, v.NodeID)
+				}
+				break
+			}
+		}
+		if r.node.Volume == nil {
+			log.Println("No available volumes found.")
+			if r.cfg.RelocateVolumes && !sticky {
+				r.relocateVolume(ctx, volumes, networkInterfaces)
+			}
+		}
+		if r.node.Volume != nil {
+			for _, n := range networkInterfaces {
+				if n.Available && r.node.Volume.NodeID == n.NodeID {
+					_ = r.attachNetworkInterface(ctx, n)
+					r.waitAndSetupIface(ctx, n)
+					break
+				}
+				log.Println("No available network interfaces found.")
+			}
+		} else {
+			log.Println("No volumes appear to be attached, skipping network interface attachment.")
+		}
+	}
+
+	// If volume is attached, but network interface is not, then find a
+	// matching available network interface and attach it. If we cannot find
+	// a matching network interface after AttachRetries tries, we release the
+	// volume unless its file system is in use.
+	if r.node.Volume != nil && r.node.NetworkInterface == nil {
+		if r.cfg.AttachRetries > 0 && r.interfaceAttachTries >= r.cfg.AttachRetries && !r.fsMounted() {
+			msg := fmt.Sprintf("unable to attach a matching network interface after %d retries", r.interfaceAttachTries)
+			log.Printf("Unable to attach a matching network interface after %d retries.\n", r.interfaceAttachTries)
+			r.publishEvent(ctx, eventAttachFailed, msg)
+			if err := r.detachVolume(ctx); err == nil {
+				r.interfaceAttachTries = 0
+			}
+		}
+		for _, n := range networkInterfaces {
+			if r.node.Volume == nil {
+				break
+			}
+			if n.Available && n.NodeID == r.node.Volume.NodeID {
+				if err := r.attachNetworkInterface(ctx, n); err == nil {
+					r.interfaceAttachTries = 0
+				}
+				r.waitAndSetupIface(ctx, n)
+				break
+			}
+		}
+		if r.node.Volume != nil && r.node.NetworkInterface == nil {
+			r.interfaceAttachTries++
+		}
+	}
+
+	// If network interface is attached, but volume is not, then find a
+	// matching available volume and attach it. If we cannot find a matching
+	// volume after AttachRetries tries, we release the network interface
+	// right away if the volume is held by another instance, and do not
+	// count tries while it is being detached.
+	if r.node.NetworkInterface != nil && r.node.Volume == nil {
+		if r.cfg.AttachRetries > 0 && r.volumeAttachTries >= r.cfg.AttachRetries {
+			msg := fmt.Sprintf("unable to attach a matching volume after %d retries", r.volumeAttachTries)
+			log.Printf("Unable to attach a matching volume after %d retries.\n", r.volumeAttachTries)
+			r.publishEvent(ctx, eventAttachFailed, msg)
+			r.runHook(ctx, "pre-detach", r.cfg.PreDetachHook)
+			if err := r.detachNetworkInterface(ctx); err == nil {
+				r.volumeAttachTries = 0
+			}
+		}
+		held, detaching := false, false
+		for _, v := range volumes {
+			if r.node.NetworkInterface == nil {
+				break
+			}
+			if v.Available && v.NodeID == r.node.NetworkInterface.NodeID && r.claimNode(ctx, v.NodeID) {
+				log.Printf("Found a matching volume %q with NodeID %q.\n", v.ID, v.NodeID)
+				err := r.attachVolume(ctx, v)
+				if err == nil {
+					r.volumeAttachTries = 0
+					break
+				}
+				r.releaseClaim(ctx, v.NodeID)
+				held = held || errors.Is(err, errVolumeHeld)
+				detaching = detaching || errors.Is(err, errVolumeDetaching)
+			}
+		}
+		switch {
+		case r.node.Volume != nil:
+		case held && r.cfg.AttachRetries > 0:
+			r.volumeAttachTries = r.cfg.AttachRetries
+		case !detaching:
+			r.volumeAttachTries++
+		}
+	}
+
+	r.completeNode(ctx)
+	r.checkVolumeRequirements(ctx)
+	r.tuneDevice()
+	r.warmUpVolume(ctx)
+	r.checkIOErrors(ctx)
+	r.checkVolumeStatus(ctx)
+	r.checkHealth(ctx)
+	r.snapshotVolume(ctx)
+	r.fastSnapshotRestore(ctx)
+	r.trimVolume(ctx)
+	r.modifyVolume(ctx)
+	r.updateConsulHealth(ctx)
+	r.pushMetrics(ctx)
+}
+
+// completeNode sets the node ID once both a volume and a network interface
+// are attached. If specified, it creates and mounts the file system.
+func (r *Reconciler) completeNode(ctx context.Context) {
+	// FIXME: below could be cleaned up with less if statements maybe
+	if r.node.Volume != nil && r.node.NetworkInterface != nil {
+		if r.node.Volume.NodeID == r.node.NetworkInterface.NodeID {
+			if r.node.ID != r.node.Volume.NodeID {
+				r.node.ID = r.node.Volume.NodeID
+				log.Printf("Node ID is %q.\n", r.node.ID)
+				r.updateRoutes(ctx)
+				r.writeEnvFile(r.cfg.EnvFile)
+				r.renderTemplates()
+				r.publishEvent(ctx, eventNodeIDAcquired, "")
+				r.metrics.acquired()
+				r.labelKubeNode(ctx)
+				r.registerClusterRecord(ctx)
+				// Record the network interface in the registry.
+				r.claimNode(ctx, r.node.ID)
+				r.registerConsul(ctx)
+				r.saveStickyNodeID()
+			}
+		}
+		// Set nodeID only when both volume and network interface are attached and their node IDs match.
+		if r.node.Volume.NodeID != r.node.NetworkInterface.NodeID {
+			log.Printf("Something has gone wrong, volume and network interface node IDs do not match.")
+		}
+		if r.cfg.CreateFs || r.cfg.MountFs {
+			err := traced(ctx, "wait for device", func() error {
+				return r.host.waitForDevice(r.blockDevice(), r.cfg.AttachTimeout)
+			}, "device", r.blockDevice())
+			if err != nil {
+				log.Printf("Skipping file system setup: %q.\n", err)
+				return
+			}
+			if err := r.verifyDevice(); err != nil {
+				log.Printf("Skipping file system setup, the block device is not the attached volume: %q.\n", err)
+				return
+			}
+			if r.cfg.Partition {
+				if err := r.ensurePartition(); err != nil {
+					log.Printf("Skipping file system setup: %q.\n", err)
+					return
+				}
+			}
+		}
+		if r.cfg.CreateFs {
+			if r.needsFs() {
+				if err := r.host.waitDeviceReady(r.fsDevice()); err != nil {
+					log.Printf("Skipping file system creation: %q.\n", err)
+					return
+				}
+				if err := r.guardMkfs(); err != nil {
+					log.Printf("Refusing to create a file system: %q.\n", err)
+					return
+				}
+				if err := traced(ctx, "create file system", r.createFs, "fs_type", r.fileSystemType()); err == nil {
+					r.relabel = true
+					if err := r.tuneFs(); err != nil {
+						log.Printf("Failed to tune the file system: %q.\n", err)
+					}
+				}
+			}
+		}
+		mounted := false
+		if r.cfg.MountFs {
+			if r.fsPresent() && !r.fsMounted() {
+				if err := r.host.waitDeviceReady(r.fsDevice()); err != nil {
+					log.Printf("Skipping mount: %q.\n", err)
+					return
+				}
+				if err := r.runHook(ctx, "pre-mount", r.cfg.PreMountHook); err == nil {
+					if err := traced(ctx, "mount", r.mountFs, "mount_point", r.mountPoint()); err == nil {
+						// A context mount option labels all files, so
+						// there is nothing to relabel then.
+						if r.relabel && r.cfg.SELinuxContext == "" && r.host.selinuxEnabled() {
+							r.host.relabel(r.mountPoint())
+						}
+						r.relabel = false
+						r.mountPerms.apply(r.host, r.mountPoint())
+						mounted = true
+					}
+				}
+			}
+		}
+		// These check the mount themselves and fill in what is missing on
+		// every pass, so the post-mount hook sees them in place.
+		r.createMountDirs()
+		r.reconcileMounts()
+		if r.node.Volume.NodeID == r.node.NetworkInterface.NodeID {
+			r.writeMyID()
+		}
+		if mounted {
+			r.runHook(ctx, "post-mount", r.cfg.PostMountHook)
+		}
+	}
+}
+
+// attachVolume attaches a volume v to the instance.
+func (r *Reconciler) attachVolume(ctx context.Context, v Volume) (err error) {
+	ctx, s := startSpan(ctx, "attach volume", "volume_id", v.ID, "node_id", v.NodeID)
+	defer func() { s.end(err) }()
+	log.Printf("Attaching volume: %q.\n", v.ID)
+	d := r.setting(v.NodeID, settingBlockDevice, r.cfg.BlockDevice)
+	if r.cfg.AutoDevice {
+		d = r.freeDevice(ctx, d)
+	}
+	if err := r.provider.AttachVolume(ctx, v, d); err != nil {
+		log.Printf("Failed to attach volume %q: %q.\n", v.ID, err)
+		if volumeInUse(err) {
+			if err = r.resolveVolumeInUse(ctx, v, d, err); err == nil {
+				r.metrics.attached()
+				r.publishEvent(ctx, eventVolumeAttached, "")
+				return nil
+			}
+		}
+		r.metrics.attachFailed()
+		r.recordError(err)
+		return categorize(err)
+	}
+	v.Device = d
+	r.metrics.attached()
+	r.node.Volume = &v
+	r.publishEvent(ctx, eventVolumeAttached, "")
+	return nil
+}
+
+// attachNetworkInterface attaches a network interface n to the instance.
+func (r *Reconciler) attachNetworkInterface(ctx context.Context, n NetworkInterface) (err error) {
+	ctx, s := startSpan(ctx, "attach network interface", "network_interface_id", n.ID, "node_id", n.NodeID)
+	defer func() { s.end(err) }()
+	log.Printf("Attaching network interface: %q.\n", n.ID)
+	if err := r.provider.AttachInterface(ctx, n); err != nil {
+		log.Printf("Failed to attach network interface %q: %q.\n", n.ID, err)
+		r.metrics.attachFailed()
+		r.recordError(err)
+		return categorize(err)
+	}
+	r.metrics.attached()
+	r.node.NetworkInterface = &n
+	r.publishEvent(ctx, eventNetworkInterfaceAttached, "")
+	return nil
+}
+
+// detachNetworkInterface detaches the network interface held by the node.
+// Callers run the pre-detach hook first.
+func (r *Reconciler) detachNetworkInterface(ctx context.Context) error {
+	n := r.node.NetworkInterface
+	r.deregisterConsul(ctx)
+	r.deregisterClusterRecord(ctx)
+	r.removeAliases(*n)
+	log.Printf("Detaching network interface: %q.\n", n.ID)
+	if err := r.provider.DetachInterface(ctx, *n); err != nil {
+		log.Printf("Failed to dettach network interface %q: %q.\n", n.ID, err)
+		return categorize(err)
+	}
+	r.publishEvent(ctx, eventNetworkInterfaceDetached, "")
+	r.node.NetworkInterface = nil
+	return nil
+}
+
+// detachVolume detaches the volume held by the node.
+func (r *Reconciler) detachVolume(ctx context.Context) error {
+	v := r.node.Volume
+	log.Printf("Detaching volume: %q.\n", v.ID)
+	if err := r.provider.DetachVolume(ctx, *v); err != nil {
+		log.Printf("Failed to detach volume %q: %q.\n", v.ID, err)
+		return categorize(err)
+	}
+	r.publishEvent(ctx, eventVolumeDetached, "")
+	r.releaseNode(ctx, v.NodeID)
+	r.node.Volume = nil
+	return nil
+}
+
+// mountOptions returns the options the file system is mounted with.
+func (r *Reconciler) mountOptions() []string {
+	var opts []string
+	if r.cfg.SELinuxContext != "" {
+		opts = append(opts, fmt.Sprintf("context=%q", r.cfg.SELinuxContext))
+	}
+	if r.cfg.Discard {
+		opts = append(opts, "discard")
+	}
+	return opts
+}
+
+// fsDevice returns the device the file system lives on: the first partition
+// of the block device with Partition, the block device itself otherwise.
+func (r *Reconciler) fsDevice() string {
+	if !r.cfg.Partition {
+		return r.blockDevice()
+	}
+	return partitionDevice(r.blockDevice(), 1)
+}
+
+// ensurePartition creates a GPT with a single partition spanning the block
+// device unless the partition exists already, and waits for it to appear. A
+// block device with existing signatures is only partitioned with ForceMkfs.
+func (r *Reconciler) ensurePartition() error {
+	d := r.fsDevice()
+	if _, err := os.Stat(r.host.path(d)); err == nil {
+		return nil
+	}
+	if err := r.host.waitDeviceReady(r.blockDevice()); err != nil {
+		return err
+	}
+	sigs, err := r.host.signatures(r.blockDevice())
+	if err != nil {
+		return err
+	}
+	if len(sigs) > 0 {
+		if !r.cfg.ForceMkfs {
+			return fmt.Errorf("%q has existing signatures %s, use -force-mkfs to overwrite them", r.blockDevice(), strings.Join(sigs, ", "))
+		}
+		if err := r.host.wipe(r.blockDevice()); err != nil {
+			return err
+		}
+	}
+	if err := r.host.partition(r.blockDevice()); err != nil {
+		return err
+	}
+	return r.host.waitForDevice(d, r.cfg.AttachTimeout)
+}
+
+// needsFs checks whether a file system should be created on the block device.
+// A different existing file system is only replaced with ForceMkfs, an
+// existing ZFS pool never is.
+func (r *Reconciler) needsFs() bool {
+	if !r.cfg.ForceMkfs || r.hasZFS() {
+		return !r.fsPresent()
+	}
+	t, err := r.host.fsType(r.fsDevice())
+	if err != nil {
+		log.Printf("Failed to read file system type of %q: %q.\n", r.fsDevice(), err)
+		return false
+	}
+	return t != r.fileSystemType()
+}
+
+// guardMkfs makes sure creating a file system does not destroy data. Any
+// existing file system, RAID, LVM or partition table signature is refused,
+// unless ForceMkfs is set, in which case the signatures are erased.
+func (r *Reconciler) guardMkfs() error {
+	sigs, err := r.host.signatures(r.fsDevice())
+	if err != nil {
+		return err
+	}
+	if len(sigs) == 0 {
+		return nil
+	}
+	if !r.cfg.ForceMkfs {
+		return fmt.Errorf("%q has existing signatures %s, use -force-mkfs to overwrite them", r.fsDevice(), strings.Join(sigs, ", "))
+	}
+	log.Printf("Overwriting existing signatures of %q: %s.\n", r.fsDevice(), strings.Join(sigs, ", "))
+	return r.host.wipe(r.fsDevice())
+}
@@ -0,0 +1,100 @@
+package smilodon
+
+import (
+	"log"
+	"reflect"
+)
+
+// parseOutputs parses the output file settings of config cfg: file
+// permissions and templates, including app presets.
+func parseOutputs(cfg Config) (map[string]filePerms, []outputTemplate, error) {
+	perms, err := parseFilePerms(cfg.FilePerms)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.EnvFileMode != "" || cfg.EnvFileOwner != "" {
+		p, ok := perms[cfg.EnvFile]
+		if !ok {
+			p = defaultFilePerms
+		}
+		if perms[cfg.EnvFile], err = parseEnvFilePerms(p, cfg.EnvFileMode, cfg.EnvFileOwner); err != nil {
+			return nil, nil, err
+		}
+	}
+	if _, err := formatEnv(nil, cfg.EnvFormat); err != nil {
+		return nil, nil, err
+	}
+	templates, err := parseTemplates(cfg.Templates, cfg.TemplateOutputs)
+	if err != nil {
+		return nil, nil, err
+	}
+	presets, err := parsePresets(cfg.AppPresets)
+	if err != nil {
+		return nil, nil, err
+	}
+	return perms, append(templates, presets...), nil
+}
+
+// Reload validates config cfg and passes it to the reconcile loop, which
+// applies its poll intervals, output file settings and hooks between passes.
+// Nothing is detached. Other settings only take effect on restart.
+func (r *Reconciler) Reload(cfg Config) error {
+	if _, _, err := parseOutputs(cfg); err != nil {
+		return err
+	}
+	// Only the latest config matters if the loop is busy.
+	select {
+	case <-r.reloads:
+	default:
+	}
+	r.reloads <- cfg
+	return nil
+}
+
+// outputs returns the paths of the environment file and rendered templates.
+func (r *Reconciler) outputs() []string {
+	files := []string{r.cfg.EnvFile}
+	for _, t := range r.templates {
+		files = append(files, t.output)
+	}
+	return files
+}
+
+// applyConfig applies the reloadable settings of config cfg and rewrites the
+// output files of a complete node.
+func (r *Reconciler) applyConfig(cfg Config) {
+	perms, templates, err := parseOutputs(cfg)
+	if err != nil {
+		log.Printf("Failed to reload configuration: %q.\n", err)
+		return
+	}
+	next := r.cfg
+	next.PollInterval = cfg.PollInterval
+	next.StablePollInterval = cfg.StablePollInterval
+	next.PollJitter = cfg.PollJitter
+	next.EnvFile = cfg.EnvFile
+	next.EnvFormat = cfg.EnvFormat
+	next.EnvPrefix = cfg.EnvPrefix
+	next.EnvFileMode = cfg.EnvFileMode
+	next.EnvFileOwner = cfg.EnvFileOwner
+	next.Templates = cfg.Templates
+	next.TemplateOutputs = cfg.TemplateOutputs
+	next.AppPresets = cfg.AppPresets
+	next.FilePerms = cfg.FilePerms
+	next.PreMountHook = cfg.PreMountHook
+	next.PostMountHook = cfg.PostMountHook
+	next.PreDetachHook = cfg.PreDetachHook
+	next.VolumeLostHook = cfg.VolumeLostHook
+	if !reflect.DeepEqual(next, cfg) {
+		log.Println("Some settings changed, which only take effect on restart.")
+	}
+	r.cfg = next
+	r.files.setPerms(perms)
+	r.templates = templates
+	r.files.prune(r.outputs())
+	log.Println("Reloaded poll intervals, output files and hooks.")
+	if r.Stable() {
+		r.writeEnvFile(r.cfg.EnvFile)
+		r.renderTemplates()
+	}
+}
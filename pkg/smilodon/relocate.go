@@ -0,0 +1,56 @@
+package smilodon
+
+import (
+	"context"
+	"log"
+)
+
+// volumeRelocator is implemented by providers which can recreate a volume in
+// the instance availability zone from a volume in another one.
+type volumeRelocator interface {
+	// DiscoverRemoteVolumes returns available volumes in other
+	// availability zones which can be relocated.
+	DiscoverRemoteVolumes(ctx context.Context) ([]Volume, error)
+	// RelocateVolume advances the relocation of volume v by one step. The
+	// relocated volume is returned by DiscoverVolumes once it is ready.
+	RelocateVolume(ctx context.Context, v Volume) error
+}
+
+// relocateVolume relocates a volume of a free node ID into the instance
+// availability zone, if there is no available volume in it. A node ID is free
+// if it has an available network interface but no volume in the
+// availability zone.
+func (r *Reconciler) relocateVolume(ctx context.Context, volumes []Volume, networkInterfaces []NetworkInterface) {
+	local := map[string]bool{}
+	for _, v := range volumes {
+		if v.Available {
+			return
+		}
+		local[v.NodeID] = true
+	}
+	p, ok := r.provider.(volumeRelocator)
+	if !ok {
+		log.Println("Volume relocation is not supported by the provider.")
+		return
+	}
+	remote, err := p.DiscoverRemoteVolumes(ctx)
+	if err != nil {
+		return
+	}
+	remote = r.normalizeVolumes(remote)
+	for _, n := range networkInterfaces {
+		if !n.Available || local[n.NodeID] {
+			continue
+		}
+		for _, v := range remote {
+			if v.NodeID != n.NodeID {
+				continue
+			}
+			log.Printf("Relocating volume %q of node %q.\n", v.ID, v.NodeID)
+			if err := p.RelocateVolume(ctx, v); err != nil {
+				log.Printf("Failed to relocate volume %q: %q.\n", v.ID, err)
+			}
+			return
+		}
+	}
+}
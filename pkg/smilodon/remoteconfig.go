@@ -0,0 +1,100 @@
+package smilodon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// LoadRemoteConfig returns flag values kept centrally in AWS, by flag name:
+// the SSM parameters under path ssmPrefix, named after the flag, for example
+// /smilodon/prod/poll-interval, and the keys of the JSON object in the Secrets
+// Manager secret secretID. Names may be prefixed with a profile name, for
+// example kafka/poll-interval. Secret values take precedence over parameters.
+// They are read with the region, credentials, role and debug setting of o,
+// and the region is read from the metadata service if o has none.
+func LoadRemoteConfig(ctx context.Context, ssmPrefix, secretID string, o AWSOptions) (map[string]string, error) {
+	if ssmPrefix == "" && secretID == "" {
+		return nil, nil
+	}
+	md := imds.New(imds.Options{})
+	region := o.Region
+	if region == "" {
+		r, err := md.GetRegion(ctx, &imds.GetRegionInput{})
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMetadata, err)
+		}
+		region = r.Region
+	}
+	sessionName := "smilodon"
+	if o.AssumeRoleARN != "" {
+		if id, err := metadataValue(ctx, md, "instance-id"); err == nil {
+			sessionName += "-" + id
+		}
+	}
+	cfg, err := o.clientConfig(ctx, region, sessionName)
+	if err != nil {
+		return nil, err
+	}
+	flags := map[string]string{}
+	if ssmPrefix != "" {
+		if err := loadParameters(ctx, ssm.NewFromConfig(cfg), ssmPrefix, flags); err != nil {
+			return nil, fmt.Errorf("failed to read SSM parameters under %q: %w", ssmPrefix, categorize(err))
+		}
+	}
+	if secretID != "" {
+		if err := loadSecret(ctx, secretsmanager.NewFromConfig(cfg), secretID, flags); err != nil {
+			return nil, fmt.Errorf("failed to read secret %q: %w", secretID, categorize(err))
+		}
+	}
+	return flags, nil
+}
+
+// loadParameters adds the decrypted SSM parameters under path p to flags.
+func loadParameters(ctx context.Context, c *ssm.Client, p string, flags map[string]string) error {
+	p = "/" + strings.Trim(p, "/")
+	in := &ssm.GetParametersByPathInput{
+		Path:           aws.String(p),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	}
+	for {
+		out, err := c.GetParametersByPath(ctx, in)
+		if err != nil {
+			return err
+		}
+		for _, param := range out.Parameters {
+			flags[strings.TrimPrefix(aws.ToString(param.Name), p+"/")] = aws.ToString(param.Value)
+		}
+		if aws.ToString(out.NextToken) == "" {
+			return nil
+		}
+		in.NextToken = out.NextToken
+	}
+}
+
+// loadSecret adds the keys of the JSON object in secret id to flags.
+func loadSecret(ctx context.Context, c *secretsmanager.Client, id string, flags map[string]string) error {
+	out, err := c.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(id)})
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(aws.ToString(out.SecretString)), &values); err != nil {
+		return fmt.Errorf("the secret is not a JSON object: %v", err)
+	}
+	for k, v := range values {
+		if s, ok := v.(string); ok {
+			flags[k] = s
+		} else {
+			flags[k] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
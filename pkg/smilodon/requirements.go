@@ -0,0 +1,139 @@
+package smilodon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Actions on volumes not meeting the performance requirements.
+const (
+	// volumeRequirementWarn reports the volume as under-provisioned.
+	volumeRequirementWarn = "warn"
+	// volumeRequirementModify modifies the volume to meet the requirements.
+	volumeRequirementModify = "modify"
+)
+
+// parseVolumeRequirementAction validates volume requirement action s.
+func parseVolumeRequirementAction(s string) error {
+	switch s {
+	case "", volumeRequirementWarn, volumeRequirementModify:
+		return nil
+	}
+	return fmt.Errorf("unknown volume requirement action %q", s)
+}
+
+// volumeDescriber is implemented by providers which can report the type and
+// performance of volumes.
+type volumeDescriber interface {
+	// DescribeVolume returns the type, size and performance of volume v.
+	// IOPS and throughput are zero if the volume type does not report them.
+	DescribeVolume(ctx context.Context, v Volume) (volumeSpec, error)
+}
+
+// volumeRequirements are the minimum performance and accepted types of the
+// volume of a node.
+type volumeRequirements struct {
+	Types      []string
+	IOPS       int64
+	Throughput int64
+}
+
+// volumeRequirements returns the performance requirements of the volume of
+// node ID id.
+func (r *Reconciler) volumeRequirements(id string) volumeRequirements {
+	var q volumeRequirements
+	if ts := r.setting(id, settingVolumeTypes, r.cfg.VolumeTypes); ts != "" {
+		q.Types = strings.Split(ts, ",")
+	}
+	q.IOPS, _ = strconv.ParseInt(r.setting(id, settingMinIOPS, strconv.FormatInt(r.cfg.MinVolumeIOPS, 10)), 10, 64)
+	q.Throughput, _ = strconv.ParseInt(r.setting(id, settingMinThroughput, strconv.FormatInt(r.cfg.MinVolumeThroughput, 10)), 10, 64)
+	return q
+}
+
+// acceptsType checks whether volume type t is accepted.
+func (q volumeRequirements) acceptsType(t string) bool {
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, e := range q.Types {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// unmet returns the spec modifying a volume of spec s to meet the
+// requirements, and descriptions of the unmet requirements.
+func (q volumeRequirements) unmet(s volumeSpec) (volumeSpec, []string) {
+	var fix volumeSpec
+	var unmet []string
+	if !q.acceptsType(s.Type) {
+		fix.Type = q.Types[0]
+		unmet = append(unmet, fmt.Sprintf("type %s is not one of %s", s.Type, strings.Join(q.Types, ", ")))
+	}
+	if q.IOPS > 0 && s.IOPS > 0 && s.IOPS < q.IOPS {
+		fix.IOPS = q.IOPS
+		unmet = append(unmet, fmt.Sprintf("%d IOPS are below %d", s.IOPS, q.IOPS))
+	}
+	if q.Throughput > 0 && s.Throughput > 0 && s.Throughput < q.Throughput {
+		fix.Throughput = q.Throughput
+		unmet = append(unmet, fmt.Sprintf("%d MiB/s are below %d MiB/s", s.Throughput, q.Throughput))
+	}
+	return fix, unmet
+}
+
+// checkVolumeRequirements checks the attached volume against its performance
+// requirements once, and reports it as under-provisioned or modifies it as
+// configured. After a modification, the volume is checked again on the next
+// pass until it meets them.
+func (r *Reconciler) checkVolumeRequirements(ctx context.Context) {
+	v := r.node.Volume
+	if v == nil {
+		r.requirementsChecked, r.underProvisioned = "", false
+		return
+	}
+	if r.requirementsChecked == v.ID {
+		return
+	}
+	q := r.volumeRequirements(v.NodeID)
+	if len(q.Types) == 0 && q.IOPS == 0 && q.Throughput == 0 {
+		r.requirementsChecked, r.underProvisioned = v.ID, false
+		return
+	}
+	p, ok := r.provider.(volumeDescriber)
+	if !ok {
+		log.Println("Checking volume performance requirements is not supported by the provider.")
+		r.requirementsChecked = v.ID
+		return
+	}
+	s, err := p.DescribeVolume(ctx, *v)
+	if err != nil {
+		log.Printf("Failed to check the performance of volume %q: %q.\n", v.ID, err)
+		return
+	}
+	fix, unmet := q.unmet(s)
+	r.underProvisioned = len(unmet) > 0
+	if !r.underProvisioned {
+		log.Printf("Volume %q meets its performance requirements.\n", v.ID)
+		r.requirementsChecked = v.ID
+		return
+	}
+	log.Printf("WARNING: Volume %q of node %q is under-provisioned: %s.\n", v.ID, v.NodeID, strings.Join(unmet, "; "))
+	if r.cfg.VolumeRequirementAction != volumeRequirementModify {
+		r.requirementsChecked = v.ID
+		return
+	}
+	m, ok := r.provider.(volumeModifier)
+	if !ok {
+		log.Println("Volume modification is not supported by the provider.")
+		r.requirementsChecked = v.ID
+		return
+	}
+	if _, err := m.ModifyVolume(ctx, *v, fix); err != nil {
+		log.Printf("Failed to modify volume %q: %q.\n", v.ID, err)
+	}
+}
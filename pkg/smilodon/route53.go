@@ -0,0 +1,154 @@
+package smilodon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// clusterRecord maintains a Route 53 record set holding the IP addresses of
+// all nodes, for example seeds.cluster.internal.
+type clusterRecord struct {
+	zone string
+	name string
+	ttl  int64
+	r53  *route53.Client
+}
+
+// newClusterRecord returns a clusterRecord of record name in hosted zone
+// zone with a client of config cfg, or nil if zone is empty.
+func newClusterRecord(zone, name string, ttl int64, cfg aws.Config) (*clusterRecord, error) {
+	if zone == "" {
+		return nil, nil
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no cluster record name given for hosted zone %q", zone)
+	}
+	return &clusterRecord{
+		zone: strings.TrimPrefix(zone, "/hostedzone/"),
+		name: strings.TrimSuffix(name, ".") + ".",
+		ttl:  ttl,
+		r53:  route53.NewFromConfig(cfg),
+	}, nil
+}
+
+// recordType returns the DNS record type of ip.
+func recordType(ip string) types.RRType {
+	if net.ParseIP(ip).To4() != nil {
+		return types.RRTypeA
+	}
+	return types.RRTypeAaaa
+}
+
+// get returns the record set of type t, which is nil if there is none.
+func (c *clusterRecord) get(ctx context.Context, t types.RRType) (*types.ResourceRecordSet, error) {
+	r, err := c.r53.ListResourceRecordSets(ctx, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(c.zone),
+		StartRecordName: aws.String(c.name),
+		StartRecordType: t,
+		MaxItems:        aws.Int32(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Record sets are listed from name and type on, which may be others.
+	for i, rs := range r.ResourceRecordSets {
+		if strings.EqualFold(aws.ToString(rs.Name), c.name) && rs.Type == t {
+			return &r.ResourceRecordSets[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// update adds ip to or removes it from the record set. The old record set is
+// deleted and the new one created in a single change batch, which fails if
+// another node changed the record set in between, in which case it is
+// retried.
+func (c *clusterRecord) update(ctx context.Context, ip string, add bool) error {
+	t := recordType(ip)
+	var err error
+	for tries := 0; tries < 5; tries++ {
+		var old *types.ResourceRecordSet
+		if old, err = c.get(ctx, t); err != nil {
+			return err
+		}
+		var values []types.ResourceRecord
+		found := false
+		if old != nil {
+			for _, v := range old.ResourceRecords {
+				if aws.ToString(v.Value) == ip {
+					found = true
+					if !add {
+						continue
+					}
+				}
+				values = append(values, v)
+			}
+		}
+		if found == add {
+			return nil
+		}
+		if add {
+			values = append(values, types.ResourceRecord{Value: aws.String(ip)})
+		}
+		var changes []types.Change
+		if old != nil && len(old.ResourceRecords) > 0 {
+			changes = append(changes, types.Change{Action: types.ChangeActionDelete, ResourceRecordSet: old})
+		}
+		if len(values) > 0 {
+			changes = append(changes, types.Change{
+				Action: types.ChangeActionCreate,
+				ResourceRecordSet: &types.ResourceRecordSet{
+					Name:            aws.String(c.name),
+					Type:            t,
+					TTL:             aws.Int64(c.ttl),
+					ResourceRecords: values,
+				},
+			})
+		}
+		_, err = c.r53.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+			HostedZoneId: aws.String(c.zone),
+			ChangeBatch:  &types.ChangeBatch{Changes: changes},
+		})
+		var e *types.InvalidChangeBatch
+		if !errors.As(err, &e) {
+			return err
+		}
+		log.Printf("Record set %q changed concurrently, retrying: %q.\n", c.name, err)
+	}
+	return err
+}
+
+// registerClusterRecord adds the IP address of the network interface held by
+// the node to the cluster record set, if enabled. Failures are logged and
+// otherwise ignored.
+func (r *Reconciler) registerClusterRecord(ctx context.Context) {
+	if r.cluster == nil || r.node.NetworkInterface == nil {
+		return
+	}
+	ip := r.node.NetworkInterface.IPAddress
+	log.Printf("Adding %q to record set %q.\n", ip, r.cluster.name)
+	if err := r.cluster.update(ctx, ip, true); err != nil {
+		log.Printf("Failed to add %q to record set %q: %q.\n", ip, r.cluster.name, err)
+	}
+}
+
+// deregisterClusterRecord removes the IP address of the network interface
+// held by the node from the cluster record set, if enabled.
+func (r *Reconciler) deregisterClusterRecord(ctx context.Context) {
+	if r.cluster == nil || r.node.NetworkInterface == nil {
+		return
+	}
+	ip := r.node.NetworkInterface.IPAddress
+	log.Printf("Removing %q from record set %q.\n", ip, r.cluster.name)
+	if err := r.cluster.update(ctx, ip, false); err != nil {
+		log.Printf("Failed to remove %q from record set %q: %q.\n", ip, r.cluster.name, err)
+	}
+}
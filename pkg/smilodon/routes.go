@@ -0,0 +1,30 @@
+package smilodon
+
+import (
+	"context"
+	"log"
+)
+
+// routeUpserter is implemented by providers which can point routes at a
+// network interface.
+type routeUpserter interface {
+	// UpsertRoutes creates or replaces routes to cidrs in route tables so
+	// that they target network interface n.
+	UpsertRoutes(ctx context.Context, n NetworkInterface, tables, cidrs []string) error
+}
+
+// updateRoutes points the configured routes at the network interface held by
+// the node. Routes held by a previous instance are moved over.
+func (r *Reconciler) updateRoutes(ctx context.Context) {
+	if len(r.cfg.RouteTables) == 0 || r.node.NetworkInterface == nil {
+		return
+	}
+	p, ok := r.provider.(routeUpserter)
+	if !ok {
+		log.Println("Route table management is not supported by the provider.")
+		return
+	}
+	if err := p.UpsertRoutes(ctx, *r.node.NetworkInterface, r.cfg.RouteTables, r.cfg.RouteCIDRs); err != nil {
+		log.Printf("Failed to update routes: %q.\n", err)
+	}
+}
@@ -0,0 +1,93 @@
+package smilodon
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// scratchRAIDDevice is the RAID 0 device striping multiple instance-store
+// disks.
+const scratchRAIDDevice = "/dev/md/smilodon-scratch"
+
+// instanceStoreModel is the model of NVMe instance-store disks on EC2.
+const instanceStoreModel = "Amazon EC2 NVMe Instance Storage"
+
+// instanceStoreDisks returns the NVMe instance-store disks of the instance.
+func (h host) instanceStoreDisks() []string {
+	models, _ := filepath.Glob(h.path("/sys/block/nvme*/device/model"))
+	var disks []string
+	for _, m := range models {
+		b, err := ioutil.ReadFile(m)
+		if err != nil || strings.TrimSpace(string(b)) != instanceStoreModel {
+			continue
+		}
+		disks = append(disks, "/dev/"+filepath.Base(filepath.Dir(filepath.Dir(m))))
+	}
+	sort.Strings(disks)
+	return disks
+}
+
+// stripe assembles the RAID 0 device of disks ds, creating it if the disks
+// are not RAID members yet.
+func (h host) stripe(ds []string) error {
+	if _, err := h.evalSymlinks(scratchRAIDDevice); err == nil {
+		return nil
+	}
+	args := []string{"--assemble", scratchRAIDDevice}
+	if fs, err := h.fsType(ds[0]); err != nil || fs != "linux_raid_member" {
+		log.Printf("Creating RAID 0 device %q of %q.\n", scratchRAIDDevice, ds)
+		args = []string{"--create", scratchRAIDDevice, "--run", "--level=0", "--raid-devices=" + strconv.Itoa(len(ds))}
+	}
+	o, err := h.command("/usr/sbin/mdadm", append(args, ds...)...).CombinedOutput()
+	if err != nil {
+		log.Printf("Failed to set up RAID 0 device %q: %q.\n", scratchRAIDDevice, string(o))
+		return err
+	}
+	return nil
+}
+
+// setupScratch formats and mounts the instance-store disks, striped if there
+// are several, to the scratch mount point. It is independent of the node and
+// done once.
+func (r *Reconciler) setupScratch() {
+	if !r.cfg.Scratch || r.scratchReady {
+		return
+	}
+	ds := r.cfg.ScratchDevices
+	if len(ds) == 0 {
+		ds = r.host.instanceStoreDisks()
+	}
+	if len(ds) == 0 {
+		log.Println("No instance-store disks found, skipping scratch setup.")
+		r.scratchReady = true
+		return
+	}
+	d := ds[0]
+	if len(ds) > 1 {
+		if err := r.host.stripe(ds); err != nil {
+			return
+		}
+		d = scratchRAIDDevice
+	}
+	// /proc/mounts lists the resolved device, for example /dev/md127.
+	dev, err := r.host.evalSymlinks(d)
+	if err != nil {
+		log.Printf("Skipping scratch setup: %q.\n", err)
+		return
+	}
+	if !r.host.isMounted(dev) {
+		if !r.host.hasFs(dev, r.cfg.ScratchFsType) {
+			if err := r.host.mkfs(dev, r.cfg.ScratchFsType, nil); err != nil {
+				return
+			}
+		}
+		if err := r.host.mount(dev, r.cfg.ScratchMountPoint, r.cfg.ScratchFsType, nil); err != nil {
+			return
+		}
+	}
+	r.scratchReady = true
+}
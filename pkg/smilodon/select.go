@@ -0,0 +1,112 @@
+package smilodon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+)
+
+// Node selection strategies, which decide the node ID an instance without one
+// adopts.
+const (
+	// nodeSelectionFirst picks the first available volume found.
+	nodeSelectionFirst = "first"
+	// nodeSelectionLowest picks the available volume of the lowest node ID.
+	nodeSelectionLowest = "lowest"
+	// nodeSelectionRandom picks a random available volume, so that instances
+	// started at the same time do not all race for the same node ID.
+	nodeSelectionRandom = "random"
+	// nodeSelectionPreferred picks the available volume of a preferred node
+	// ID, falling back to the lowest node ID.
+	nodeSelectionPreferred = "preferred"
+)
+
+// nodeIDHinter is implemented by providers which read a preferred node ID
+// from the instance.
+type nodeIDHinter interface {
+	// PreferredNodeID returns the raw node ID preferred by the instance, or
+	// an empty string if it has no preference.
+	PreferredNodeID(ctx context.Context) (string, error)
+}
+
+// parseNodeSelection checks node selection strategy s.
+func parseNodeSelection(s string) error {
+	switch s {
+	case nodeSelectionFirst, nodeSelectionLowest, nodeSelectionRandom, nodeSelectionPreferred, "":
+		return nil
+	}
+	return fmt.Errorf("unknown node selection strategy %q", s)
+}
+
+// lessNodeID compares node IDs a and b numerically if both are numbers, and
+// lexically otherwise.
+func lessNodeID(a, b string) bool {
+	na, aerr := strconv.ParseUint(a, 10, 64)
+	nb, berr := strconv.ParseUint(b, 10, 64)
+	if aerr == nil && berr == nil {
+		return na < nb
+	}
+	return a < b
+}
+
+// preferredNodeIDs returns the preferred node IDs: the configured ones
+// followed by the one read from the instance, if any.
+func (r *Reconciler) preferredNodeIDs(ctx context.Context) []string {
+	raw := append([]string(nil), r.cfg.PreferredNodeIDs...)
+	if h, ok := r.provider.(nodeIDHinter); ok {
+		id, err := h.PreferredNodeID(ctx)
+		if err != nil {
+			log.Printf("Failed to get the preferred node ID of the instance: %q.\n", err)
+		} else if id != "" {
+			raw = append(raw, id)
+		}
+	}
+	var ids []string
+	for _, s := range raw {
+		id, err := parseNodeID(s, r.cfg.NodeIDFormat)
+		if err != nil || id == "" {
+			log.Printf("Ignoring invalid preferred node ID %q.\n", s)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// selectVolumes returns the available volumes of vs in the order they are
+// tried to be attached, as set by the node selection strategy.
+func (r *Reconciler) selectVolumes(ctx context.Context, vs []Volume) []Volume {
+	var out []Volume
+	for _, v := range vs {
+		if v.Available {
+			out = append(out, v)
+		}
+	}
+	switch r.cfg.NodeSelection {
+	case nodeSelectionLowest:
+		sort.SliceStable(out, func(i, j int) bool { return lessNodeID(out[i].NodeID, out[j].NodeID) })
+	case nodeSelectionRandom:
+		r.rnd.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	case nodeSelectionPreferred:
+		rank := map[string]int{}
+		for i, id := range r.preferredNodeIDs(ctx) {
+			if _, ok := rank[id]; !ok {
+				rank[id] = i
+			}
+		}
+		sort.SliceStable(out, func(i, j int) bool {
+			ri, iok := rank[out[i].NodeID]
+			rj, jok := rank[out[j].NodeID]
+			switch {
+			case iok && jok:
+				return ri < rj
+			case iok != jok:
+				return iok
+			}
+			return lessNodeID(out[i].NodeID, out[j].NodeID)
+		})
+	}
+	return out
+}
@@ -0,0 +1,65 @@
+package smilodon
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mountDir is a directory created beneath the mount point, with the
+// ownership and mode applied to it.
+type mountDir struct {
+	path  string
+	perms mountPerms
+}
+
+// parseMountDirs parses directories ds of the form path[=mode[:user:group]],
+// with paths relative to the mount point, for example
+// 'kafka/logs=0750:kafka:kafka'.
+func parseMountDirs(ds []string) ([]mountDir, error) {
+	var dirs []mountDir
+	for _, d := range ds {
+		kv := strings.SplitN(d, "=", 2)
+		p := filepath.Clean(strings.TrimPrefix(kv[0], "/"))
+		if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+			return nil, fmt.Errorf("invalid mount directory %q", d)
+		}
+		var mode, owner string
+		if len(kv) == 2 {
+			parts := strings.SplitN(kv[1], ":", 2)
+			mode = parts[0]
+			if len(parts) == 2 {
+				owner = parts[1]
+			}
+		}
+		perms, err := parseMountPerms(owner, mode)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount directory %q: %v", d, err)
+		}
+		dirs = append(dirs, mountDir{p, perms})
+	}
+	return dirs, nil
+}
+
+// createMountDirs creates the configured directories missing beneath the
+// mounted file system. Existing directories are left as they are, so that
+// the application owns them once created.
+func (r *Reconciler) createMountDirs() {
+	if len(r.mountDirs) == 0 || !r.fsMounted() {
+		return
+	}
+	for _, d := range r.mountDirs {
+		p := filepath.Join(r.mountPoint(), d.path)
+		if _, err := os.Stat(r.host.path(p)); err == nil {
+			continue
+		}
+		log.Printf("Creating directory %q.\n", p)
+		if err := os.MkdirAll(r.host.path(p), 0755); err != nil {
+			log.Printf("Failed to create directory %q: %q.\n", p, err)
+			continue
+		}
+		d.perms.apply(r.host, p)
+	}
+}
@@ -0,0 +1,75 @@
+// Package smilodon manages attachment of volume and network interface pairs,
+// which together make up a stable node identity, to cloud instances.
+//
+// A Provider discovers and attaches cloud resources, while a Reconciler
+// periodically makes sure that the instance it runs on holds a complete Node:
+// a volume and a network interface tagged with the same node ID.
+package smilodon
+
+import "context"
+
+// Instance describes the instance smilodon runs on.
+type Instance struct {
+	ID     string `json:"id"`
+	VPC    string `json:"vpc"`
+	AZ     string `json:"availability_zone"`
+	Region string `json:"region"`
+}
+
+// Volume is a block storage volume tagged with a node ID.
+type Volume struct {
+	ID         string `json:"id"`
+	NodeID     string `json:"node_id"`
+	Available  bool   `json:"available"`
+	AttachedTo string `json:"attached_to,omitempty"`
+	// MultiAttach is set for volumes which can be attached to several
+	// instances at once.
+	MultiAttach bool `json:"multi_attach,omitempty"`
+	// Device is the device name the volume is attached to the instance as,
+	// if known.
+	Device string `json:"device,omitempty"`
+	// Snapshot is the snapshot the volume was restored from, if any, and
+	// WarmedUp is set once it has been read in full.
+	Snapshot string `json:"snapshot,omitempty"`
+	WarmedUp bool   `json:"warmed_up,omitempty"`
+}
+
+// NetworkInterface is a network interface tagged with a node ID.
+type NetworkInterface struct {
+	ID                   string   `json:"id"`
+	NodeID               string   `json:"node_id"`
+	Available            bool     `json:"available"`
+	AttachedTo           string   `json:"attached_to,omitempty"`
+	AttachmentID         string   `json:"attachment_id,omitempty"`
+	IPAddress            string   `json:"ip_address"`
+	MACAddress           string   `json:"mac_address,omitempty"`
+	SubnetCIDR           string   `json:"subnet_cidr,omitempty"`
+	SecondaryIPAddresses []string `json:"secondary_ip_addresses,omitempty"`
+}
+
+// Node is the identity held by an instance. The node ID is only set once both
+// the volume and the network interface are attached and their node IDs match.
+type Node struct {
+	ID               string            `json:"id"`
+	Volume           *Volume           `json:"volume"`
+	NetworkInterface *NetworkInterface `json:"network_interface"`
+}
+
+// Provider discovers and attaches volumes and network interfaces of a cloud
+// provider.
+type Provider interface {
+	// Metadata returns the instance smilodon runs on.
+	Metadata(ctx context.Context) (Instance, error)
+	// DiscoverVolumes returns candidate volumes.
+	DiscoverVolumes(ctx context.Context) ([]Volume, error)
+	// DiscoverInterfaces returns candidate network interfaces.
+	DiscoverInterfaces(ctx context.Context) ([]NetworkInterface, error)
+	// AttachVolume attaches volume v to the instance as block device d.
+	AttachVolume(ctx context.Context, v Volume, d string) error
+	// DetachVolume detaches volume v from the instance.
+	DetachVolume(ctx context.Context, v Volume) error
+	// AttachInterface attaches network interface n to the instance.
+	AttachInterface(ctx context.Context, n NetworkInterface) error
+	// DetachInterface detaches network interface n from the instance.
+	DetachInterface(ctx context.Context, n NetworkInterface) error
+}
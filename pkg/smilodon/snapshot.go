@@ -0,0 +1,76 @@
+package smilodon
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// snapshot is a scheduled snapshot of a volume.
+type snapshot struct {
+	ID        string
+	StartTime time.Time
+	Completed bool
+}
+
+// volumeSnapshotter is implemented by providers which can snapshot volumes.
+type volumeSnapshotter interface {
+	// Snapshots returns the scheduled snapshots of volume v.
+	Snapshots(ctx context.Context, v Volume) ([]snapshot, error)
+	// SnapshotVolume takes a scheduled snapshot of volume v.
+	SnapshotVolume(ctx context.Context, v Volume) error
+	// DeleteSnapshot deletes snapshot id.
+	DeleteSnapshot(ctx context.Context, id string) error
+}
+
+// snapshotVolume takes a snapshot of the volume held by the node once every
+// SnapshotInterval and deletes all but the last SnapshotRetain completed
+// snapshots.
+func (r *Reconciler) snapshotVolume(ctx context.Context) {
+	if r.cfg.SnapshotInterval == 0 || !r.Stable() {
+		return
+	}
+	if !r.lastSnapshot.IsZero() && time.Since(r.lastSnapshot) < r.cfg.SnapshotInterval {
+		return
+	}
+	p, ok := r.provider.(volumeSnapshotter)
+	if !ok {
+		log.Println("Volume snapshots are not supported by the provider.")
+		return
+	}
+	v := *r.node.Volume
+	ss, err := p.Snapshots(ctx, v)
+	if err != nil {
+		log.Printf("Failed to list snapshots of volume %q: %q.\n", v.ID, err)
+		return
+	}
+	sort.Slice(ss, func(i, j int) bool { return ss[i].StartTime.After(ss[j].StartTime) })
+	if len(ss) > 0 {
+		r.lastSnapshot = ss[0].StartTime
+	}
+	if r.lastSnapshot.IsZero() || time.Since(r.lastSnapshot) >= r.cfg.SnapshotInterval {
+		log.Printf("Taking a snapshot of volume %q.\n", v.ID)
+		if err := p.SnapshotVolume(ctx, v); err != nil {
+			log.Printf("Failed to snapshot volume %q: %q.\n", v.ID, err)
+			return
+		}
+		r.lastSnapshot = time.Now()
+	}
+	// Pending snapshots are neither counted nor deleted, so that the
+	// retained ones are always restorable.
+	kept := 0
+	for _, s := range ss {
+		if !s.Completed {
+			continue
+		}
+		if kept < r.cfg.SnapshotRetain {
+			kept++
+			continue
+		}
+		log.Printf("Deleting snapshot %q of volume %q.\n", s.ID, v.ID)
+		if err := p.DeleteSnapshot(ctx, s.ID); err != nil {
+			log.Printf("Failed to delete snapshot %q: %q.\n", s.ID, err)
+		}
+	}
+}
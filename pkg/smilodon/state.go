@@ -0,0 +1,143 @@
+package smilodon
+
+import (
+	"sync"
+	"time"
+)
+
+// State is a snapshot of the internal state of a Reconciler for debugging,
+// as of the last checkpoint of the reconcile loop.
+type State struct {
+	Instance Instance `json:"instance"`
+	Node     Node     `json:"node"`
+	Stable   bool     `json:"stable"`
+	// Paused is set while periodic passes are paused with the control API.
+	Paused bool `json:"paused"`
+	// Volumes and NetworkInterfaces are the candidates of the last discovery.
+	Volumes           []Volume           `json:"volumes"`
+	NetworkInterfaces []NetworkInterface `json:"network_interfaces"`
+
+	VolumeAttachTries    int       `json:"volume_attach_tries"`
+	InterfaceAttachTries int       `json:"interface_attach_tries"`
+	HealthFailures       int       `json:"health_failures"`
+	IOErrorPasses        int       `json:"io_error_passes"`
+	HoldOffUntil         time.Time `json:"hold_off_until,omitempty"`
+	StickyNodeID         string    `json:"sticky_node_id,omitempty"`
+	StickyUntil          time.Time `json:"sticky_until,omitempty"`
+	LastSnapshot         time.Time `json:"last_snapshot,omitempty"`
+	LastTrim             time.Time `json:"last_trim,omitempty"`
+	// WarmUpProgress is the percentage of the volume read by the running or
+	// last warm-up.
+	WarmUpProgress float64 `json:"warm_up_progress,omitempty"`
+	// VolumeStatus is the result of the latest volume status check.
+	VolumeStatus string `json:"volume_status,omitempty"`
+	// FastSnapshotRestores are the fast snapshot restore states of the
+	// latest snapshot by availability zone.
+	FastSnapshotRestores map[string]string `json:"fast_snapshot_restores,omitempty"`
+
+	// Passes counts reconcile passes started. InPass is set while a pass is
+	// running since PassStarted, which helps to spot stuck passes.
+	Passes           int           `json:"passes"`
+	InPass           bool          `json:"in_pass"`
+	PassStarted      time.Time     `json:"pass_started,omitempty"`
+	LastPassDuration time.Duration `json:"last_pass_duration"`
+	// LastError is the last error of discovering or attaching resources.
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+}
+
+// stateRecorder holds the State of a Reconciler, which is updated by the
+// reconcile loop and may be read concurrently.
+type stateRecorder struct {
+	mu    sync.Mutex
+	state State
+}
+
+// State returns the internal state of the reconciler as of the last
+// checkpoint. It is safe to call while the reconcile loop is running.
+func (r *Reconciler) State() State {
+	r.recorder.mu.Lock()
+	defer r.recorder.mu.Unlock()
+	s := r.recorder.state
+	s.Volumes = append([]Volume(nil), s.Volumes...)
+	s.NetworkInterfaces = append([]NetworkInterface(nil), s.NetworkInterfaces...)
+	return s
+}
+
+// startPass records the start of a reconcile pass.
+func (r *Reconciler) startPass() {
+	r.recorder.mu.Lock()
+	defer r.recorder.mu.Unlock()
+	r.recorder.state.Passes++
+	r.recorder.state.InPass = true
+	r.recorder.state.PassStarted = time.Now()
+}
+
+// recordDiscovery records candidate volumes vs and network interfaces ns.
+func (r *Reconciler) recordDiscovery(vs []Volume, ns []NetworkInterface) {
+	r.recorder.mu.Lock()
+	defer r.recorder.mu.Unlock()
+	r.recorder.state.Volumes = vs
+	r.recorder.state.NetworkInterfaces = ns
+	r.recordHeld()
+}
+
+// recordState records the state left by an operation outside of a pass.
+func (r *Reconciler) recordState() {
+	r.recorder.mu.Lock()
+	defer r.recorder.mu.Unlock()
+	r.recordHeld()
+}
+
+// recordError records err, if not nil.
+func (r *Reconciler) recordError(err error) {
+	if err == nil {
+		return
+	}
+	r.lastErr = err
+	r.recorder.mu.Lock()
+	defer r.recorder.mu.Unlock()
+	r.recorder.state.LastError = err.Error()
+	r.recorder.state.LastErrorTime = time.Now()
+}
+
+// finishPass records the end of a reconcile pass and the state it left.
+func (r *Reconciler) finishPass() {
+	r.recorder.mu.Lock()
+	defer r.recorder.mu.Unlock()
+	s := &r.recorder.state
+	s.InPass = false
+	s.LastPassDuration = time.Since(s.PassStarted)
+	r.recordHeld()
+}
+
+// recordHeld records a copy of the node, which the reconcile loop goes on
+// modifying, and the counters. The recorder must be locked.
+func (r *Reconciler) recordHeld() {
+	s := &r.recorder.state
+	s.Instance = r.instance
+	s.Stable = r.Stable()
+	s.Paused = r.paused
+	s.VolumeAttachTries = r.volumeAttachTries
+	s.InterfaceAttachTries = r.interfaceAttachTries
+	s.HealthFailures = r.healthFailures
+	s.IOErrorPasses = r.ioErrorPasses
+	s.HoldOffUntil = r.holdOffUntil
+	s.StickyNodeID = r.stickyNodeID
+	s.StickyUntil = r.stickyUntil
+	s.LastSnapshot = r.lastSnapshot
+	s.LastTrim = r.lastTrim
+	s.WarmUpProgress = r.warmUpProgress()
+	s.VolumeStatus = r.volumeStatus
+	s.FastSnapshotRestores = r.fsrState
+	s.Node = Node{ID: r.node.ID}
+	if v := r.node.Volume; v != nil {
+		c := *v
+		s.Node.Volume = &c
+	}
+	if n := r.node.NetworkInterface; n != nil {
+		c := *n
+		s.Node.NetworkInterface = &c
+	}
+	loggedNodes.set(r, s.Node)
+}
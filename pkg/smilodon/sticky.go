@@ -0,0 +1,77 @@
+package smilodon
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// loadStickyNodeID reads the node ID last held by the instance from the state
+// file, which is re-claimed in preference to other node IDs until the sticky
+// timeout passes.
+func (r *Reconciler) loadStickyNodeID() {
+	if r.cfg.StickyTimeout <= 0 || r.cfg.StateFile == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(r.host.path(r.cfg.StateFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read state file %q: %q.\n", r.cfg.StateFile, err)
+		}
+		return
+	}
+	id, err := parseNodeID(strings.TrimSpace(string(b)), r.cfg.NodeIDFormat)
+	if err != nil || id == "" {
+		log.Printf("Ignoring invalid node ID in state file %q.\n", r.cfg.StateFile)
+		return
+	}
+	r.stickyNodeID = id
+	r.stickyUntil = time.Now().Add(r.cfg.StickyTimeout)
+	log.Printf("Preferring last held node ID %q for %v.\n", id, r.cfg.StickyTimeout)
+}
+
+// stickyVolumes returns the volumes of available volumes vs to try, which
+// are only those of the last held node ID until the sticky timeout passes.
+// It returns whether other node IDs are held back.
+func (r *Reconciler) stickyVolumes(vs []Volume) ([]Volume, bool) {
+	if r.stickyNodeID == "" {
+		return vs, false
+	}
+	if time.Now().After(r.stickyUntil) {
+		log.Printf("Node ID %q did not become available in time, trying other node IDs.\n", r.stickyNodeID)
+		r.stickyNodeID = ""
+		return vs, false
+	}
+	for _, v := range vs {
+		if v.NodeID == r.stickyNodeID {
+			return []Volume{v}, true
+		}
+	}
+	log.Printf("Waiting for the volume of last held node ID %q to become available.\n", r.stickyNodeID)
+	return nil, true
+}
+
+// saveStickyNodeID records the acquired node ID in the state file and stops
+// preferring any previously held one.
+func (r *Reconciler) saveStickyNodeID() {
+	r.stickyNodeID = ""
+	if r.cfg.StickyTimeout <= 0 || r.cfg.StateFile == "" {
+		return
+	}
+	f := r.cfg.StateFile
+	data := []byte(r.node.ID + "\n")
+	if b, err := ioutil.ReadFile(r.host.path(f)); err == nil && bytes.Equal(b, data) {
+		return
+	}
+	if err := os.MkdirAll(r.host.path(path.Dir(f)), 0755); err != nil {
+		log.Printf("Failed to write state file %q: %q.\n", f, err)
+		return
+	}
+	if err := r.files.writeFile(f, data, r.files.permsFor(f)); err != nil {
+		log.Printf("Failed to write state file %q: %q.\n", f, err)
+	}
+}
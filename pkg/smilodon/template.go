@@ -0,0 +1,84 @@
+package smilodon
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"text/template"
+)
+
+// templateData is the data output templates are rendered with.
+type templateData struct {
+	NodeID             string
+	NumericNodeID      string
+	InstanceID         string
+	AvailabilityZone   string
+	Region             string
+	VpcID              string
+	VolumeID           string
+	NetworkInterfaceID string
+	IPAddress          string
+	BlockDevice        string
+	MountPoint         string
+}
+
+// templateData returns template data describing the node.
+func (r *Reconciler) templateData() templateData {
+	d := templateData{
+		NodeID:           r.node.ID,
+		InstanceID:       r.instance.ID,
+		AvailabilityZone: r.instance.AZ,
+		Region:           r.instance.Region,
+		VpcID:            r.instance.VPC,
+		BlockDevice:      r.blockDevice(),
+		MountPoint:       r.mountPoint(),
+	}
+	// NumericNodeID is left empty for node IDs without a number.
+	d.NumericNodeID, _ = parseNodeID(r.node.ID, nodeIDFormatNumeric)
+	if r.node.Volume != nil {
+		d.VolumeID = r.node.Volume.ID
+	}
+	if r.node.NetworkInterface != nil {
+		d.NetworkInterfaceID = r.node.NetworkInterface.ID
+		d.IPAddress = r.node.NetworkInterface.IPAddress
+	}
+	return d
+}
+
+// outputTemplate is a template rendered to a file.
+type outputTemplate struct {
+	tmpl   *template.Template
+	output string
+}
+
+// parseTemplates parses template files ts, each of which is rendered to the
+// output file at the same position in outs.
+func parseTemplates(ts, outs []string) ([]outputTemplate, error) {
+	if len(ts) != len(outs) {
+		return nil, fmt.Errorf("got %d templates, but %d template outputs", len(ts), len(outs))
+	}
+	var out []outputTemplate
+	for n, t := range ts {
+		tmpl, err := template.ParseFiles(t)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, outputTemplate{tmpl: tmpl, output: outs[n]})
+	}
+	return out, nil
+}
+
+// renderTemplates renders all templates with the node data.
+func (r *Reconciler) renderTemplates() {
+	d := r.templateData()
+	for _, t := range r.templates {
+		var b bytes.Buffer
+		if err := t.tmpl.Execute(&b, d); err != nil {
+			log.Printf("Failed to render template %q: %q.\n", t.tmpl.Name(), err)
+			continue
+		}
+		if err := r.files.write(t.output, b.Bytes()); err != nil {
+			log.Printf("Failed to write template output %q: %q.\n", t.output, err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package smilodon
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OTLP span status codes.
+const (
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+// otlpSpanKindInternal is the OTLP kind of all spans.
+const otlpSpanKindInternal = 1
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+// tracer records spans of reconcile passes and exports them to an
+// OpenTelemetry collector with OTLP over HTTP, JSON encoded. A nil tracer
+// records nothing.
+type tracer struct {
+	url      string
+	resource []otlpAttribute
+	client   *http.Client
+
+	mu    sync.Mutex
+	spans []otlpSpan
+}
+
+// newTracer returns a tracer exporting to the OTLP HTTP endpoint, for
+// example http://localhost:4318, of spans of instance i, or nil if endpoint
+// is empty.
+func newTracer(endpoint string, i Instance) *tracer {
+	if endpoint == "" {
+		return nil
+	}
+	return &tracer{
+		url: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		resource: attributes(
+			"service.name", "smilodon",
+			"host.id", i.ID,
+			"cloud.region", i.Region,
+			"cloud.availability_zone", i.AZ,
+		),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// attributes returns OTLP attributes of key and value pairs kvs.
+func attributes(kvs ...string) []otlpAttribute {
+	var as []otlpAttribute
+	for i := 0; i+1 < len(kvs); i += 2 {
+		as = append(as, otlpAttribute{kvs[i], otlpValue{kvs[i+1]}})
+	}
+	return as
+}
+
+// span is a span being recorded. A nil span records nothing.
+type span struct {
+	t     *tracer
+	s     otlpSpan
+	start time.Time
+}
+
+// spanKey is the context key of the current span.
+type spanKey struct{}
+
+// randomID returns a random hex ID of n bytes.
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newSpan starts a span named name of trace id with parent span parent.
+func (t *tracer) newSpan(ctx context.Context, name, id, parent string, kvs []string) (context.Context, *span) {
+	s := &span{t: t, start: time.Now()}
+	s.s = otlpSpan{
+		TraceID:      id,
+		SpanID:       randomID(8),
+		ParentSpanID: parent,
+		Name:         name,
+		Kind:         otlpSpanKindInternal,
+		Attributes:   attributes(kvs...),
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// start starts the root span of a new trace named name, with attributes of
+// key and value pairs kvs.
+func (t *tracer) start(ctx context.Context, name string, kvs ...string) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	return t.newSpan(ctx, name, randomID(16), "", kvs)
+}
+
+// startSpan starts a span named name as a child of the span of ctx, with
+// attributes of key and value pairs kvs. Nothing is recorded if ctx carries
+// no span.
+func startSpan(ctx context.Context, name string, kvs ...string) (context.Context, *span) {
+	parent, _ := ctx.Value(spanKey{}).(*span)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.t.newSpan(ctx, name, parent.s.TraceID, parent.s.SpanID, kvs)
+}
+
+// traced runs f in a span named name, with attributes of key and value
+// pairs kvs.
+func traced(ctx context.Context, name string, f func() error, kvs ...string) error {
+	_, s := startSpan(ctx, name, kvs...)
+	err := f()
+	s.end(err)
+	return err
+}
+
+// set adds attribute k with value v to the span.
+func (s *span) set(k, v string) {
+	if s != nil {
+		s.s.Attributes = append(s.s.Attributes, attributes(k, v)...)
+	}
+}
+
+// end ends the span, failed with err if not nil.
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	s.s.StartTimeUnixNano = strconv.FormatInt(s.start.UnixNano(), 10)
+	s.s.EndTimeUnixNano = strconv.FormatInt(time.Now().UnixNano(), 10)
+	s.s.Status = otlpStatus{Code: otlpStatusOK}
+	if err != nil {
+		s.s.Status = otlpStatus{Code: otlpStatusError, Message: err.Error()}
+	}
+	s.t.mu.Lock()
+	s.t.spans = append(s.t.spans, s.s)
+	s.t.mu.Unlock()
+}
+
+// flush exports the ended spans in the background. Failures are logged and
+// the spans dropped.
+func (t *tracer) flush() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	req := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": t.resource},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "smilodon"},
+				"spans": spans,
+			}},
+		}},
+	}
+	go func() {
+		if err := t.export(req); err != nil {
+			log.Printf("Failed to export %d spans: %q.\n", len(spans), err)
+		}
+	}()
+}
+
+// export posts OTLP request req to the collector.
+func (t *tracer) export(req interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Post(t.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
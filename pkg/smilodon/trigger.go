@@ -0,0 +1,70 @@
+package smilodon
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// triggerListener receives EventBridge events about volumes, network
+// interfaces and instances from an SQS queue.
+type triggerListener struct {
+	queue string
+	sqsc  *sqs.Client
+}
+
+// newTriggerListener returns a triggerListener of SQS queue URL queue with a
+// client of config cfg.
+func newTriggerListener(queue string, cfg aws.Config) *triggerListener {
+	return &triggerListener{
+		queue: queue,
+		sqsc:  sqs.NewFromConfig(cfg),
+	}
+}
+
+// listen long polls the queue until ctx is done and sends on c whenever
+// events were received. Received messages are deleted, so with a queue
+// shared by many instances, every event triggers a single instance, which is
+// enough to pick up released resources.
+func (l *triggerListener) listen(ctx context.Context, c chan<- struct{}) {
+	for ctx.Err() == nil {
+		r, err := l.sqsc.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(l.queue),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     20,
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to receive events from %q: %q.\n", l.queue, err)
+				time.Sleep(10 * time.Second)
+			}
+			continue
+		}
+		if len(r.Messages) == 0 {
+			continue
+		}
+		for _, m := range r.Messages {
+			var e struct {
+				DetailType string `json:"detail-type"`
+			}
+			if json.Unmarshal([]byte(aws.ToString(m.Body)), &e) == nil && e.DetailType != "" {
+				log.Printf("Received %q event.\n", e.DetailType)
+			}
+			_, err := l.sqsc.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(l.queue),
+				ReceiptHandle: m.ReceiptHandle,
+			})
+			if err != nil {
+				log.Printf("Failed to delete event from %q: %q.\n", l.queue, err)
+			}
+		}
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package smilodon
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// trimVolume discards the unused blocks of the mounted file system once every
+// TrimInterval, so that the volume learns about freed blocks. The trim runs
+// in the background, as it may take minutes on large file systems, and is
+// skipped while the previous one is still running.
+func (r *Reconciler) trimVolume(ctx context.Context) {
+	if r.cfg.TrimInterval == 0 || !r.Stable() || !r.cfg.MountFs || !r.fsMounted() {
+		return
+	}
+	if !r.lastTrim.IsZero() && time.Since(r.lastTrim) < r.cfg.TrimInterval {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&r.trimming, 0, 1) {
+		log.Println("Skipping trim, the previous one is still running.")
+		return
+	}
+	r.lastTrim = time.Now()
+	cmd := r.host.commandContext(ctx, "/usr/sbin/fstrim", "-v", r.mountPoint())
+	if r.hasZFS() {
+		// zpool trim starts trimming and returns right away.
+		_, name, err := r.host.zfsPool(r.host.zfsMember(r.fsDevice()))
+		if err != nil {
+			log.Printf("Failed to trim %q: %q.\n", r.mountPoint(), err)
+			atomic.StoreInt32(&r.trimming, 0)
+			return
+		}
+		cmd = r.host.commandContext(ctx, "/usr/sbin/zpool", "trim", name)
+	}
+	log.Printf("Trimming %q.\n", r.mountPoint())
+	go func() {
+		defer atomic.StoreInt32(&r.trimming, 0)
+		o, err := cmd.CombinedOutput()
+		if err != nil {
+			log.Printf("Failed to trim %q: %q: %q.\n", r.mountPoint(), err, strings.TrimSpace(string(o)))
+			return
+		}
+		log.Printf("Trimmed %q: %s.\n", r.mountPoint(), strings.TrimSpace(string(o)))
+	}()
+}
@@ -0,0 +1,74 @@
+package smilodon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// queueSetting is a sysfs queue attribute of a block device and its desired
+// value.
+type queueSetting struct {
+	name  string
+	value string
+}
+
+// queueSettings returns the configured queue settings of the block device.
+func (r *Reconciler) queueSettings() []queueSetting {
+	var qs []queueSetting
+	if r.cfg.IOScheduler != "" {
+		qs = append(qs, queueSetting{"scheduler", r.cfg.IOScheduler})
+	}
+	if r.cfg.ReadAheadKB > 0 {
+		qs = append(qs, queueSetting{"read_ahead_kb", strconv.Itoa(r.cfg.ReadAheadKB)})
+	}
+	if r.cfg.NrRequests > 0 {
+		qs = append(qs, queueSetting{"nr_requests", strconv.Itoa(r.cfg.NrRequests)})
+	}
+	return qs
+}
+
+// tuneDevice applies the configured queue settings to the block device of
+// the attached volume on every pass, as udev rules may race with the attach
+// or reset them.
+func (r *Reconciler) tuneDevice() {
+	qs := r.queueSettings()
+	if len(qs) == 0 || r.node.Volume == nil {
+		return
+	}
+	d, err := r.host.evalSymlinks(r.blockDevice())
+	if err != nil {
+		// The device has not appeared yet.
+		return
+	}
+	dir := r.host.path(filepath.Join("/sys/block", filepath.Base(d), "queue"))
+	for _, q := range qs {
+		if err := setQueue(dir, q); err != nil {
+			log.Printf("Failed to set %s of %q: %q.\n", q.name, d, err)
+		}
+	}
+}
+
+// setQueue sets queue attribute q in sysfs directory dir, unless it has the
+// desired value already. The scheduler file lists all schedulers with the
+// active one in brackets.
+func setQueue(dir string, q queueSetting) error {
+	f := filepath.Join(dir, q.name)
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		return err
+	}
+	cur := strings.TrimSpace(string(b))
+	if cur == q.value || strings.Contains(cur, "["+q.value+"]") {
+		return nil
+	}
+	if q.name == "scheduler" && !strings.Contains(" "+strings.NewReplacer("[", "", "]", "").Replace(cur)+" ", " "+q.value+" ") {
+		return fmt.Errorf("scheduler %q is not available: %s", q.value, cur)
+	}
+	log.Printf("Setting %s of %q to %s.\n", q.name, filepath.Base(filepath.Dir(dir)), q.value)
+	return ioutil.WriteFile(f, []byte(q.value), os.FileMode(0644))
+}
@@ -0,0 +1,57 @@
+package smilodon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// deviceIdentities returns the identities of block device d: the serial
+// number of NVMe devices, and the names of its /dev/disk/by-id symlinks.
+func (h host) deviceIdentities(d string) ([]string, error) {
+	dev, err := h.evalSymlinks(d)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if b, err := ioutil.ReadFile(h.path(filepath.Join("/sys/block", filepath.Base(dev), "device/serial"))); err == nil {
+		ids = append(ids, strings.TrimSpace(string(b)))
+	}
+	links, _ := filepath.Glob(h.path("/dev/disk/by-id/*"))
+	for _, l := range links {
+		if t, err := filepath.EvalSymlinks(l); err == nil && t == h.path(dev) {
+			ids = append(ids, filepath.Base(l))
+		}
+	}
+	return ids, nil
+}
+
+// verifyDevice checks that the block device is the attached EBS volume, as
+// device names are not guaranteed to match. EBS NVMe devices have the volume
+// ID without the dash as serial number, for example 'vol0123456789abcdef0'.
+// Devices without any identity, and volumes of other providers, are not
+// checked.
+func (r *Reconciler) verifyDevice() error {
+	v := r.node.Volume
+	if v == nil || !strings.HasPrefix(v.ID, "vol-") {
+		return nil
+	}
+	d := r.blockDevice()
+	ids, err := r.host.deviceIdentities(d)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		log.Printf("Cannot verify that %q is volume %q, it has no identity.\n", d, v.ID)
+		return nil
+	}
+	serial := strings.Replace(v.ID, "-", "", 1)
+	for _, id := range ids {
+		if strings.Contains(id, serial) || strings.Contains(id, v.ID) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not volume %q but %s", d, v.ID, strings.Join(ids, ", "))
+}
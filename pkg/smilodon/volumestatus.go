@@ -0,0 +1,99 @@
+package smilodon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Recovery paths of impaired volumes.
+const (
+	volumeRecoveryReattach = "reattach"
+	volumeRecoveryRestore  = "restore"
+)
+
+// Volume statuses reported by providers.
+const (
+	volumeStatusOK               = "ok"
+	volumeStatusImpaired         = "impaired"
+	volumeStatusInsufficientData = "insufficient-data"
+)
+
+// volumeStatusChecker is implemented by providers which report the status
+// checks of volumes.
+type volumeStatusChecker interface {
+	// VolumeStatus returns the status of volume v.
+	VolumeStatus(ctx context.Context, v Volume) (string, error)
+}
+
+// volumeRestorer is implemented by providers which can replace a volume with
+// one restored from its latest snapshot.
+type volumeRestorer interface {
+	// RestoreVolume creates a replacement of volume v, which is returned
+	// by DiscoverVolumes instead of v once it is ready.
+	RestoreVolume(ctx context.Context, v Volume) error
+}
+
+// parseVolumeRecovery checks the recovery path of impaired volumes.
+func parseVolumeRecovery(s string) error {
+	switch s {
+	case "", volumeRecoveryReattach, volumeRecoveryRestore:
+		return nil
+	}
+	return fmt.Errorf("unknown volume status recovery %q", s)
+}
+
+// checkVolumeStatus checks the status of the volume held by the node once
+// every VolumeStatusInterval. Status changes are logged and published as
+// events. Once the volume was impaired in VolumeStatusChecks checks in a
+// row, it is recovered with VolumeStatusRecovery, if set.
+func (r *Reconciler) checkVolumeStatus(ctx context.Context) {
+	if r.cfg.VolumeStatusInterval == 0 || r.node.Volume == nil {
+		return
+	}
+	if !r.lastVolumeStatus.IsZero() && time.Since(r.lastVolumeStatus) < r.cfg.VolumeStatusInterval {
+		return
+	}
+	r.lastVolumeStatus = time.Now()
+	p, ok := r.provider.(volumeStatusChecker)
+	if !ok {
+		log.Println("Volume status checks are not supported by the provider.")
+		return
+	}
+	v := *r.node.Volume
+	s, err := p.VolumeStatus(ctx, v)
+	if err != nil {
+		log.Printf("Failed to check the status of volume %q: %q.\n", v.ID, err)
+		return
+	}
+	if s != r.volumeStatus {
+		log.Printf("Volume %q status is %q.\n", v.ID, s)
+		if s != volumeStatusOK {
+			r.publishEvent(ctx, eventVolumeImpaired, "volume status "+s)
+		}
+	}
+	r.volumeStatus = s
+	if s != volumeStatusImpaired {
+		r.impairedChecks = 0
+		return
+	}
+	r.impairedChecks++
+	log.Printf("Volume %q is impaired (%d/%d checks).\n", v.ID, r.impairedChecks, r.cfg.VolumeStatusChecks)
+	if r.impairedChecks < r.cfg.VolumeStatusChecks || r.cfg.VolumeStatusRecovery == "" {
+		return
+	}
+	r.impairedChecks = 0
+	if r.cfg.VolumeStatusRecovery == volumeRecoveryRestore {
+		if p, ok := r.provider.(volumeRestorer); ok {
+			log.Printf("Restoring volume %q of node %q from its latest snapshot.\n", v.ID, v.NodeID)
+			if err := p.RestoreVolume(ctx, v); err != nil {
+				log.Printf("Failed to restore volume %q, attaching it again instead: %q.\n", v.ID, err)
+			}
+		} else {
+			log.Println("Volume restores are not supported by the provider, attaching the volume again instead.")
+		}
+	}
+	r.reattachVolume(ctx, v)
+	r.volumeStatus = ""
+}
@@ -0,0 +1,138 @@
+package smilodon
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// warmUpChunk is the size of the reads warming up a volume.
+const warmUpChunk = 1 << 20
+
+// volumeWarmUpMarker is implemented by providers which can record that a
+// volume restored from a snapshot has been warmed up.
+type volumeWarmUpMarker interface {
+	// MarkWarmedUp records that volume v has been read in full.
+	MarkWarmedUp(ctx context.Context, v Volume) error
+}
+
+// warmUpVolume reads the whole block device of a volume restored from a
+// snapshot in the background, so that its blocks are fetched before the
+// first reads by the application. Volumes not restored from a snapshot, or
+// already warmed up, are skipped.
+func (r *Reconciler) warmUpVolume(ctx context.Context) {
+	if !r.cfg.WarmUp || !r.Stable() {
+		return
+	}
+	v := *r.node.Volume
+	if v.Snapshot == "" || v.WarmedUp || v.ID == r.warmedUp {
+		return
+	}
+	p, ok := r.provider.(volumeWarmUpMarker)
+	if !ok {
+		log.Println("Volume warm-up is not supported by the provider.")
+		r.warmedUp = v.ID
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&r.warming, 0, 1) {
+		return
+	}
+	d, err := r.host.evalSymlinks(r.blockDevice())
+	if err != nil {
+		log.Printf("Failed to warm up volume %q: %q.\n", v.ID, err)
+		atomic.StoreInt32(&r.warming, 0)
+		return
+	}
+	readers, rate := r.cfg.WarmUpParallel, r.cfg.WarmUpRate
+	log.Printf("Warming up volume %q restored from snapshot %q on %q.\n", v.ID, v.Snapshot, d)
+	go func() {
+		defer atomic.StoreInt32(&r.warming, 0)
+		start := time.Now()
+		if err := r.readDevice(ctx, d, readers, rate); err != nil {
+			log.Printf("Failed to warm up volume %q: %q.\n", v.ID, err)
+			return
+		}
+		log.Printf("Warmed up volume %q in %s.\n", v.ID, time.Since(start).Round(time.Second))
+		if err := p.MarkWarmedUp(ctx, v); err != nil {
+			log.Printf("Failed to mark volume %q as warmed up: %q.\n", v.ID, err)
+		}
+	}()
+	// The reconcile loop does not wait for the warm-up, so it is only
+	// started once per volume, even if it fails.
+	r.warmedUp = v.ID
+}
+
+// readDevice reads block device d in full with readers parallel readers, at
+// no more than rate MiB/s if positive, and logs the progress every 10%.
+func (r *Reconciler) readDevice(ctx context.Context, d string, readers, rate int) error {
+	f, err := os.Open(r.host.path(d))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&r.warmUpSize, size)
+	atomic.StoreInt64(&r.warmUpDone, 0)
+	limiter := newRateLimiter(float64(rate), readers)
+	if readers < 1 {
+		readers = 1
+	}
+	var (
+		next int64
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := make([]byte, warmUpChunk)
+			for {
+				off := (atomic.AddInt64(&next, 1) - 1) * warmUpChunk
+				if off >= size {
+					return
+				}
+				if err := limiter.wait(ctx); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+					return
+				}
+				n, err := f.ReadAt(b, off)
+				if err != nil && err != io.EOF {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+					return
+				}
+				done := atomic.AddInt64(&r.warmUpDone, int64(n))
+				if step := size / 10; step > 0 && done/step != (done-int64(n))/step {
+					log.Printf("Warmed up %d%% of %q.\n", done*100/size, d)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// warmUpProgress returns the percentage of the volume read by the running or
+// last warm-up.
+func (r *Reconciler) warmUpProgress() float64 {
+	size := atomic.LoadInt64(&r.warmUpSize)
+	if size == 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&r.warmUpDone)) * 100 / float64(size)
+}
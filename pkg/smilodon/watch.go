@@ -0,0 +1,114 @@
+package smilodon
+
+import (
+	"bytes"
+	"log"
+	"path"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF
+
+// watcher watches directories of output files with inotify.
+type watcher struct {
+	mu    sync.Mutex
+	fd    int
+	dirs  map[int]string
+	wds   map[string]int
+	files *outputFiles
+}
+
+// watch starts watching output files for external modification or removal
+// and restores them when that happens.
+func (o *outputFiles) watch() error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	w := &watcher{
+		fd:    fd,
+		dirs:  map[int]string{},
+		wds:   map[string]int{},
+		files: o,
+	}
+	o.mu.Lock()
+	o.watcher = w
+	for f := range o.content {
+		w.add(path.Dir(f))
+	}
+	o.mu.Unlock()
+	go w.loop()
+	return nil
+}
+
+// add adds an inotify watch on directory dir.
+func (w *watcher) add(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.wds[dir]; ok {
+		return
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, w.files.host.path(dir), watchMask)
+	if err != nil {
+		log.Printf("Failed to watch directory %q: %q.\n", dir, err)
+		return
+	}
+	w.dirs[wd] = dir
+	w.wds[dir] = wd
+}
+
+// loop reads inotify events and restores affected output files.
+func (w *watcher) loop() {
+	var buf [syscall.SizeofInotifyEvent * 4096]byte
+	for {
+		n, err := syscall.Read(w.fd, buf[:])
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			log.Printf("Failed to read file watch events: %q.\n", err)
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			w.mu.Lock()
+			dir, ok := w.dirs[int(ev.Wd)]
+			if ok && ev.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, int(ev.Wd))
+				delete(w.wds, dir)
+			}
+			w.mu.Unlock()
+			if !ok {
+				continue
+			}
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				// The directory itself is gone, restore everything in it.
+				w.restoreDir(dir)
+				continue
+			}
+			w.files.restore(path.Join(dir, string(bytes.TrimRight(name, "\x00"))))
+		}
+	}
+}
+
+// restoreDir restores all output files in directory dir and watches it again.
+func (w *watcher) restoreDir(dir string) {
+	w.files.mu.Lock()
+	var files []string
+	for f := range w.files.content {
+		if path.Dir(f) == dir {
+			files = append(files, f)
+		}
+	}
+	w.files.mu.Unlock()
+	for _, f := range files {
+		w.files.restore(f)
+	}
+	w.add(dir)
+}
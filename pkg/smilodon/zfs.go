@@ -0,0 +1,186 @@
+package smilodon
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	fsZFS = "zfs"
+	// fsZFSMember is the type blkid reports for ZFS pool devices.
+	fsZFSMember = "zfs_member"
+)
+
+// zfsMember returns device d, or its first partition as created by ZFS for
+// whole disks, if it is a member of a ZFS pool, or an empty string.
+func (h host) zfsMember(d string) string {
+	for _, m := range []string{d, partitionDevice(d, 1)} {
+		if t, err := h.fsType(m); err == nil && t == fsZFSMember {
+			return m
+		}
+	}
+	return ""
+}
+
+// zfsPool returns the GUID and name of the ZFS pool of member device m, which
+// blkid reports as its UUID and label.
+func (h host) zfsPool(m string) (string, string, error) {
+	o, err := h.command("/usr/sbin/blkid", "-p", "-o", "export", m).Output()
+	if err != nil {
+		return "", "", err
+	}
+	var guid, name string
+	for _, l := range strings.Split(string(o), "\n") {
+		switch {
+		case strings.HasPrefix(l, "UUID="):
+			guid = strings.TrimPrefix(l, "UUID=")
+		case strings.HasPrefix(l, "LABEL="):
+			name = strings.TrimPrefix(l, "LABEL=")
+		}
+	}
+	if guid == "" || name == "" {
+		return "", "", fmt.Errorf("no ZFS pool found on %q", m)
+	}
+	return guid, name, nil
+}
+
+// zfs runs the ZFS command c, zpool or zfs, with args.
+func (h host) zfs(c string, args ...string) error {
+	o, err := h.command("/usr/sbin/"+c, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", c, strings.Join(args, " "), err, strings.TrimSpace(string(o)))
+	}
+	return nil
+}
+
+// zfsCreate creates ZFS pool name on device d with mount point p, passing
+// extra options opts to zpool create. The pool is not recorded in the cache
+// file, so that it is only ever imported by smilodon.
+func (h host) zfsCreate(d, name, p string, opts []string) error {
+	args := append([]string{"create", "-o", "cachefile=none", "-m", p}, opts...)
+	if err := h.zfs("zpool", append(args, name, d)...); err != nil {
+		log.Printf("Failed to create ZFS pool %q on %q: %q.\n", name, d, err)
+		return err
+	}
+	log.Printf("Successfully created ZFS pool %q on %q.\n", name, d)
+	return nil
+}
+
+// zfsImport imports the ZFS pool on device d by its GUID, which works even if
+// a pool of the same name is known, and mounts its datasets, creating the
+// missing ones of datasets, under mount point p. Pools are forcibly imported,
+// as the previous instance of a failed over node could not export them.
+func (h host) zfsImport(d, p string, datasets []string) error {
+	m := h.zfsMember(d)
+	if m == "" {
+		return fmt.Errorf("no ZFS pool found on %q", d)
+	}
+	guid, name, err := h.zfsPool(m)
+	if err != nil {
+		return err
+	}
+	if h.zfs("zpool", "list", "-H", "-o", "name", name) != nil {
+		log.Printf("Importing ZFS pool %q (%s).\n", name, guid)
+		if err := h.zfs("zpool", "import", "-f", "-N", "-o", "cachefile=none", guid); err != nil {
+			log.Printf("Failed to import ZFS pool %q: %q.\n", name, err)
+			return err
+		}
+	}
+	if err := h.zfs("zfs", "set", "mountpoint="+p, name); err != nil {
+		return err
+	}
+	for _, ds := range datasets {
+		if h.zfs("zfs", "list", "-H", name+"/"+ds) == nil {
+			continue
+		}
+		log.Printf("Creating ZFS dataset %q.\n", name+"/"+ds)
+		if err := h.zfs("zfs", "create", "-p", name+"/"+ds); err != nil {
+			return err
+		}
+	}
+	if err := h.zfs("zfs", "mount", "-a"); err != nil {
+		log.Printf("Failed to mount ZFS pool %q: %q.\n", name, err)
+		return err
+	}
+	log.Printf("Successfully mounted ZFS pool %q to %q.\n", name, p)
+	return nil
+}
+
+// zfsExport unmounts the datasets of the ZFS pool on device d and exports
+// it, so that it is imported cleanly elsewhere.
+func (h host) zfsExport(d string) error {
+	m := h.zfsMember(d)
+	if m == "" {
+		return fmt.Errorf("no ZFS pool found on %q", d)
+	}
+	_, name, err := h.zfsPool(m)
+	if err != nil {
+		return err
+	}
+	log.Printf("Exporting ZFS pool %q.\n", name)
+	return h.zfs("zpool", "export", name)
+}
+
+// zfsGrow expands the ZFS pool on device d to the size of the device.
+func (h host) zfsGrow(d string) error {
+	m := h.zfsMember(d)
+	if m == "" {
+		return fmt.Errorf("no ZFS pool found on %q", d)
+	}
+	_, name, err := h.zfsPool(m)
+	if err != nil {
+		return err
+	}
+	return h.zfs("zpool", "online", "-e", name, m)
+}
+
+// hasZFS reports whether the file system type is ZFS.
+func (r *Reconciler) hasZFS() bool {
+	return r.fileSystemType() == fsZFS
+}
+
+// fsPresent checks whether the block device has the configured file system,
+// or ZFS pool.
+func (r *Reconciler) fsPresent() bool {
+	if r.hasZFS() {
+		return r.host.zfsMember(r.fsDevice()) != ""
+	}
+	return r.host.hasFs(r.fsDevice(), r.fileSystemType())
+}
+
+// fsMounted checks whether the file system of the block device is mounted.
+// ZFS datasets are mounted by pool name, so the mount point is checked then.
+func (r *Reconciler) fsMounted() bool {
+	if r.hasZFS() {
+		return r.host.isMountPoint(r.mountPoint())
+	}
+	return r.host.isMounted(r.fsDevice())
+}
+
+// createFs creates the configured file system, or ZFS pool, on the block
+// device.
+func (r *Reconciler) createFs() error {
+	if r.hasZFS() {
+		return r.host.zfsCreate(r.fsDevice(), r.cfg.ZFSPool, r.mountPoint(), r.mkfsOptions())
+	}
+	return r.host.mkfs(r.fsDevice(), r.fileSystemType(), r.mkfsOptions())
+}
+
+// mountFs mounts the file system of the block device, or imports its ZFS
+// pool.
+func (r *Reconciler) mountFs() error {
+	if r.hasZFS() {
+		return r.host.zfsImport(r.fsDevice(), r.mountPoint(), r.cfg.ZFSDatasets)
+	}
+	return r.host.mount(r.fsDevice(), r.mountPoint(), r.fileSystemType(), r.mountOptions())
+}
+
+// unmountFs unmounts the file system of the block device, or exports its ZFS
+// pool.
+func (r *Reconciler) unmountFs() error {
+	if r.hasZFS() {
+		return r.host.zfsExport(r.fsDevice())
+	}
+	return r.host.unmount(r.mountPoint())
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/UKHomeOffice/smilodon/pkg/smilodon"
+)
+
+// capabilities are the Linux capabilities -capabilities accepts, by name.
+var capabilities = map[string]uintptr{
+	"chown":            0,
+	"dac_override":     1,
+	"fowner":           3,
+	"kill":             5,
+	"net_bind_service": 10,
+	"net_admin":        12,
+	"net_raw":          13,
+	"sys_chroot":       18,
+	"sys_ptrace":       19,
+	"sys_admin":        21,
+	"mknod":            27,
+}
+
+// defaultCapabilities returns the capabilities kept by -user without
+// -capabilities: sys_admin to mount and unmount, net_admin to configure the
+// attached interface, and net_raw to send gratuitous ARP if enabled by c.
+func defaultCapabilities(c smilodon.Config) string {
+	if c.GratuitousARP {
+		return "sys_admin,net_admin,net_raw"
+	}
+	return "sys_admin,net_admin"
+}
+
+// parseCapabilities parses comma-separated capability names cs, with or
+// without the CAP_ prefix, for example 'CAP_SYS_ADMIN,net_admin'.
+func parseCapabilities(cs string) ([]uintptr, error) {
+	var out []uintptr
+	for _, c := range strings.Split(cs, ",") {
+		name := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(c)), "cap_")
+		if name == "" {
+			continue
+		}
+		n, ok := capabilities[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown capability %q", c)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// lookupCredential returns the credential of user u, given by name or UID,
+// with the supplementary groups of the user.
+func lookupCredential(u string) (*syscall.Credential, error) {
+	usr, err := user.Lookup(u)
+	if err != nil {
+		if usr, err = user.LookupId(u); err != nil {
+			return nil, fmt.Errorf("unknown user %q", u)
+		}
+	}
+	uid, err := strconv.ParseUint(usr.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	if uid == 0 {
+		return nil, errors.New("-user must not be root")
+	}
+	gid, err := strconv.ParseUint(usr.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	c := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	gids, err := usr.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range gids {
+		if n, err := strconv.ParseUint(g, 10, 32); err == nil {
+			c.Groups = append(c.Groups, uint32(n))
+		}
+	}
+	return c, nil
+}
+
+// runUnprivileged runs smilodon again with the same arguments as user u,
+// keeping only capabilities caps, and returns its exit code. The signals
+// the daemon handles are forwarded to it. It is run by root, which does
+// nothing else, so that the reconcile loop, the provider API clients and
+// the control socket do not run with full privileges.
+func runUnprivileged(u, caps string) (int, error) {
+	c, err := lookupCredential(u)
+	if err != nil {
+		return 2, err
+	}
+	cs, err := parseCapabilities(caps)
+	if err != nil {
+		return 2, err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return 1, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: c, AmbientCaps: cs}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+	if err := cmd.Start(); err != nil {
+		return 1, err
+	}
+	log.Printf("Running as user %q with capabilities %q, pid %d.\n", u, caps, cmd.Process.Pid)
+	go func() {
+		for s := range sigs {
+			cmd.Process.Signal(s)
+		}
+	}()
+	err = cmd.Wait()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		if code := exit.ExitCode(); code >= 0 {
+			return code, nil
+		}
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/UKHomeOffice/smilodon/pkg/smilodon"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	for _, tc := range []struct {
+		cs      string
+		want    []uintptr
+		wantErr bool
+	}{
+		{cs: ""},
+		{cs: "sys_admin,net_admin", want: []uintptr{21, 12}},
+		{cs: "CAP_SYS_ADMIN, cap_net_raw,", want: []uintptr{21, 13}},
+		{cs: "Net_Admin", want: []uintptr{12}},
+		{cs: "sys_admin,sys_module", wantErr: true},
+		{cs: "cap_", want: nil},
+	} {
+		got, err := parseCapabilities(tc.cs)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseCapabilities(%q) error = %v, want error %t", tc.cs, err, tc.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseCapabilities(%q) = %v, want %v", tc.cs, got, tc.want)
+		}
+	}
+}
+
+func TestDefaultCapabilities(t *testing.T) {
+	c := smilodon.DefaultConfig()
+	c.GratuitousARP = true
+	if got, want := defaultCapabilities(c), "sys_admin,net_admin,net_raw"; got != want {
+		t.Errorf("defaultCapabilities() with gratuitous ARP = %q, want %q", got, want)
+	}
+	c.GratuitousARP = false
+	if got, want := defaultCapabilities(c), "sys_admin,net_admin"; got != want {
+		t.Errorf("defaultCapabilities() without gratuitous ARP = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/UKHomeOffice/smilodon/pkg/smilodon"
+)
+
+// daemon is a reconciler run by the run command with the options of its
+// profile, which is empty without profiles.
+type daemon struct {
+	profile string
+	r       *smilodon.Reconciler
+	opts    cmdLineOpts
+}
+
+// runProfiles runs a reconciler for each of profiles in this process.
+// Profiles must not share a block device, mount point, environment file or
+// state file.
+func runProfiles(ctx context.Context, profiles []string) error {
+	var ds []daemon
+	used := map[string]string{}
+	for _, name := range profiles {
+		if err := reloadConfig(name); err != nil {
+			return err
+		}
+		paths := map[string]string{"block-device": cfg.BlockDevice, "env-file": cfg.EnvFile}
+		if cfg.MountFs {
+			paths["mount-point"] = cfg.MountPoint
+		}
+		if cfg.StickyTimeout > 0 {
+			paths["state-file"] = cfg.StateFile
+		}
+		for f, p := range paths {
+			if p == "" {
+				continue
+			}
+			if other, ok := used[f+"="+p]; ok {
+				return fmt.Errorf("profiles %q and %q share -%s=%s", other, name, f, p)
+			}
+			used[f+"="+p] = name
+		}
+		p, err := newProvider(opts.provider, opts.filters, awsOpts)
+		if err != nil {
+			return fmt.Errorf("profile %q: %w", name, err)
+		}
+		r, err := smilodon.NewReconciler(ctx, cfg, p)
+		if err != nil {
+			return fmt.Errorf("profile %q: %w", name, err)
+		}
+		ds = append(ds, daemon{name, r, opts})
+	}
+	return runDaemons(ctx, ds)
+}
+
+// runDaemons runs the reconcile loops of ds until ctx is done or one of them
+// fails, which stops the others.
+func runDaemons(ctx context.Context, ds []daemon) error {
+	if opts.pidFile != "" {
+		if err := writePidFile(opts.pidFile); err != nil {
+			return err
+		}
+		defer os.Remove(opts.pidFile)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for _, d := range ds {
+		if d.opts.preflight {
+			if err := preflight(ctx, d.r, false); err != nil {
+				return err
+			}
+		}
+		if p, ok := d.r.Provider().(*smilodon.AWSProvider); ok && d.opts.disableSourceDestCheck {
+			p.DisableSourceDestCheck(ctx)
+			if d.opts.restoreSourceDestCheck {
+				// ctx is cancelled on shutdown, so restore with a fresh one.
+				defer func() {
+					ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+					defer cancel()
+					p.RestoreSourceDestCheck(ctx)
+				}()
+			}
+		}
+		if d.opts.socket != "" {
+			go func(d daemon) {
+				if err := d.r.ServeControl(ctx, d.opts.socket); err != nil {
+					log.Printf("Failed to serve the control API: %q.\n", err)
+				}
+			}(d)
+		}
+	}
+	if opts.pprofPort > 0 {
+		go func() {
+			if err := servePprof(ctx, opts.pprofPort); err != nil {
+				log.Printf("Failed to serve pprof profiles: %q.\n", err)
+			}
+		}()
+	}
+	go reloadOnHangup(ctx, ds)
+	go dumpOnSignal(ctx, ds)
+	errs := make(chan error, len(ds))
+	for _, d := range ds {
+		go func(d daemon) {
+			err := d.r.Run(ctx)
+			if err != nil && d.profile != "" {
+				err = fmt.Errorf("profile %q: %w", d.profile, err)
+			}
+			cancel()
+			errs <- err
+		}(d)
+	}
+	var err error
+	for range ds {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// contains checks whether ss contains s.
+func contains(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/UKHomeOffice/smilodon/pkg/smilodon"
+)
+
+const serviceUnit = `[Unit]
+Description=smilodon node identity daemon
+Wants=network-online.target
+After=network-online.target%s
+
+[Service]
+ExecStart=%s
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+`
+
+const bootstrapUnit = `[Unit]
+Description=smilodon node identity bootstrap
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=%s
+
+[Install]
+WantedBy=%s.target
+`
+
+const readyTarget = `[Unit]
+Description=smilodon node identity ready
+Requires=%[1]s-bootstrap.service
+After=%[1]s-bootstrap.service
+
+[Install]
+WantedBy=multi-user.target
+`
+
+func installUnitCmd(ctx context.Context, r *smilodon.Reconciler, args []string) error {
+	fs := flag.NewFlagSet("install-unit", flag.ExitOnError)
+	dir := fs.String("dir", "/etc/systemd/system", "directory the units are installed to")
+	name := fs.String("name", "smilodon", "name of the service unit")
+	ready := fs.Bool("ready-target", false, "also install <name>-bootstrap.service and <name>-ready.target, which services can be ordered after to start once the node is ready")
+	dryRun := fs.Bool("dry-run", false, "print the units instead of installing them")
+	fs.Parse(args)
+
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// The options given before the command are passed on as they are, so
+	// that a config file is still read and reloaded by the service.
+	cmdLine := []string{bin}
+	cmdLine = append(cmdLine, os.Args[1:len(os.Args)-flag.NArg()]...)
+	units := make(map[string]string)
+	var after string
+	if *ready {
+		after = fmt.Sprintf(" %s-bootstrap.service", *name)
+		units[*name+"-bootstrap.service"] = fmt.Sprintf(bootstrapUnit, execLine(cmdLine, "bootstrap"), *name+"-ready")
+		units[*name+"-ready.target"] = fmt.Sprintf(readyTarget, *name)
+	}
+	units[*name+".service"] = fmt.Sprintf(serviceUnit, after, execLine(cmdLine, "run"))
+
+	for _, u := range unitNames(*name, *ready) {
+		if *dryRun {
+			fmt.Printf("# %s\n%s\n", filepath.Join(*dir, u), units[u])
+			continue
+		}
+		f := filepath.Join(*dir, u)
+		if err := ioutil.WriteFile(f, []byte(units[u]), 0644); err != nil {
+			return err
+		}
+		log.Printf("Installed %q.\n", f)
+	}
+	if *dryRun {
+		return nil
+	}
+	if o, err := exec.CommandContext(ctx, "systemctl", "daemon-reload").CombinedOutput(); err != nil {
+		return fmt.Errorf("systemctl daemon-reload: %v: %s", err, strings.TrimSpace(string(o)))
+	}
+	return nil
+}
+
+// unitNames returns the names of the units installed for service name, in
+// the order they are written.
+func unitNames(name string, ready bool) []string {
+	if ready {
+		return []string{name + "-bootstrap.service", name + "-ready.target", name + ".service"}
+	}
+	return []string{name + ".service"}
+}
+
+// execLine returns the ExecStart line running command with arguments args,
+// quoted for systemd.
+func execLine(args []string, command string) string {
+	var qs []string
+	for _, a := range append(args, command) {
+		qs = append(qs, systemdQuote(a))
+	}
+	return strings.Join(qs, " ")
+}
+
+// systemdQuote quotes argument a for a systemd command line, escaping
+// specifiers and variable expansion.
+func systemdQuote(a string) string {
+	a = strings.NewReplacer("%", "%%", "$", "$$").Replace(a)
+	if a != "" && !strings.ContainsAny(a, " \t\"'\\;") {
+		return a
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(a) + `"`
+}